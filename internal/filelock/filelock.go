@@ -0,0 +1,42 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+// Package filelock provides the lock that is held while writing files that concurrent provider processes
+// share, such as the .gltform file and the token cache entries
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+const (
+	// filePermissions are the permissions of the lock file
+	filePermissions = 0o600
+	// retryInterval is the interval between attempts to take the lock
+	retryInterval = 20 * time.Millisecond
+)
+
+// Lock takes an exclusive lock on the file path, creating it if it doesn't exist, and waits for up to timeout.
+// The lock is an OS file lock, so it is released if the process holding it dies and there are no stale locks
+// to remove.  The lock file is left in place when the lock is released, removing it would let another process
+// lock a new file at path while the old one is still locked.  The returned function releases the lock.
+func Lock(path string, timeout time.Duration) (func(), error) {
+	f := flock.New(path, flock.SetPermissions(filePermissions))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	locked, err := f.TryLockContext(ctx, retryInterval)
+	if errors.Is(err, context.DeadlineExceeded) || (err == nil && !locked) {
+		return nil, fmt.Errorf("timed out waiting for lock %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error taking lock %s: %w", path, err)
+	}
+
+	return func() { _ = f.Unlock() }, nil
+}
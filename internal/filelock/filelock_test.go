@@ -0,0 +1,31 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package filelock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "file.lock")
+
+	unlock, err := Lock(path, time.Second)
+	require.NoError(t, err)
+
+	// The lock is exclusive
+	_, err = Lock(path, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out waiting for lock")
+
+	// It can be taken again once it has been released, the lock file is left in place
+	unlock()
+	unlock, err = Lock(path, time.Second)
+	require.NoError(t, err)
+	unlock()
+	assert.FileExists(t, path)
+}
@@ -3,8 +3,6 @@
 package client
 
 import (
-	"fmt"
-
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
@@ -23,12 +21,7 @@ type Initialisation interface {
 // This function takes the schema.ResourceData passed in to NewClient, gets the *schema.Set
 // at the key passed in, converts to a list which we know will have just one element,
 // gets that element and converts to map[string]interface{}.  This map holds the
-// settings for the service.  If the block hasn't been set we return an error.
+// settings for the service.  If the block hasn't been set we return an error, see also DecodeServiceSettings.
 func GetServiceSettingsMap(key string, r *schema.ResourceData) (map[string]interface{}, error) {
-	l := r.Get(key).(*schema.Set).List()
-	if len(l) == 0 {
-		return nil, fmt.Errorf("service %s block not defined in hpegl stanza", key)
-	}
-
-	return l[0].(map[string]interface{}), nil
+	return serviceSettings(key, r)
 }
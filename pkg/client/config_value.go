@@ -0,0 +1,129 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// ConfigValue is a provider config held as a tftypes.Value object, e.g. the Raw field of a
+// terraform-plugin-framework tfsdk.Config, or the Config of a tfprotov6.ConfigureProviderRequest.  It implements
+// Get, so it can be passed to DecodeServiceSettings:
+//
+//	config, err := client.NewConfigValue(req.Config.Raw)
+//	...
+//	settings, err := client.DecodeServiceSettings[Settings]("svc", config)
+//
+// Null and unknown values are nil, and so are missing.  Numbers are int64 if they are integers, otherwise float64.
+type ConfigValue struct {
+	attributes map[string]interface{}
+}
+
+// NewConfigValue returns the ConfigValue of v, which must be an object
+func NewConfigValue(v tftypes.Value) (*ConfigValue, error) {
+	if _, ok := v.Type().(tftypes.Object); !ok {
+		return nil, fmt.Errorf("config must be an object, not %s", v.Type())
+	}
+
+	raw, err := fromTFValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, _ := raw.(map[string]interface{})
+
+	return &ConfigValue{attributes: attributes}, nil
+}
+
+// NewConfigValueV6 returns the ConfigValue of the config dv, typ is the value type of the provider schema,
+// e.g. from (*tfprotov6.Schema).ValueType()
+func NewConfigValueV6(dv *tfprotov6.DynamicValue, typ tftypes.Type) (*ConfigValue, error) {
+	if dv == nil {
+		return nil, fmt.Errorf("config is not set")
+	}
+
+	v, err := dv.Unmarshal(typ)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %w", err)
+	}
+
+	return NewConfigValue(v)
+}
+
+// Get returns the value of the attribute or block key, nil if it is null, unknown or not in the config
+func (c *ConfigValue) Get(key string) interface{} {
+	return c.attributes[key]
+}
+
+// fromTFValue converts v to the values that DecodeSettings handles: nil, string, bool, int64, float64,
+// []interface{} and map[string]interface{}
+//
+//nolint:gocyclo
+func fromTFValue(v tftypes.Value) (interface{}, error) {
+	if v.IsNull() || !v.IsKnown() {
+		return nil, nil
+	}
+
+	switch typ := v.Type(); {
+	case typ.Is(tftypes.String):
+		var s string
+		err := v.As(&s)
+
+		return s, err
+	case typ.Is(tftypes.Bool):
+		var b bool
+		err := v.As(&b)
+
+		return b, err
+	case typ.Is(tftypes.Number):
+		n := new(big.Float)
+		if err := v.As(&n); err != nil {
+			return nil, err
+		}
+		if i, accuracy := n.Int64(); n.IsInt() && accuracy == big.Exact {
+			return i, nil
+		}
+		f, _ := n.Float64()
+
+		return f, nil
+	}
+
+	switch v.Type().(type) {
+	case tftypes.List, tftypes.Set, tftypes.Tuple:
+		var elems []tftypes.Value
+		if err := v.As(&elems); err != nil {
+			return nil, err
+		}
+		l := make([]interface{}, len(elems))
+		for i, e := range elems {
+			raw, err := fromTFValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			l[i] = raw
+		}
+
+		return l, nil
+	case tftypes.Map, tftypes.Object:
+		var elems map[string]tftypes.Value
+		if err := v.As(&elems); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(elems))
+		for k, e := range elems {
+			raw, err := fromTFValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = raw
+		}
+
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", v.Type())
+	}
+}
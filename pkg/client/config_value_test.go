@@ -0,0 +1,133 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testNetworkType = tftypes.Object{AttributeTypes: map[string]tftypes.Type{
+		"name": tftypes.String,
+		"vlan": tftypes.Number,
+	}}
+	testBlockType = tftypes.Object{AttributeTypes: map[string]tftypes.Type{
+		"location":   tftypes.String,
+		"space_name": tftypes.String,
+		"insecure":   tftypes.Bool,
+		"retries":    tftypes.Number,
+		"timeout":    tftypes.String,
+		"ratio":      tftypes.Number,
+		"network":    tftypes.List{ElementType: testNetworkType},
+		"tags":       tftypes.Map{ElementType: tftypes.String},
+		"proxy":      tftypes.List{ElementType: testNetworkType},
+		"zones":      tftypes.Set{ElementType: tftypes.String},
+	}}
+	testConfigType = tftypes.Object{AttributeTypes: map[string]tftypes.Type{
+		"region": tftypes.String,
+		"svc":    tftypes.List{ElementType: testBlockType},
+	}}
+)
+
+func testNetworkValue(name string, vlan interface{}) tftypes.Value {
+	return tftypes.NewValue(testNetworkType, map[string]tftypes.Value{
+		"name": tftypes.NewValue(tftypes.String, name),
+		"vlan": tftypes.NewValue(tftypes.Number, vlan),
+	})
+}
+
+// testConfigValue returns a provider config with one svc block, as a framework provider would receive it
+func testConfigValue() tftypes.Value {
+	block := tftypes.NewValue(testBlockType, map[string]tftypes.Value{
+		"location":   tftypes.NewValue(tftypes.String, "us-west"),
+		"space_name": tftypes.NewValue(tftypes.String, nil),
+		"insecure":   tftypes.NewValue(tftypes.Bool, nil),
+		"retries":    tftypes.NewValue(tftypes.Number, tftypes.UnknownValue),
+		"timeout":    tftypes.NewValue(tftypes.String, "1m"),
+		"ratio":      tftypes.NewValue(tftypes.Number, big.NewFloat(0.5)),
+		"network": tftypes.NewValue(tftypes.List{ElementType: testNetworkType}, []tftypes.Value{
+			testNetworkValue("net-a", big.NewFloat(10)),
+			testNetworkValue("net-b", nil),
+		}),
+		"tags": tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, map[string]tftypes.Value{
+			"team": tftypes.NewValue(tftypes.String, "infra"),
+		}),
+		"proxy": tftypes.NewValue(tftypes.List{ElementType: testNetworkType}, []tftypes.Value{
+			testNetworkValue("proxy", nil),
+		}),
+		"zones": tftypes.NewValue(tftypes.Set{ElementType: tftypes.String}, []tftypes.Value{
+			tftypes.NewValue(tftypes.String, "a"),
+		}),
+	})
+
+	return tftypes.NewValue(testConfigType, map[string]tftypes.Value{
+		"region": tftypes.NewValue(tftypes.String, "eu"),
+		"svc":    tftypes.NewValue(tftypes.List{ElementType: testBlockType}, []tftypes.Value{block}),
+	})
+}
+
+// expConfigSettings are the settings decoded from testConfigValue
+var expConfigSettings = testSettings{
+	Location:  "us-west",
+	SpaceName: "default",
+	// null and unknown bools and numbers are missing, unlike in *schema.ResourceData, so their defaults are used
+	Insecure: true,
+	Retries:  3,
+	Timeout:  time.Minute,
+	Ratio:    0.5,
+	Networks: []testNetwork{{Name: "net-a", VLAN: 10}, {Name: "net-b"}},
+	Tags:     map[string]string{"team": "infra"},
+	Proxy:    &testNetwork{Name: "proxy"},
+	Zones:    []string{"a"},
+}
+
+func TestDecodeServiceSettingsConfigValue(t *testing.T) {
+	t.Parallel()
+	config, err := NewConfigValue(testConfigValue())
+	require.NoError(t, err)
+	assert.Equal(t, "eu", config.Get("region"))
+	assert.Nil(t, config.Get("other"))
+
+	settings, err := DecodeServiceSettings[testSettings]("svc", config)
+	require.NoError(t, err)
+	assert.Equal(t, expConfigSettings, settings)
+
+	// The block isn't present
+	config, err = NewConfigValue(tftypes.NewValue(testConfigType, map[string]tftypes.Value{
+		"region": tftypes.NewValue(tftypes.String, nil),
+		"svc":    tftypes.NewValue(tftypes.List{ElementType: testBlockType}, nil),
+	}))
+	require.NoError(t, err)
+	_, err = DecodeServiceSettings[testSettings]("svc", config)
+	assert.ErrorIs(t, err, ErrServiceBlockNotDefined)
+
+	// The config must be an object
+	_, err = NewConfigValue(tftypes.NewValue(tftypes.String, "config"))
+	assert.EqualError(t, err, "config must be an object, not tftypes.String")
+}
+
+func TestNewConfigValueV6(t *testing.T) {
+	t.Parallel()
+	dv, err := tfprotov6.NewDynamicValue(testConfigType, testConfigValue())
+	require.NoError(t, err)
+
+	config, err := NewConfigValueV6(&dv, testConfigType)
+	require.NoError(t, err)
+	settings, err := DecodeServiceSettings[testSettings]("svc", config)
+	require.NoError(t, err)
+	assert.Equal(t, expConfigSettings, settings)
+
+	_, err = NewConfigValueV6(nil, testConfigType)
+	assert.EqualError(t, err, "config is not set")
+
+	// The type doesn't match the config
+	_, err = NewConfigValueV6(&dv, tftypes.Object{AttributeTypes: map[string]tftypes.Type{"region": tftypes.Bool}})
+	assert.ErrorContains(t, err, "error unmarshalling config")
+}
@@ -0,0 +1,329 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/retrieve"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/serviceclient"
+)
+
+// defaultClientTimeout is the default time allowed for each NewClient call
+const defaultClientTimeout = 2 * time.Minute
+
+// configureConfig holds the settings of the ConfigureFunc returned by NewConfigureFunc
+type configureConfig struct {
+	clientTimeout time.Duration
+	handlerOpts   []serviceclient.CreateOpt
+	lifecycle     *Lifecycle
+}
+
+// ConfigureOpt - function option definition for NewConfigureFunc
+type ConfigureOpt func(c *configureConfig)
+
+// WithClientTimeout set the time allowed for each NewClient call, the default is 2 minutes
+func WithClientTimeout(d time.Duration) ConfigureOpt {
+	return func(c *configureConfig) {
+		c.clientTimeout = d
+	}
+}
+
+// WithHandlerOpts set the options passed to serviceclient.NewHandler when the token Handler is created
+func WithHandlerOpts(opts ...serviceclient.CreateOpt) ConfigureOpt {
+	return func(c *configureConfig) {
+		c.handlerOpts = append(c.handlerOpts, opts...)
+	}
+}
+
+// WithLifecycle set the Lifecycle that the clients are added to, so that they are closed when it is
+func WithLifecycle(l *Lifecycle) ConfigureOpt {
+	return func(c *configureConfig) {
+		c.lifecycle = l
+	}
+}
+
+// NewConfigureFunc returns a provider.ConfigureFunc that initialises the service clients of inits, for use
+// with provider.NewProviderFunc.  The ConfigureContextFunc:
+//   - creates the IAM token Handler once, and puts its Token Retrieve Function in the client map at
+//     common.TokenRetrieveFunctionKey
+//   - runs NewClient for each service concurrently, each with the time set by WithClientTimeout, and puts the
+//     client in the client map at ServiceName()
+//   - skips a service if ServiceName() is the key of a service block in the provider schema, and the block
+//     isn't present in the provider stanza
+//   - returns a diagnostic naming the service for each NewClient that fails or times out
+//
+// Each NewClient is passed its own copy of the provider config, since *schema.ResourceData isn't safe for
+// concurrent use.  The inits are adapted with FromInitialisation, see NewConfigureFuncV2.
+func NewConfigureFunc(inits []Initialisation, opts ...ConfigureOpt) provider.ConfigureFunc {
+	initsV2 := make([]InitialisationV2, len(inits))
+	for i, init := range inits {
+		initsV2[i] = FromInitialisation(init)
+	}
+
+	return NewConfigureFuncV2(initsV2, opts...)
+}
+
+// NewConfigureFuncV2 is NewConfigureFunc for InitialisationV2.  NewClient is passed a context that is cancelled
+// when the client timeout passes, and any warnings it returns are returned by the ConfigureContextFunc.  If the
+// configuration fails the token Handler and the clients that have been created are closed, otherwise they are
+// added to the Lifecycle set by WithLifecycle.  Without a Lifecycle a warning is returned for each client that
+// implements Closer, since it would never be closed.  The token Handler is also stopped when the provider is
+// stopped, i.e. when Terraform sends StopProvider.
+func NewConfigureFuncV2(inits []InitialisationV2, opts ...ConfigureOpt) provider.ConfigureFunc {
+	c := &configureConfig{clientTimeout: defaultClientTimeout}
+
+	// run overrides
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+
+	return func(p *schema.Provider) schema.ConfigureContextFunc {
+		return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+			// The Handler outlives this call, its retrieve thread exits when the provider is stopped or
+			// the Lifecycle is closed
+			stop, ok := schema.StopContext(ctx)
+			if !ok {
+				stop = context.Background()
+			}
+			handlerCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			context.AfterFunc(stop, cancel)
+
+			h, err := serviceclient.NewHandler(d,
+				append([]serviceclient.CreateOpt{serviceclient.WithContext(handlerCtx)}, c.handlerOpts...)...)
+			if err != nil {
+				cancel()
+
+				return nil, tokenerrors.Diagnostics(err)
+			}
+
+			created, diags := c.newClients(ctx, p.Schema, inits, d)
+
+			// The Handler comes first so that it is closed last, after the clients that use it
+			closers := append([]namedClient{{name: tokenHandlerName, client: tokenHandler{h: h, cancel: cancel}}},
+				created...)
+			if diags.HasError() {
+				// release the resources of the Handler and the clients that were created
+				return nil, append(diags, closeClients(ctx, closers)...)
+			}
+
+			if c.lifecycle != nil {
+				diags = append(diags, c.lifecycle.add(ctx, closers)...)
+			} else {
+				diags = append(diags, unclosedClients(created)...)
+			}
+
+			clients := make(map[string]interface{}, len(created)+1)
+			for _, cli := range created {
+				clients[cli.name] = cli.client
+			}
+			clients[common.TokenRetrieveFunctionKey] = retrieve.NewTokenRetrieveFunc(h)
+
+			return clients, diags
+		}
+	}
+}
+
+// tokenHandlerName is the name of the token Handler in the diagnostics of closeClients
+const tokenHandlerName = "IAM token"
+
+// tokenHandler is a Closer for the token Handler created by the ConfigureContextFunc, Close stops the Handler
+// and releases its context
+type tokenHandler struct {
+	h      common.TokenChannelInterface
+	cancel context.CancelFunc
+}
+
+func (t tokenHandler) Close(ctx context.Context) error {
+	defer t.cancel()
+
+	if closer, ok := t.h.(common.TokenHandlerCloser); ok {
+		return closer.Close(ctx)
+	}
+
+	return nil
+}
+
+// unclosedClients returns a warning for each of clients that implements Closer, for when there is no Lifecycle
+// to close them
+func unclosedClients(clients []namedClient) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for _, cli := range clients {
+		if _, ok := cli.client.(Closer); ok {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("the %s client won't be closed", cli.name),
+				Detail: "The client implements Closer, but no Lifecycle was passed to the ConfigureFunc with " +
+					"WithLifecycle so it is never closed.",
+			})
+		}
+	}
+
+	return diags
+}
+
+// clientResult is the result of a NewClient call
+type clientResult struct {
+	client interface{}
+	diags  diag.Diagnostics
+}
+
+// newClients runs NewClient for the services in inits concurrently and returns the clients created, in the
+// order of inits
+func (c *configureConfig) newClients(
+	ctx context.Context,
+	providerSchema map[string]*schema.Schema,
+	inits []InitialisationV2,
+	d *schema.ResourceData,
+) ([]namedClient, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	results := make([]chan clientResult, len(inits))
+	seen := make(map[string]bool)
+	for i, init := range inits {
+		name := init.ServiceName()
+		if seen[name] {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("service name %s is repeated", name),
+				Detail:   "Each Initialisation must have a unique ServiceName().",
+			})
+
+			continue
+		}
+		seen[name] = true
+
+		// skip the service if its block isn't present
+		if _, hasBlock := providerSchema[name]; hasBlock {
+			if _, ok := d.GetOk(name); !ok {
+				continue
+			}
+		}
+
+		// the copies are made here, d mustn't be read concurrently
+		r, err := copyResourceData(ctx, providerSchema, d)
+		if err != nil {
+			diags = append(diags, clientDiagnostic(name, err))
+
+			continue
+		}
+
+		results[i] = make(chan clientResult, 1)
+		go c.newClient(ctx, init, r, results[i])
+	}
+
+	clients := make([]namedClient, 0, len(inits))
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+
+		res := <-result
+		diags = append(diags, res.diags...)
+		if !res.diags.HasError() {
+			clients = append(clients, namedClient{name: inits[i].ServiceName(), client: res.client})
+		}
+	}
+
+	return clients, diags
+}
+
+// newClient runs NewClient for init and sends the result on result, a NewClient that doesn't return within the
+// client timeout, or before ctx is cancelled, is abandoned
+func (c *configureConfig) newClient(
+	ctx context.Context,
+	init InitialisationV2,
+	r *schema.ResourceData,
+	result chan<- clientResult,
+) {
+	name := init.ServiceName()
+	ctx, cancel := context.WithTimeout(ctx, c.clientTimeout)
+	defer cancel()
+
+	done := make(chan clientResult, 1)
+	go func() {
+		cli, diags := init.NewClient(ctx, r)
+		done <- clientResult{client: cli, diags: diags}
+	}()
+
+	select {
+	case res := <-done:
+		result <- res
+	case <-ctx.Done():
+		// close the client if NewClient returns one after all
+		go func() {
+			if res := <-done; !res.diags.HasError() {
+				closeClients(context.Background(), []namedClient{{name: name, client: res.client}})
+			}
+		}()
+		result <- clientResult{diags: diag.Diagnostics{{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("timed out creating the %s client", name),
+			Detail:   fmt.Sprintf("NewClient didn't return within %s: %s", c.clientTimeout, ctx.Err()),
+		}}}
+	}
+}
+
+// clientDiagnostic returns the diagnostic for an error creating the client of service name
+func clientDiagnostic(name string, err error) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  fmt.Sprintf("error creating the %s client", name),
+		Detail:   err.Error(),
+	}
+}
+
+// copyResourceData returns a copy of d that can be used independently of d.  The values are read from d
+// and set as the config of the copy, so zero values set in d aren't replaced by defaults.
+func copyResourceData(
+	ctx context.Context,
+	providerSchema map[string]*schema.Schema,
+	d *schema.ResourceData,
+) (*schema.ResourceData, error) {
+	raw := make(map[string]interface{}, len(providerSchema))
+	for k := range providerSchema {
+		raw[k] = rawValue(d.Get(k))
+	}
+
+	sm := schema.InternalMap(providerSchema)
+	diff, err := sm.Diff(ctx, nil, terraform.NewResourceConfigRaw(raw), nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.Data(nil, diff)
+}
+
+// rawValue converts a value read from *schema.ResourceData to its raw config form
+func rawValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case *schema.Set:
+		return rawValue(value.List())
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for i, e := range value {
+			l[i] = rawValue(e)
+		}
+
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[k] = rawValue(e)
+		}
+
+		return m
+	default:
+		return v
+	}
+}
@@ -0,0 +1,196 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+// testRegistration is a service with a service block, and no resources or data-sources
+type testRegistration struct {
+	serviceName string
+}
+
+func (r testRegistration) Name() string {
+	return r.serviceName
+}
+
+func (r testRegistration) SupportedDataSources() map[string]*schema.Resource {
+	return nil
+}
+
+func (r testRegistration) SupportedResources() map[string]*schema.Resource {
+	return nil
+}
+
+func (r testRegistration) ProviderSchemaEntry() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"workspace": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+func testConfigure(p *schema.Provider) schema.ConfigureContextFunc { // nolint staticcheck
+	return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		return nil, nil
+	}
+}
+
+type testInitialisation struct {
+	serviceName string
+	err         error
+	delay       time.Duration
+	calls       *int32
+}
+
+func (i testInitialisation) NewClient(r *schema.ResourceData) (interface{}, error) {
+	if i.calls != nil {
+		atomic.AddInt32(i.calls, 1)
+	}
+	time.Sleep(i.delay)
+	if i.err != nil {
+		return nil, i.err
+	}
+
+	// The client is the workspace from the service block, if there is one
+	if r.Get(i.serviceName) == nil {
+		return "no block", nil
+	}
+	settings, err := GetServiceSettingsMap(i.serviceName, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings["workspace"], nil
+}
+
+func (i testInitialisation) ServiceName() string {
+	return i.serviceName
+}
+
+func TestNewConfigureFunc(t *testing.T) {
+	t.Parallel()
+	absentCalls := int32(0)
+	testcases := []struct {
+		name       string
+		inits      []Initialisation
+		expClients map[string]interface{}
+		expErrors  []string
+	}{
+		{
+			name: "success",
+			inits: []Initialisation{
+				testInitialisation{serviceName: "svc-a", delay: 100 * time.Millisecond},
+				testInitialisation{serviceName: "svc-b", delay: 100 * time.Millisecond},
+				// no service block in the provider schema
+				testInitialisation{serviceName: "svc-no-block"},
+				// service block not present
+				testInitialisation{serviceName: "svc-absent", calls: &absentCalls},
+			},
+			expClients: map[string]interface{}{
+				"svc-a":        "workspace-a",
+				"svc-b":        "workspace-b",
+				"svc-no-block": "no block",
+			},
+		},
+		{
+			name: "failures are aggregated",
+			inits: []Initialisation{
+				testInitialisation{serviceName: "svc-a"},
+				testInitialisation{serviceName: "svc-b", err: errors.New("bad workspace")},
+				testInitialisation{serviceName: "svc-slow", delay: time.Second},
+				testInitialisation{serviceName: "svc-a"},
+			},
+			expErrors: []string{
+				"service name svc-a is repeated",
+				"error creating the svc-b client",
+				"timed out creating the svc-slow client",
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			regs := make([]registration.ServiceRegistration, 0)
+			for _, name := range []string{"svc-a", "svc-b", "svc-slow", "svc-absent"} {
+				regs = append(regs, testRegistration{serviceName: name})
+			}
+			p := provider.NewProviderFunc(regs, NewConfigureFunc(tc.inits, WithClientTimeout(200*time.Millisecond)))()
+
+			d := schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{
+				"iam_token":   "token",
+				"iam_version": "glcs",
+				"svc-a":       []interface{}{map[string]interface{}{"workspace": "workspace-a"}},
+				"svc-b":       []interface{}{map[string]interface{}{"workspace": "workspace-b"}},
+				"svc-slow":    []interface{}{map[string]interface{}{"workspace": "workspace-slow"}},
+			})
+
+			start := time.Now()
+			meta, diags := p.ConfigureContextFunc(context.Background(), d)
+			if tc.expErrors != nil {
+				assert.Nil(t, meta)
+				summaries := make([]string, 0, len(diags))
+				for _, d := range diags {
+					assert.Equal(t, diag.Error, d.Severity)
+					summaries = append(summaries, d.Summary)
+				}
+				assert.Equal(t, tc.expErrors, summaries)
+				assert.Equal(t, "bad workspace", diags[1].Detail)
+
+				return
+			}
+			require.Empty(t, diags)
+
+			// The clients are created concurrently
+			assert.Less(t, time.Since(start), 190*time.Millisecond)
+
+			clients, ok := meta.(map[string]interface{})
+			require.True(t, ok)
+			assert.NotNil(t, clients[common.TokenRetrieveFunctionKey])
+			delete(clients, common.TokenRetrieveFunctionKey)
+			assert.Equal(t, tc.expClients, clients)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&absentCalls))
+		})
+	}
+}
+
+func TestCopyResourceData(t *testing.T) {
+	t.Parallel()
+	p := provider.NewProviderFunc([]registration.ServiceRegistration{
+		testRegistration{serviceName: "svc"},
+	}, testConfigure)()
+
+	d := schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{
+		"api_vended_service_client": false,
+		"tenant_id":                 "tenant",
+		"svc":                       []interface{}{map[string]interface{}{"workspace": "ws"}},
+	})
+
+	r, err := copyResourceData(context.Background(), p.Schema, d)
+	require.NoError(t, err)
+	for k := range p.Schema {
+		assert.Equal(t, rawValue(d.Get(k)), rawValue(r.Get(k)), k)
+	}
+
+	// An explicit false isn't replaced by the default of true
+	assert.Equal(t, false, r.Get("api_vended_service_client"))
+}
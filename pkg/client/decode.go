@@ -0,0 +1,389 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// settingsTag is the struct tag that maps a field to a settings attribute: `tf:"name"` or
+	// `tf:"name,required"`, fields without it are left alone
+	settingsTag = "tf"
+	// defaultTag is the struct tag that holds the default of a scalar field: `default:"value"`
+	defaultTag = "default"
+)
+
+// ErrServiceBlockNotDefined is returned when the service block isn't present in the provider stanza
+var ErrServiceBlockNotDefined = errors.New("block not defined in hpegl stanza")
+
+// resourceData is a generic model which implements Get function, e.g. *schema.ResourceData
+type resourceData interface {
+	Get(key string) interface{}
+}
+
+// durationType is decoded from a duration string, e.g. "30s"
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DecodeServiceSettings helper function for use by client code in NewClient instances
+// This function decodes the service block at key into a T, which must be a struct.  The attributes of the
+// block are mapped to the fields of T with `tf:"name"` tags:
+//
+//	type Settings struct {
+//		Location  string        `tf:"location,required"`
+//		SpaceName string        `tf:"space_name" default:"default"`
+//		Timeout   time.Duration `tf:"timeout" default:"30s"`
+//		Networks  []Network     `tf:"network"`
+//	}
+//
+// Attributes that are absent, or are "" or empty lists, are missing: the default is used if there is one.
+// Note that *schema.ResourceData returns false and 0 for unset bools and numbers, which aren't missing, so
+// their defaults should be set in the schema instead.
+// Nested blocks are decoded into structs, or pointers to structs that are nil if the block is missing, and
+// lists into slices.  All of the missing required attributes and attributes of the wrong type are reported in
+// the error.
+//
+// r can be *schema.ResourceData, a *ConfigValue for terraform-plugin-framework and terraform-plugin-go
+// providers, or any model which implements Get and returns the block as a map[string]interface{}, or a
+// []interface{} with one.
+func DecodeServiceSettings[T any](key string, r resourceData) (T, error) {
+	var settings T
+	m, err := serviceSettings(key, r)
+	if err != nil {
+		return settings, err
+	}
+
+	if err := DecodeSettings(m, &settings); err != nil {
+		return settings, fmt.Errorf("service %s block: %w", key, err)
+	}
+
+	return settings, nil
+}
+
+// DecodeSettings decodes the settings map m, e.g. one returned by GetServiceSettingsMap, into the struct
+// pointed to by out, see DecodeServiceSettings
+func DecodeSettings(m map[string]interface{}, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("settings must be decoded into a pointer to a struct, not %T", out)
+	}
+
+	return errors.Join(decodeStruct("", m, v.Elem())...)
+}
+
+// serviceSettings returns the settings map of the service block at key
+func serviceSettings(key string, r resourceData) (map[string]interface{}, error) {
+	m, ok, err := blockMap(r.Get(key))
+	if err != nil {
+		return nil, fmt.Errorf("service %s block: %w", key, err)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("service %s %w", key, ErrServiceBlockNotDefined)
+	}
+
+	return m, nil
+}
+
+// blockMap returns the map of a block, which is a *schema.Set or list with at most one element, or a map
+func blockMap(raw interface{}) (map[string]interface{}, bool, error) {
+	if set, ok := raw.(*schema.Set); ok {
+		raw = set.List()
+	}
+
+	switch value := raw.(type) {
+	case nil:
+		return nil, false, nil
+	case map[string]interface{}:
+		return value, true, nil
+	case []interface{}:
+		if len(value) == 0 {
+			return nil, false, nil
+		}
+
+		if len(value) > 1 {
+			return nil, false, fmt.Errorf("expected one block, got %d", len(value))
+		}
+
+		m, ok := value[0].(map[string]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("expected a block, got %T", value[0])
+		}
+
+		return m, true, nil
+	default:
+		return nil, false, fmt.Errorf("expected a block, got %T", raw)
+	}
+}
+
+// listOf returns the elements of a list or *schema.Set
+func listOf(raw interface{}) ([]interface{}, bool) {
+	switch value := raw.(type) {
+	case *schema.Set:
+		return value.List(), true
+	case []interface{}:
+		return value, true
+	default:
+		return nil, false
+	}
+}
+
+// isMissing reports whether an attribute value is missing
+func isMissing(raw interface{}) bool {
+	switch value := raw.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case *schema.Set:
+		return value.Len() == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	default:
+		return false
+	}
+}
+
+// fieldPath returns the path of attribute name within path
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// decodeStruct decodes the tagged fields of the struct v from m
+func decodeStruct(path string, m map[string]interface{}, v reflect.Value) []error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup(settingsTag)
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		fp := fieldPath(path, name)
+		raw := m[name]
+		if isMissing(raw) {
+			if def, ok := field.Tag.Lookup(defaultTag); ok {
+				if err := decodeString(fp, def, v.Field(i)); err != nil {
+					errs = append(errs, err)
+				}
+			} else if opts == "required" {
+				errs = append(errs, fmt.Errorf("%s is required", fp))
+			}
+
+			continue
+		}
+
+		errs = append(errs, decodeValue(fp, raw, v.Field(i))...)
+	}
+
+	return errs
+}
+
+// decodeValue decodes raw into v
+//
+//nolint:gocyclo
+func decodeValue(path string, raw interface{}, v reflect.Value) []error {
+	if v.Type() == durationType {
+		s, ok := raw.(string)
+		if !ok {
+			return []error{typeError(path, "duration string", raw)}
+		}
+
+		if err := decodeString(path, s, v); err != nil {
+			return []error{err}
+		}
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if isMissing(raw) {
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		errs := decodeValue(path, raw, elem.Elem())
+		v.Set(elem)
+
+		return errs
+	case reflect.Struct:
+		m, ok, err := blockMap(raw)
+		if err != nil {
+			return []error{fmt.Errorf("%s: %w", path, err)}
+		}
+		if !ok {
+			return nil
+		}
+
+		return decodeStruct(path, m, v)
+	case reflect.Slice:
+		l, ok := listOf(raw)
+		if !ok {
+			return []error{typeError(path, "list", raw)}
+		}
+		s := reflect.MakeSlice(v.Type(), len(l), len(l))
+		var errs []error
+		for i, e := range l {
+			errs = append(errs, decodeValue(fmt.Sprintf("%s.%d", path, i), e, s.Index(i))...)
+		}
+		v.Set(s)
+
+		return errs
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return []error{typeError(path, "map", raw)}
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		var errs []error
+		for k, e := range m {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			errs = append(errs, decodeValue(fieldPath(path, k), e, elem)...)
+			out.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+		}
+		v.Set(out)
+
+		return errs
+	case reflect.Interface:
+		if reflect.TypeOf(raw) != nil && !reflect.TypeOf(raw).AssignableTo(v.Type()) {
+			return []error{typeError(path, v.Type().String(), raw)}
+		}
+		if raw != nil {
+			v.Set(reflect.ValueOf(raw))
+		}
+
+		return nil
+	default:
+		if err := decodeScalar(path, raw, v); err != nil {
+			return []error{err}
+		}
+
+		return nil
+	}
+}
+
+// decodeScalar decodes raw into the string, bool or number v
+func decodeScalar(path string, raw interface{}, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return typeError(path, "string", raw)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return typeError(path, "bool", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt(raw)
+		if !ok || v.OverflowInt(i) {
+			return typeError(path, v.Type().String(), raw)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := toInt(raw)
+		if !ok || i < 0 || v.OverflowUint(uint64(i)) {
+			return typeError(path, v.Type().String(), raw)
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(raw)
+		if !ok || v.OverflowFloat(f) {
+			return typeError(path, v.Type().String(), raw)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("%s: unsupported field type %s", path, v.Type())
+	}
+
+	return nil
+}
+
+// decodeString decodes the string s, e.g. a default, into v
+func decodeString(path, s string, v reflect.Value) error {
+	var raw interface{}
+	var err error
+	switch {
+	case v.Type() == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			v.SetInt(int64(d))
+
+			return nil
+		}
+	case v.Kind() == reflect.String:
+		raw = s
+	case v.Kind() == reflect.Bool:
+		raw, err = strconv.ParseBool(s)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Uint64:
+		raw, err = strconv.ParseInt(s, 10, 64)
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		raw, err = strconv.ParseFloat(s, 64)
+	default:
+		return fmt.Errorf("%s: a default isn't supported for field type %s", path, v.Type())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q: %w", path, s, err)
+	}
+
+	return decodeScalar(path, raw, v)
+}
+
+// toInt converts the integral numbers that attribute values can be to int64
+func toInt(raw interface{}) (int64, bool) {
+	switch n := raw.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n > math.MaxInt64 {
+			return 0, false
+		}
+
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// toFloat converts the numbers that attribute values can be to float64
+func toFloat(raw interface{}) (float64, bool) {
+	switch n := raw.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	default:
+		i, ok := toInt(raw)
+
+		return float64(i), ok
+	}
+}
+
+// typeError returns the error for a value of the wrong type
+func typeError(path, expected string, raw interface{}) error {
+	return fmt.Errorf("%s: expected %s, got %T", path, expected, raw)
+}
@@ -0,0 +1,218 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testNetwork struct {
+	Name string `tf:"name,required"`
+	VLAN int    `tf:"vlan"`
+}
+
+type testSettings struct {
+	Location  string            `tf:"location,required"`
+	SpaceName string            `tf:"space_name" default:"default"`
+	Insecure  bool              `tf:"insecure" default:"true"`
+	Retries   uint              `tf:"retries" default:"3"`
+	Timeout   time.Duration     `tf:"timeout" default:"30s"`
+	Ratio     float64           `tf:"ratio"`
+	Networks  []testNetwork     `tf:"network"`
+	Tags      map[string]string `tf:"tags"`
+	Proxy     *testNetwork      `tf:"proxy"`
+	Zones     []string          `tf:"zones"`
+	Ignored   string
+}
+
+// testModel is a model that implements Get, e.g. an adapter over a framework config
+type testModel map[string]interface{}
+
+func (m testModel) Get(key string) interface{} {
+	return m[key]
+}
+
+func testSettingsSchema() map[string]*schema.Schema {
+	network := &schema.Resource{Schema: map[string]*schema.Schema{
+		"name": {Type: schema.TypeString, Optional: true},
+		"vlan": {Type: schema.TypeInt, Optional: true},
+	}}
+
+	return map[string]*schema.Schema{
+		"svc": {
+			Type:     schema.TypeSet,
+			Optional: true,
+			MaxItems: 1,
+			Elem: &schema.Resource{Schema: map[string]*schema.Schema{
+				"location":   {Type: schema.TypeString, Optional: true},
+				"space_name": {Type: schema.TypeString, Optional: true},
+				"insecure":   {Type: schema.TypeBool, Optional: true},
+				"retries":    {Type: schema.TypeInt, Optional: true},
+				"timeout":    {Type: schema.TypeString, Optional: true},
+				"ratio":      {Type: schema.TypeFloat, Optional: true},
+				"network":    {Type: schema.TypeList, Optional: true, Elem: network},
+				"tags":       {Type: schema.TypeMap, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+				"proxy":      {Type: schema.TypeList, Optional: true, MaxItems: 1, Elem: network},
+				"zones":      {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+			}},
+		},
+	}
+}
+
+func TestDecodeServiceSettingsResourceData(t *testing.T) {
+	t.Parallel()
+	d := schema.TestResourceDataRaw(t, testSettingsSchema(), map[string]interface{}{
+		"svc": []interface{}{map[string]interface{}{
+			"location": "us-west",
+			"insecure": false,
+			"ratio":    0.5,
+			"network": []interface{}{
+				map[string]interface{}{"name": "net-a", "vlan": 10},
+				map[string]interface{}{"name": "net-b"},
+			},
+			"tags":  map[string]interface{}{"team": "infra"},
+			"proxy": []interface{}{map[string]interface{}{"name": "proxy"}},
+			"zones": []interface{}{"a", "b"},
+		}},
+	})
+
+	settings, err := DecodeServiceSettings[testSettings]("svc", d)
+	require.NoError(t, err)
+	assert.Equal(t, testSettings{
+		Location:  "us-west",
+		SpaceName: "default",
+		// false and 0 aren't missing, and unset numbers and bools are 0 and false in *schema.ResourceData, so
+		// their defaults aren't used
+		Insecure: false,
+		Retries:  0,
+		Timeout:  30 * time.Second,
+		Ratio:    0.5,
+		Networks: []testNetwork{{Name: "net-a", VLAN: 10}, {Name: "net-b"}},
+		Tags:     map[string]string{"team": "infra"},
+		Proxy:    &testNetwork{Name: "proxy"},
+		Zones:    []string{"a", "b"},
+	}, settings)
+
+	// The block isn't present
+	d = schema.TestResourceDataRaw(t, testSettingsSchema(), map[string]interface{}{})
+	_, err = DecodeServiceSettings[testSettings]("svc", d)
+	assert.ErrorIs(t, err, ErrServiceBlockNotDefined)
+	assert.EqualError(t, err, "service svc block not defined in hpegl stanza")
+}
+
+func TestDecodeServiceSettings(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		block       interface{}
+		expSettings testSettings
+		expErrors   []string
+	}{
+		{
+			name:  "map block",
+			block: map[string]interface{}{"location": "eu", "timeout": "1m", "retries": 5, "proxy": map[string]interface{}{"name": "p"}},
+			expSettings: testSettings{
+				Location:  "eu",
+				SpaceName: "default",
+				Insecure:  true,
+				Retries:   5,
+				Timeout:   time.Minute,
+				Proxy:     &testNetwork{Name: "p"},
+			},
+		},
+		{
+			name:  "numbers as float64",
+			block: []interface{}{map[string]interface{}{"location": "eu", "retries": float64(2), "ratio": 1}},
+			expSettings: testSettings{
+				Location:  "eu",
+				SpaceName: "default",
+				Insecure:  true,
+				Retries:   2,
+				Timeout:   30 * time.Second,
+				Ratio:     1,
+			},
+		},
+		{
+			name: "all errors are reported",
+			block: map[string]interface{}{
+				"space_name": 1,
+				"insecure":   "yes",
+				"retries":    -1,
+				"timeout":    "soon",
+				"network":    []interface{}{map[string]interface{}{"vlan": 1.5}},
+				"zones":      "a",
+			},
+			expErrors: []string{
+				"location is required",
+				"space_name: expected string, got int",
+				"insecure: expected bool, got string",
+				"retries: expected uint, got int",
+				`timeout: invalid value "soon": time: invalid duration "soon"`,
+				"network.0.name is required",
+				"network.0.vlan: expected int, got float64",
+				"zones: expected list, got string",
+			},
+		},
+		{
+			name:      "more than one block",
+			block:     []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+			expErrors: []string{"expected one block, got 2"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			settings, err := DecodeServiceSettings[testSettings]("svc", testModel{"svc": tc.block})
+			if tc.expErrors != nil {
+				require.Error(t, err)
+				for _, e := range tc.expErrors {
+					assert.Contains(t, err.Error(), e)
+				}
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expSettings, settings)
+		})
+	}
+}
+
+func TestDecodeSettings(t *testing.T) {
+	t.Parallel()
+	var settings testNetwork
+	require.NoError(t, DecodeSettings(map[string]interface{}{"name": "net"}, &settings))
+	assert.Equal(t, testNetwork{Name: "net"}, settings)
+
+	assert.Error(t, DecodeSettings(map[string]interface{}{}, settings))
+	assert.Error(t, DecodeSettings(map[string]interface{}{}, new(string)))
+
+	// A default that can't be used for the field type
+	type badDefault struct {
+		Zones []string `tf:"zones" default:"a"`
+	}
+	err := DecodeSettings(map[string]interface{}{}, &badDefault{})
+	assert.EqualError(t, err, "zones: a default isn't supported for field type []string")
+	assert.False(t, errors.Is(err, ErrServiceBlockNotDefined))
+}
+
+func TestGetServiceSettingsMap(t *testing.T) {
+	t.Parallel()
+	d := schema.TestResourceDataRaw(t, testSettingsSchema(), map[string]interface{}{
+		"svc": []interface{}{map[string]interface{}{"location": "us-west"}},
+	})
+	m, err := GetServiceSettingsMap("svc", d)
+	require.NoError(t, err)
+	assert.Equal(t, "us-west", m["location"])
+
+	// A key that isn't in the schema is an error rather than a panic
+	_, err = GetServiceSettingsMap("missing", d)
+	assert.ErrorIs(t, err, ErrServiceBlockNotDefined)
+}
@@ -0,0 +1,121 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// InitialisationV2 is the version 2 of the Initialisation interface.  NewClient is passed a context, that is
+// cancelled if the client isn't created in time, and returns diagnostics so that it can return warnings as well
+// as errors.  Use FromInitialisation to adapt an Initialisation.
+type InitialisationV2 interface {
+	// NewClient is run by hpegl to initialise the service client, the client is only used if there are no
+	// error diagnostics
+	NewClient(ctx context.Context, r *schema.ResourceData) (interface{}, diag.Diagnostics)
+
+	// ServiceName is used by hpegl, it returns the key to be used for the client returned by NewClient
+	// in the map[string]interface{} passed-down to provider code by terraform
+	ServiceName() string
+}
+
+// Closer is an optional interface for the clients returned by NewClient.  Close is called when the provider
+// shuts down, see Lifecycle, so that the client can flush caches, close idle connections and stop background
+// goroutines.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// FromInitialisation adapts an Initialisation to InitialisationV2, an error returned by NewClient becomes an
+// error diagnostic naming the service
+func FromInitialisation(i Initialisation) InitialisationV2 {
+	return initialisationAdapter{i}
+}
+
+// initialisationAdapter adapts an Initialisation to InitialisationV2
+type initialisationAdapter struct {
+	Initialisation
+}
+
+func (a initialisationAdapter) NewClient(_ context.Context, r *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	cli, err := a.Initialisation.NewClient(r)
+	if err != nil {
+		return nil, diag.Diagnostics{clientDiagnostic(a.ServiceName(), err)}
+	}
+
+	return cli, nil
+}
+
+// Lifecycle holds the token Handler and the clients created by the ConfigureFunc returned by NewConfigureFunc
+// or NewConfigureFuncV2, pass it with WithLifecycle.  Close stops the Handler's goroutines and closes the clients
+// that implement Closer, it must be called by the consumer when the provider shuts down, i.e. when plugin.Serve
+// or tf6server.Serve returns.
+type Lifecycle struct {
+	mu      sync.Mutex
+	clients []namedClient
+	closed  bool
+}
+
+// namedClient is a client and the name of its service
+type namedClient struct {
+	name   string
+	client interface{}
+}
+
+// NewLifecycle creates a Lifecycle
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// add adds the clients that implement Closer, if the Lifecycle has already been closed they are closed now
+func (l *Lifecycle) add(ctx context.Context, clients []namedClient) diag.Diagnostics {
+	l.mu.Lock()
+	if !l.closed {
+		l.clients = append(l.clients, clients...)
+		l.mu.Unlock()
+
+		return nil
+	}
+	l.mu.Unlock()
+
+	return closeClients(ctx, clients)
+}
+
+// Close closes the clients that implement Closer, in the reverse order to that in which they were added, and
+// returns a diagnostic for each that fails.  The token Handler is closed after the clients that use it, Close
+// waits for its goroutines to exit or ctx to be done.  Only the first call closes the clients.
+func (l *Lifecycle) Close(ctx context.Context) diag.Diagnostics {
+	l.mu.Lock()
+	clients := l.clients
+	l.clients = nil
+	l.closed = true
+	l.mu.Unlock()
+
+	return closeClients(ctx, clients)
+}
+
+// closeClients closes the clients that implement Closer in reverse order
+func closeClients(ctx context.Context, clients []namedClient) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for i := len(clients) - 1; i >= 0; i-- {
+		closer, ok := clients[i].client.(Closer)
+		if !ok {
+			continue
+		}
+
+		if err := closer.Close(ctx); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("error closing the %s client", clients[i].name),
+				Detail:   err.Error(),
+			})
+		}
+	}
+
+	return diags
+}
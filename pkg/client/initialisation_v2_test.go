@@ -0,0 +1,255 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/retrieve"
+)
+
+// closeRecorder records the order in which clients are closed
+type closeRecorder struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (r *closeRecorder) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = append(r.closed, name)
+}
+
+func (r *closeRecorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.closed...)
+}
+
+type testCloser struct {
+	name     string
+	err      error
+	recorder *closeRecorder
+}
+
+func (c testCloser) Close(ctx context.Context) error {
+	c.recorder.record(c.name)
+
+	return c.err
+}
+
+type testInitialisationV2 struct {
+	serviceName string
+	diags       diag.Diagnostics
+	recorder    *closeRecorder
+	hasDeadline *bool
+}
+
+func (i testInitialisationV2) NewClient(ctx context.Context, r *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	if i.hasDeadline != nil {
+		_, *i.hasDeadline = ctx.Deadline()
+	}
+
+	return testCloser{name: i.serviceName, recorder: i.recorder}, i.diags
+}
+
+func (i testInitialisationV2) ServiceName() string {
+	return i.serviceName
+}
+
+func TestFromInitialisation(t *testing.T) {
+	t.Parallel()
+	init := FromInitialisation(testInitialisation{serviceName: "svc", err: errors.New("bad workspace")})
+	assert.Equal(t, "svc", init.ServiceName())
+
+	cli, diags := init.NewClient(context.Background(), nil)
+	assert.Nil(t, cli)
+	require.Len(t, diags, 1)
+	assert.Equal(t, "error creating the svc client", diags[0].Summary)
+	assert.Equal(t, "bad workspace", diags[0].Detail)
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Parallel()
+	recorder := &closeRecorder{}
+	l := NewLifecycle()
+	assert.Empty(t, l.add(context.Background(), []namedClient{
+		{name: "svc-a", client: testCloser{name: "svc-a", recorder: recorder}},
+		{name: "svc-b", client: "not a closer"},
+		{name: "svc-c", client: testCloser{name: "svc-c", err: errors.New("flush failed"), recorder: recorder}},
+	}))
+
+	diags := l.Close(context.Background())
+	assert.Equal(t, []string{"svc-c", "svc-a"}, recorder.names())
+	require.Len(t, diags, 1)
+	assert.Equal(t, diag.Warning, diags[0].Severity)
+	assert.Equal(t, "error closing the svc-c client", diags[0].Summary)
+
+	// Only the first call closes the clients
+	assert.Empty(t, l.Close(context.Background()))
+	assert.Len(t, recorder.names(), 2)
+
+	// Clients added once closed are closed straight away
+	assert.Empty(t, l.add(context.Background(), []namedClient{
+		{name: "svc-d", client: testCloser{name: "svc-d", recorder: recorder}},
+	}))
+	assert.Equal(t, []string{"svc-c", "svc-a", "svc-d"}, recorder.names())
+}
+
+func TestNewConfigureFuncV2(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		diags       diag.Diagnostics
+		expClients  []string
+		expClosed   []string
+		expWarnings int
+		hasError    bool
+		noLifecycle bool
+	}{
+		{
+			name:        "warnings are returned",
+			diags:       diag.Diagnostics{{Severity: diag.Warning, Summary: "workspace is deprecated"}},
+			expClients:  []string{"svc-a", "svc-b"},
+			expWarnings: 1,
+		},
+		{
+			name:      "clients are closed if configuration fails",
+			diags:     diag.Diagnostics{{Severity: diag.Error, Summary: "bad workspace"}},
+			expClosed: []string{"svc-a"},
+			hasError:  true,
+		},
+		{
+			name:        "warnings are returned for Closers without a Lifecycle",
+			expClients:  []string{"svc-a", "svc-b"},
+			expWarnings: 2,
+			noLifecycle: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			recorder := &closeRecorder{}
+			lifecycle := NewLifecycle()
+			hasDeadline := false
+			inits := []InitialisationV2{
+				testInitialisationV2{serviceName: "svc-a", recorder: recorder, hasDeadline: &hasDeadline},
+				testInitialisationV2{serviceName: "svc-b", recorder: recorder, diags: tc.diags},
+			}
+
+			opts := []ConfigureOpt{WithClientTimeout(time.Minute)}
+			if !tc.noLifecycle {
+				opts = append(opts, WithLifecycle(lifecycle))
+			}
+
+			p := provider.NewProviderFunc([]registration.ServiceRegistration{}, NewConfigureFuncV2(inits, opts...))()
+			d := schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{"iam_token": "token"})
+
+			meta, diags := p.ConfigureContextFunc(context.Background(), d)
+			assert.True(t, hasDeadline)
+			assert.Equal(t, tc.expClosed, recorder.names())
+			if tc.hasError {
+				assert.Nil(t, meta)
+				assert.True(t, diags.HasError())
+
+				return
+			}
+			require.False(t, diags.HasError())
+			assert.Len(t, diags, tc.expWarnings)
+
+			clients, ok := meta.(map[string]interface{})
+			require.True(t, ok)
+			assert.Contains(t, clients, common.TokenRetrieveFunctionKey)
+			for _, name := range tc.expClients {
+				assert.Contains(t, clients, name)
+			}
+
+			if tc.noLifecycle {
+				assert.Equal(t, "the svc-a client won't be closed", diags[0].Summary)
+				assert.Equal(t, "the svc-b client won't be closed", diags[1].Summary)
+
+				return
+			}
+
+			// The clients are closed with the Lifecycle
+			assert.Empty(t, lifecycle.Close(context.Background()))
+			assert.Equal(t, []string{"svc-b", "svc-a"}, recorder.names())
+		})
+	}
+}
+
+func TestNewConfigureFuncV2HandlerClosed(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name  string
+		close func(t *testing.T, lifecycle *Lifecycle, stop context.CancelFunc)
+	}{
+		{
+			name: "closed with the Lifecycle",
+			close: func(t *testing.T, lifecycle *Lifecycle, _ context.CancelFunc) {
+				t.Helper()
+				// Close returns a warning if the Handler's goroutines don't exit in time
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				assert.Empty(t, lifecycle.Close(ctx))
+			},
+		},
+		{
+			name: "stopped with the provider",
+			close: func(t *testing.T, _ *Lifecycle, stop context.CancelFunc) {
+				t.Helper()
+				stop()
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			lifecycle := NewLifecycle()
+			p := provider.NewProviderFunc([]registration.ServiceRegistration{},
+				NewConfigureFuncV2(nil, WithLifecycle(lifecycle)))()
+			d := schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{"iam_token": "token"})
+
+			// The SDK puts the provider's stop context in the context passed to the ConfigureContextFunc
+			stopCtx, stop := context.WithCancel(context.Background())
+			defer stop()
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), schema.StopContextKey, stopCtx))
+
+			meta, diags := p.ConfigureContextFunc(ctx, d)
+			require.False(t, diags.HasError())
+			clients, ok := meta.(map[string]interface{})
+			require.True(t, ok)
+			retrieveFunc, ok := clients[common.TokenRetrieveFunctionKey].(retrieve.TokenRetrieveFuncCtx)
+			require.True(t, ok)
+
+			// The Handler outlives the configure request, the passed-in token isn't a JWT so it fails to parse
+			cancel()
+			_, err := retrieveFunc(context.Background())
+			assert.NotErrorIs(t, err, common.ErrHandlerClosed)
+
+			tc.close(t, lifecycle, stop)
+			assert.Eventually(t, func() bool {
+				_, err := retrieveFunc(context.Background())
+
+				return errors.Is(err, common.ErrHandlerClosed)
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
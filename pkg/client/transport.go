@@ -0,0 +1,31 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/transport"
+)
+
+// GetTransport helper function for use by client code in NewClient instances
+// This function returns the *http.Transport built from the ca_bundle, https_proxy, insecure_skip_verify and
+// tls_min_version provider fields.  It is the same transport that is used for IAM calls, so connections are
+// shared.  The transport must not be modified, Clone it first.
+func GetTransport(r *schema.ResourceData) (*http.Transport, error) {
+	return transport.Shared(transport.ConfigFromResourceData(r))
+}
+
+// NewHTTPClient helper function for use by client code in NewClient instances
+// This function returns an *http.Client with the transport returned by GetTransport and timeout
+func NewHTTPClient(r *schema.ResourceData, timeout time.Duration) (*http.Client, error) {
+	t, err := GetTransport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
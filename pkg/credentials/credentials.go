@@ -0,0 +1,154 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultProfile is the profile used if none is set
+	DefaultProfile = "default"
+	// EnvUserID is the env-var that holds the client ID for Env
+	EnvUserID = "HPEGL_USER_ID"
+	// EnvUserSecret is the env-var that holds the client secret for Env
+	EnvUserSecret = "HPEGL_USER_SECRET" //nolint:gosec
+	// EnvTenantID is the env-var that holds the tenant ID for Env
+	EnvTenantID = "HPEGL_TENANT_ID"
+)
+
+// ErrNoCredentials is returned by a Provider that has no credentials configured, a Chain moves on to the next
+// Provider when it is returned
+var ErrNoCredentials = errors.New("no credentials configured")
+
+// Credentials are the API client credentials used to generate tokens
+type Credentials struct {
+	ClientID     string
+	ClientSecret string
+	// TenantID is optional, the tenant_id provider field is used if it isn't set
+	TenantID string
+	// Expiry is when the credentials expire and must be retrieved again, it is zero if they don't expire
+	Expiry time.Time
+	// Source is the name of the Provider that the credentials came from
+	Source string
+}
+
+// Provider is a source of Credentials.  Retrieve is called before every token is generated, so a Provider
+// that reads its credentials from elsewhere should pick up rotated credentials, caching them if it is
+// expensive to read them.  ErrNoCredentials is returned if the Provider has nothing configured.
+type Provider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// Static is a Provider of fixed credentials, e.g. the user_id and user_secret provider fields
+type Static Credentials
+
+// Retrieve returns the static credentials, or ErrNoCredentials if there is no ClientID
+func (s Static) Retrieve(_ context.Context) (Credentials, error) {
+	if s.ClientID == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+
+	c := Credentials(s)
+	if c.Source == "" {
+		c.Source = "static"
+	}
+
+	return c, nil
+}
+
+// Env is a Provider of credentials from the HPEGL_USER_ID, HPEGL_USER_SECRET and HPEGL_TENANT_ID env-vars,
+// they are read on every call to Retrieve
+type Env struct{}
+
+// Retrieve returns the credentials in the env-vars, or ErrNoCredentials if HPEGL_USER_ID isn't set
+func (Env) Retrieve(_ context.Context) (Credentials, error) {
+	clientID := os.Getenv(EnvUserID)
+	if clientID == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+
+	return Credentials{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv(EnvUserSecret),
+		TenantID:     os.Getenv(EnvTenantID),
+		Source:       "env",
+	}, nil
+}
+
+// Chain is a Provider that returns the credentials from the first of its Providers that has some configured
+type Chain []Provider
+
+// Retrieve returns the credentials from the first Provider that doesn't return ErrNoCredentials
+func (c Chain) Retrieve(ctx context.Context) (Credentials, error) {
+	for _, p := range c {
+		if p == nil {
+			continue
+		}
+
+		creds, err := p.Retrieve(ctx)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+
+		return creds, err
+	}
+
+	return Credentials{}, ErrNoCredentials
+}
+
+// DefaultFile returns the default credentials file, .hpegl/credentials in the user's home directory
+func DefaultFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(homeDir, ".hpegl", "credentials")
+}
+
+// resourceData is a generic model which implements Get function, e.g. *schema.ResourceData
+type resourceData interface {
+	Get(key string) interface{}
+}
+
+// FromResourceData returns the credential Provider chain for the provider fields in d, in order of precedence:
+//   - user_id, user_secret and tenant_id, which default to their env-vars
+//   - credential_process, a command that prints the credentials as JSON
+//   - the profile in credentials_file, which is ~/.hpegl/credentials by default
+//
+// The fields may not be present in all models, any that are missing are skipped.
+func FromResourceData(d resourceData) Provider {
+	getString := func(key string) string {
+		v, _ := d.Get(key).(string)
+
+		return v
+	}
+
+	chain := Chain{
+		Static{
+			ClientID:     getString("user_id"),
+			ClientSecret: getString("user_secret"),
+			TenantID:     getString("tenant_id"),
+			Source:       "provider",
+		},
+	}
+
+	if command := getString("credential_process"); command != "" {
+		chain = append(chain, &Process{Command: command})
+	}
+
+	file := &File{Path: getString("credentials_file"), Profile: getString("profile")}
+	if file.Path == "" {
+		// The default file is optional
+		file.Path = DefaultFile()
+		file.Optional = true
+	}
+	chain = append(chain, file)
+
+	return chain
+}
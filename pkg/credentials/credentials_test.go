@@ -0,0 +1,311 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResourceData map[string]interface{}
+
+func (d testResourceData) Get(key string) interface{} {
+	return d[key]
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+
+	return path
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	creds, err := Chain{Static{}, nil, Static{ClientID: "id", ClientSecret: "secret"}}.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{ClientID: "id", ClientSecret: "secret", Source: "static"}, creds)
+
+	_, err = Chain{Static{}}.Retrieve(ctx)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	// Errors other than ErrNoCredentials stop the chain
+	_, err = Chain{&File{Path: "missing"}, Static{ClientID: "id"}}.Retrieve(ctx)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoCredentials)
+}
+
+//nolint:paralleltest
+func TestEnv(t *testing.T) {
+	t.Setenv(EnvUserID, "")
+	_, err := Env{}.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	t.Setenv(EnvUserID, "id")
+	t.Setenv(EnvUserSecret, "secret")
+	t.Setenv(EnvTenantID, "tenant")
+	creds, err := Env{}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{ClientID: "id", ClientSecret: "secret", TenantID: "tenant", Source: "env"}, creds)
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+	yamlFile := `
+default:
+  user_id: default-id
+  user_secret: default-secret
+prod:
+  user_id: prod-id
+  user_secret: prod-secret
+  tenant_id: prod-tenant
+`
+	iniFile := `
+# comment
+[default]
+user_id = default-id
+user_secret = default-secret
+
+[prod]
+user_id = "prod-id"
+user_secret = 'prod-secret'
+tenant_id = prod-tenant
+`
+	testcases := []struct {
+		name     string
+		file     string
+		contents string
+		profile  string
+		expCreds Credentials
+		hasError bool
+	}{
+		{
+			name:     "YAML default profile",
+			file:     "credentials",
+			contents: yamlFile,
+			expCreds: Credentials{ClientID: "default-id", ClientSecret: "default-secret"},
+		},
+		{
+			name:     "YAML named profile",
+			file:     "credentials.yaml",
+			contents: yamlFile,
+			profile:  "prod",
+			expCreds: Credentials{ClientID: "prod-id", ClientSecret: "prod-secret", TenantID: "prod-tenant"},
+		},
+		{
+			name:     "INI named profile",
+			file:     "credentials",
+			contents: iniFile,
+			profile:  "prod",
+			expCreds: Credentials{ClientID: "prod-id", ClientSecret: "prod-secret", TenantID: "prod-tenant"},
+		},
+		{
+			name:     "missing profile",
+			file:     "credentials",
+			contents: iniFile,
+			profile:  "dev",
+			hasError: true,
+		},
+		{
+			name:     "unknown INI key",
+			file:     "credentials.ini",
+			contents: "[default]\nuser_password = x\n",
+			hasError: true,
+		},
+		{
+			name:     "unknown YAML key",
+			file:     "credentials.yml",
+			contents: "default:\n  user_password: x\n",
+			hasError: true,
+		},
+		{
+			name:     "profile without credentials",
+			file:     "credentials",
+			contents: "[default]\ntenant_id = t\n",
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			f := &File{Path: writeFile(t, tc.file, tc.contents), Profile: tc.profile}
+			creds, err := f.Retrieve(context.Background())
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expCreds.ClientID, creds.ClientID)
+			assert.Equal(t, tc.expCreds.ClientSecret, creds.ClientSecret)
+			assert.Equal(t, tc.expCreds.TenantID, creds.TenantID)
+		})
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	t.Parallel()
+	path := writeFile(t, "credentials", "[default]\nuser_id = id\nuser_secret = old\n")
+	f := &File{Path: path}
+
+	creds, err := f.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "old", creds.ClientSecret)
+
+	// The file is re-read when it changes
+	require.NoError(t, os.WriteFile(path, []byte("[default]\nuser_id = id\nuser_secret = rotated\n"), 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	creds, err = f.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", creds.ClientSecret)
+
+	// A missing optional file has no credentials
+	_, err = (&File{Path: filepath.Join(t.TempDir(), "missing"), Optional: true}).Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	// As does a missing profile in an optional file
+	_, err = (&File{Path: path, Profile: "prod", Optional: true}).Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestProcess(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	counter := filepath.Join(t.TempDir(), "counter")
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	testcases := []struct {
+		name     string
+		command  string
+		expCreds Credentials
+		expRuns  int
+		hasError bool
+	}{
+		{
+			name: "with expiration",
+			command: fmt.Sprintf(`echo x >> %s; echo '{"user_id":"id","user_secret":"secret","expiration":"%s"}'`,
+				counter+"1", expiration),
+			expCreds: Credentials{ClientID: "id", ClientSecret: "secret"},
+			expRuns:  1,
+		},
+		{
+			name:     "without expiration",
+			command:  fmt.Sprintf(`echo x >> %s; echo '{"user_id":"id","user_secret":"secret","tenant_id":"t"}'`, counter+"2"),
+			expCreds: Credentials{ClientID: "id", ClientSecret: "secret", TenantID: "t"},
+			expRuns:  2,
+		},
+		{
+			name:     "command fails",
+			command:  "echo failed >&2; exit 1",
+			hasError: true,
+		},
+		{
+			name:     "invalid output",
+			command:  "echo secret",
+			hasError: true,
+		},
+		{
+			name:     "no user_id",
+			command:  `echo '{"user_secret":"secret"}'`,
+			hasError: true,
+		},
+	}
+
+	for i, testcase := range testcases {
+		tc := testcase
+		counterFile := fmt.Sprintf("%s%d", counter, i+1)
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			p := &Process{Command: tc.command}
+			for i := 0; i < 2; i++ {
+				creds, err := p.Retrieve(context.Background())
+				if tc.hasError {
+					assert.Error(t, err)
+					assert.NotContains(t, fmt.Sprint(err), "secret")
+
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tc.expCreds.ClientID, creds.ClientID)
+				assert.Equal(t, tc.expCreds.ClientSecret, creds.ClientSecret)
+				assert.Equal(t, tc.expCreds.TenantID, creds.TenantID)
+			}
+
+			// The command is only re-run if the credentials have no expiration
+			runs, err := os.ReadFile(counterFile)
+			require.NoError(t, err)
+			assert.Len(t, runs, 2*tc.expRuns)
+		})
+	}
+}
+
+func TestFromResourceData(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+	file := writeFile(t, "credentials", "[prod]\nuser_id = file-id\nuser_secret = file-secret\n")
+	testcases := []struct {
+		name        string
+		d           testResourceData
+		expClientID string
+		hasError    bool
+	}{
+		{
+			name:        "provider fields take precedence",
+			d:           testResourceData{"user_id": "id", "credentials_file": file, "profile": "prod"},
+			expClientID: "id",
+		},
+		{
+			name: "credential process",
+			d: testResourceData{
+				"credential_process": `echo '{"user_id":"process-id"}'`,
+				"credentials_file":   file,
+				"profile":            "prod",
+			},
+			expClientID: "process-id",
+		},
+		{
+			name:        "credentials file",
+			d:           testResourceData{"credentials_file": file, "profile": "prod"},
+			expClientID: "file-id",
+		},
+		{
+			name:     "missing credentials file",
+			d:        testResourceData{"credentials_file": filepath.Join(t.TempDir(), "missing")},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			creds, err := FromResourceData(tc.d).Retrieve(context.Background())
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expClientID, creds.ClientID)
+		})
+	}
+}
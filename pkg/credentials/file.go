@@ -0,0 +1,203 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// profile is a named profile in a credentials file
+type profile struct {
+	UserID            string `yaml:"user_id"`
+	UserSecret        string `yaml:"user_secret"`
+	TenantID          string `yaml:"tenant_id"`
+	CredentialProcess string `yaml:"credential_process"`
+}
+
+// File is a Provider of the credentials in a profile of a credentials file.  The file can be YAML, with a map
+// of profiles, or INI, with a section for each profile:
+//
+//	[prod]
+//	user_id = ...
+//	user_secret = ...
+//	tenant_id = ...
+//
+// A profile can set credential_process instead, in which case the credentials are taken from the command, see
+// Process.  The file is re-read when it changes, so rotated credentials are picked up.
+type File struct {
+	// Path of the credentials file
+	Path string
+	// Profile is the name of the profile, DefaultProfile is used if it is empty
+	Profile string
+	// Optional means that ErrNoCredentials is returned if the file or the profile doesn't exist, rather than an
+	// error
+	Optional bool
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	profile profile
+	process *Process
+}
+
+// Retrieve returns the credentials in the profile, re-reading the file if it has changed
+func (f *File) Retrieve(ctx context.Context) (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if errors.Is(err, os.ErrNotExist) && f.Optional {
+		return Credentials{}, ErrNoCredentials
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	if !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		if err := f.load(); err != nil {
+			return Credentials{}, err
+		}
+		f.modTime = info.ModTime()
+		f.size = info.Size()
+	}
+
+	if f.process != nil {
+		return f.process.Retrieve(ctx)
+	}
+
+	return Credentials{
+		ClientID:     f.profile.UserID,
+		ClientSecret: f.profile.UserSecret,
+		TenantID:     f.profile.TenantID,
+		Source:       "credentials file profile " + f.profileName(),
+	}, nil
+}
+
+// profileName returns the name of the profile to read
+func (f *File) profileName() string {
+	if f.Profile == "" {
+		return DefaultProfile
+	}
+
+	return f.Profile
+}
+
+// load reads the profile from the file
+func (f *File) load() error {
+	data, err := os.ReadFile(filepath.Clean(f.Path))
+	if err != nil {
+		return fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	profiles, err := parseProfiles(f.Path, data)
+	if err != nil {
+		return fmt.Errorf("error parsing credentials file %s: %w", f.Path, err)
+	}
+
+	p, ok := profiles[f.profileName()]
+	if !ok && f.Optional {
+		return ErrNoCredentials
+	}
+	if !ok {
+		return fmt.Errorf("profile %q not found in credentials file %s", f.profileName(), f.Path)
+	}
+
+	if p.CredentialProcess == "" && p.UserID == "" {
+		return fmt.Errorf("profile %q in credentials file %s has no user_id or credential_process", f.profileName(), f.Path)
+	}
+
+	f.profile = p
+	f.process = nil
+	if p.CredentialProcess != "" {
+		f.process = &Process{Command: p.CredentialProcess}
+	}
+
+	return nil
+}
+
+// parseProfiles parses data as INI if the file has a .ini extension or starts with a section, otherwise as YAML
+func parseProfiles(path string, data []byte) (map[string]profile, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".ini" || (ext != ".yaml" && ext != ".yml" && isINI(data)) {
+		return parseINI(data)
+	}
+
+	profiles := make(map[string]profile)
+	if err := yaml.UnmarshalStrict(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// isINI returns true if the first line that isn't blank or a comment is a section header
+func isINI(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		return strings.HasPrefix(line, "[")
+	}
+
+	return false
+}
+
+// parseINI parses an INI file of profiles
+func parseINI(data []byte) (map[string]profile, error) {
+	profiles := make(map[string]profile)
+	var section string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			profiles[section] = profile{}
+
+			continue
+		case section == "":
+			return nil, fmt.Errorf("line %d: key outside of a profile section", n)
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		p := profiles[section]
+		switch key {
+		case "user_id":
+			p.UserID = value
+		case "user_secret":
+			p.UserSecret = value
+		case "tenant_id":
+			p.TenantID = value
+		case "credential_process":
+			p.CredentialProcess = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+		}
+		profiles[section] = p
+	}
+
+	return profiles, scanner.Err()
+}
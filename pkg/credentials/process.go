@@ -0,0 +1,107 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProcessTimeout is the default timeout of a credential process
+const defaultProcessTimeout = 30 * time.Second
+
+// processOutput is the JSON printed by a credential process
+type processOutput struct {
+	UserID     string    `json:"user_id"`
+	UserSecret string    `json:"user_secret"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	Expiration time.Time `json:"expiration,omitempty"`
+}
+
+// Process is a Provider of credentials from an external command, e.g. one that reads them from a secrets
+// manager.  The command is run with the shell and must print JSON to stdout:
+//
+//	{"user_id": "...", "user_secret": "...", "tenant_id": "...", "expiration": "2024-01-01T00:00:00Z"}
+//
+// tenant_id and expiration are optional.  The credentials are cached until they expire, if there is no
+// expiration the command is run every time the credentials are retrieved.
+type Process struct {
+	// Command is the command line to run
+	Command string
+	// Timeout of the command, the default is 30s
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	cached *Credentials
+}
+
+// Retrieve returns the cached credentials if they haven't expired, otherwise it runs the command
+func (p *Process) Retrieve(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && time.Now().Before(p.cached.Expiry) {
+		return *p.cached, nil
+	}
+
+	creds, err := p.run(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	p.cached = nil
+	if !creds.Expiry.IsZero() {
+		p.cached = &creds
+	}
+
+	return creds, nil
+}
+
+// run runs the command and parses its output
+func (p *Process) run(ctx context.Context) (Credentials, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProcessTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", p.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", p.Command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("credential process failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out processOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		// Don't include the output, it may hold a secret
+		return Credentials{}, fmt.Errorf("credential process output isn't valid JSON: %w", err)
+	}
+
+	if out.UserID == "" {
+		return Credentials{}, fmt.Errorf("credential process output has no user_id")
+	}
+
+	return Credentials{
+		ClientID:     out.UserID,
+		ClientSecret: out.UserSecret,
+		TenantID:     out.TenantID,
+		Expiry:       out.Expiration,
+		Source:       "credential process",
+	}, nil
+}
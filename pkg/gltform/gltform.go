@@ -3,14 +3,30 @@
 package gltform
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/internal/filelock"
 )
 
-const fileExtension = ".gltform"
+const (
+	fileExtension = ".gltform"
+	// DefaultProfile is the profile that the flat .gltform layout is read as, and that is used if no profile
+	// is selected
+	DefaultProfile = "default"
+	// ProfileEnvVar is the env-var that selects the profile, it takes precedence over default_profile
+	ProfileEnvVar = "HPEGL_PROFILE"
+	// filePermissions are the permissions of the .gltform file, it has held the GL IAM token
+	filePermissions = 0o600
+	// lockTimeout is how long to wait for the lock on the .gltform file
+	lockTimeout = 5 * time.Second
+)
 
 // Gljwt - the contents of the .gltform file
 type Gljwt struct {
@@ -29,27 +45,143 @@ type Gljwt struct {
 	Token string `yaml:"access_token,omitempty"`
 }
 
+// GLConfig - the contents of a .gltform file with named profiles:
+//
+//	default_profile: prod
+//	profiles:
+//	  prod:
+//	    project_id: ...
+//	    rest_url: ...
+//	  dev:
+//	    ...
+//
+// The flat layout, a single Gljwt, is read as the DefaultProfile.
+type GLConfig struct {
+	// DefaultProfile is the profile used if HPEGL_PROFILE isn't set
+	DefaultProfile string `yaml:"default_profile,omitempty"`
+	// Profiles are the named profiles
+	Profiles map[string]*Gljwt `yaml:"profiles,omitempty"`
+}
+
+// glConfigFile is the union of the flat and profiles layouts of the .gltform file
+type glConfigFile struct {
+	Gljwt    `yaml:",inline"`
+	GLConfig `yaml:",inline"`
+}
+
+// Opt - function option definition for reading and writing the .gltform file
+type Opt func(o *options)
+
+type options struct {
+	dir string
+}
+
+// WithDir sets the directory of the .gltform file.  It is written there instead of the directory from which
+// terraform is run, and only that directory is read.
+func WithDir(dir string) Opt {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+func newOptions(opts []Opt) *options {
+	o := &options{}
+
+	// run overrides
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+
+	return o
+}
+
+// ErrProfileNotFound is returned when the selected profile isn't in the .gltform file
+var ErrProfileNotFound = errors.New("profile not found in .gltform")
+
+// selectProfile returns profile, or the profile set by HPEGL_PROFILE, the config default_profile or
+// DefaultProfile, in that order
+func selectProfile(profile string, config *GLConfig) string {
+	if profile != "" {
+		return profile
+	}
+
+	if p := os.Getenv(ProfileEnvVar); p != "" {
+		return p
+	}
+
+	if config != nil && config.DefaultProfile != "" {
+		return config.DefaultProfile
+	}
+
+	return DefaultProfile
+}
+
 // GetGLConfig - reads the .gltform file, note that the .gltform can be in the home directory of the
-// user running terraform, or in the directory from which terraform is run
-func GetGLConfig() (gljwt *Gljwt, err error) {
-	homeDir, _ := os.UserHomeDir()
-	workingDir, _ := os.Getwd()
-	for _, p := range []string{workingDir, homeDir} {
-		gljwt, err = loadGLConfig(p)
-		if err == nil {
-			break
+// user running terraform, or in the directory from which terraform is run.  The profile is selected by
+// HPEGL_PROFILE, or default_profile, see GetGLConfigProfile.
+func GetGLConfig(opts ...Opt) (gljwt *Gljwt, err error) {
+	return GetGLConfigProfile("", opts...)
+}
+
+// GetGLConfigProfile reads profile from the .gltform file, if profile is empty it is selected by HPEGL_PROFILE,
+// or the default_profile in the file, or is DefaultProfile.  The first .gltform file that has the profile is
+// used, the directory from which terraform is run is searched before the home directory.  If profile is empty
+// a file in the flat layout has the selected profile, so that existing files work when HPEGL_PROFILE is set.
+func GetGLConfigProfile(profile string, opts ...Opt) (gljwt *Gljwt, err error) {
+	o := newOptions(opts)
+	dirs := []string{o.dir}
+	if o.dir == "" {
+		homeDir, _ := os.UserHomeDir()
+		workingDir, _ := os.Getwd()
+		dirs = []string{workingDir, homeDir}
+	}
+
+	var notFoundErr error
+	for _, p := range dirs {
+		var config *GLConfig
+		config, err = loadGLConfigFile(p)
+		if err != nil {
+			continue
+		}
+
+		name := selectProfile(profile, config)
+		if gljwt = config.Profiles[name]; gljwt != nil {
+			return gljwt, nil
+		}
+
+		// A file in the flat layout has no named profiles, it is used whichever profile is selected by
+		// HPEGL_PROFILE unless the caller asked for a profile
+		if gljwt = flatProfile(config); gljwt != nil && profile == "" {
+			return gljwt, nil
 		}
+		notFoundErr = fmt.Errorf("%w: %s", ErrProfileNotFound, name)
 	}
 
-	return gljwt, err
+	// A missing profile is a more useful error than a missing file
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	return nil, err
 }
 
 // WriteGLConfig takes a map[string]interface{} which will normally come from a
 // service block in the provider stanza and writes out a .gltform file in the directory
-// from which terraform is being run.  See the use of this function
-// for metal in terraform-provider-hpegl.
-func WriteGLConfig(d map[string]interface{}) error {
-	config := &Gljwt{
+// from which terraform is being run, or the directory set by WithDir.  See the use of this function
+// for metal in terraform-provider-hpegl.  The profile is selected as for GetGLConfig, other profiles in
+// the file are kept.
+func WriteGLConfig(d map[string]interface{}, opts ...Opt) error {
+	return WriteGLConfigProfile("", d, opts...)
+}
+
+// WriteGLConfigProfile writes d to profile in the .gltform file, see WriteGLConfig.  If profile is empty it is
+// selected as for GetGLConfigProfile.  Other profiles in the file are kept, and the flat layout is kept if the
+// file only has the DefaultProfile.  The file is only readable by the current user, and is replaced atomically
+// while holding a lock so that concurrent provider processes don't corrupt it.
+func WriteGLConfigProfile(profile string, d map[string]interface{}, opts ...Opt) error {
+	profileConfig := &Gljwt{
 		// If space_name isn't present, we'll just write out ""
 		SpaceName:    d["space_name"].(string),
 		ProjectID:    d["project_id"].(string),
@@ -58,59 +190,114 @@ func WriteGLConfig(d map[string]interface{}) error {
 		GLPWorkspace: d["glp_workspace"].(string),
 	}
 
-	// Marshal config
-	b, err := yaml.Marshal(config)
+	dir := newOptions(opts).dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	path := filepath.Clean(filepath.Join(dir, fileExtension))
+
+	unlock, err := filelock.Lock(path+".lock", lockTimeout)
+	if err != nil {
+		return fmt.Errorf("error writing .gltform: %w", err)
+	}
+	defer unlock()
+
+	// Read the existing profiles, so that they aren't clobbered
+	config, err := loadGLConfigFile(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		config, err = &GLConfig{}, nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// Write out marshalled config into .gltform
-	workingDir, _ := os.Getwd()
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*Gljwt)
+	}
+	config.Profiles[selectProfile(profile, config)] = profileConfig
 
-	for _, p := range []string{workingDir} {
-		err = writeGLConfigToFile(b, p)
-		if err != nil {
-			break
-		}
+	// Marshal config
+	b, err := marshalGLConfig(config)
+	if err != nil {
+		return err
 	}
 
-	return err
+	// Write out marshalled config into .gltform
+	return writeGLConfigToFile(b, path)
 }
 
-func writeGLConfigToFile(b []byte, dir string) error {
-	f, err := os.Create(filepath.Clean(filepath.Join(dir, fileExtension)))
+// writeGLConfigToFile writes b to path atomically, through a temporary file in the same directory that is
+// renamed.  An interrupted write leaves the previous file in place.
+func writeGLConfigToFile(b []byte, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	defer f.Close()
+	if err = f.Chmod(filePermissions); err == nil {
+		if _, err = f.Write(b); err == nil {
+			err = f.Sync()
+		}
+	}
 
-	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	return f.Sync()
+	return os.Rename(f.Name(), path)
+}
+
+// flatProfile returns the DefaultProfile if it is the only profile in config and there is no default_profile,
+// i.e. if config is in the flat layout, otherwise it returns nil
+func flatProfile(config *GLConfig) *Gljwt {
+	if len(config.Profiles) != 1 || config.DefaultProfile != "" {
+		return nil
+	}
+
+	return config.Profiles[DefaultProfile]
 }
 
-func loadGLConfig(dir string) (*Gljwt, error) {
+// marshalGLConfig marshals config, in the flat layout if it only has the DefaultProfile
+func marshalGLConfig(config *GLConfig) ([]byte, error) {
+	if flat := flatProfile(config); flat != nil {
+		return yaml.Marshal(flat)
+	}
+
+	return yaml.Marshal(config)
+}
+
+func loadGLConfigFile(dir string) (*GLConfig, error) {
 	f, err := os.Open(filepath.Clean(filepath.Join(dir, fileExtension)))
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return parseGLStream(f)
+	return parseGLConfigStream(f)
 }
 
-func parseGLStream(s io.Reader) (*Gljwt, error) {
+// parseGLConfigStream parses a .gltform file in either layout, the flat layout is returned as the
+// DefaultProfile
+func parseGLConfigStream(s io.Reader) (*GLConfig, error) {
 	contents, err := io.ReadAll(s)
 	if err != nil {
 		return nil, err
 	}
 
-	q := &Gljwt{}
-	err = yaml.Unmarshal(contents, q)
+	f := &glConfigFile{}
+	if err := yaml.Unmarshal(contents, f); err != nil {
+		return nil, err
+	}
+
+	if len(f.Profiles) == 0 {
+		flat := f.Gljwt
+
+		return &GLConfig{DefaultProfile: f.DefaultProfile, Profiles: map[string]*Gljwt{DefaultProfile: &flat}}, nil
+	}
 
-	return q, err
+	return &f.GLConfig, nil
 }
@@ -0,0 +1,323 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package gltform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const flatConfig = `project_id: flat-project
+rest_url: https://flat.example.com
+`
+
+const profilesConfig = `default_profile: prod
+profiles:
+  prod:
+    project_id: prod-project
+    rest_url: https://prod.example.com
+  dev:
+    project_id: dev-project
+    rest_url: https://dev.example.com
+`
+
+// setupDirs sets the home and working directories to new temporary directories, with the .gltform contents
+// given, an empty string means that there is no .gltform file
+func setupDirs(t *testing.T, workingConfig, homeConfig string) (workingDir, homeDir string) {
+	t.Helper()
+	workingDir, homeDir = t.TempDir(), t.TempDir()
+	for dir, contents := range map[string]string{workingDir: workingConfig, homeDir: homeConfig} {
+		if contents != "" {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, fileExtension), []byte(contents), 0o600))
+		}
+	}
+
+	t.Setenv("HOME", homeDir)
+	t.Setenv(ProfileEnvVar, "")
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workingDir))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	return workingDir, homeDir
+}
+
+func TestParseGLConfigStream(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name      string
+		contents  string
+		expConfig *GLConfig
+		hasError  bool
+	}{
+		{
+			name:     "flat layout",
+			contents: flatConfig,
+			expConfig: &GLConfig{Profiles: map[string]*Gljwt{
+				DefaultProfile: {ProjectID: "flat-project", RestURL: "https://flat.example.com"},
+			}},
+		},
+		{
+			name:     "profiles layout",
+			contents: profilesConfig,
+			expConfig: &GLConfig{DefaultProfile: "prod", Profiles: map[string]*Gljwt{
+				"prod": {ProjectID: "prod-project", RestURL: "https://prod.example.com"},
+				"dev":  {ProjectID: "dev-project", RestURL: "https://dev.example.com"},
+			}},
+		},
+		{
+			name:     "invalid YAML",
+			contents: "profiles: [",
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			config, err := parseGLConfigStream(strings.NewReader(tc.contents))
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expConfig, config)
+		})
+	}
+}
+
+//nolint:paralleltest
+func TestGetGLConfigProfile(t *testing.T) {
+	testcases := []struct {
+		name          string
+		workingConfig string
+		homeConfig    string
+		profile       string
+		envProfile    string
+		expProjectID  string
+		expError      error
+	}{
+		{
+			name:          "flat layout",
+			workingConfig: flatConfig,
+			expProjectID:  "flat-project",
+		},
+		{
+			name:          "flat layout with HPEGL_PROFILE",
+			workingConfig: flatConfig,
+			envProfile:    "dev",
+			expProjectID:  "flat-project",
+		},
+		{
+			name:          "flat layout with explicit profile",
+			workingConfig: flatConfig,
+			profile:       "dev",
+			expError:      ErrProfileNotFound,
+		},
+		{
+			name:          "default_profile",
+			workingConfig: profilesConfig,
+			expProjectID:  "prod-project",
+		},
+		{
+			name:          "HPEGL_PROFILE overrides default_profile",
+			workingConfig: profilesConfig,
+			envProfile:    "dev",
+			expProjectID:  "dev-project",
+		},
+		{
+			name:          "explicit profile overrides HPEGL_PROFILE",
+			workingConfig: profilesConfig,
+			profile:       "prod",
+			envProfile:    "dev",
+			expProjectID:  "prod-project",
+		},
+		{
+			name:         "home directory",
+			homeConfig:   profilesConfig,
+			profile:      "dev",
+			expProjectID: "dev-project",
+		},
+		{
+			name:          "profile only in home directory",
+			workingConfig: flatConfig,
+			homeConfig:    profilesConfig,
+			profile:       "dev",
+			expProjectID:  "dev-project",
+		},
+		{
+			name:          "missing profile",
+			workingConfig: profilesConfig,
+			profile:       "test",
+			expError:      ErrProfileNotFound,
+		},
+		{
+			name:     "no file",
+			expError: os.ErrNotExist,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			setupDirs(t, tc.workingConfig, tc.homeConfig)
+			t.Setenv(ProfileEnvVar, tc.envProfile)
+
+			gljwt, err := GetGLConfigProfile(tc.profile)
+			if tc.expError != nil {
+				assert.ErrorIs(t, err, tc.expError)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expProjectID, gljwt.ProjectID)
+		})
+	}
+}
+
+func testServiceBlock(projectID string) map[string]interface{} {
+	return map[string]interface{}{
+		"space_name":    "",
+		"project_id":    projectID,
+		"rest_url":      "https://" + projectID + ".example.com",
+		"glp_role":      "",
+		"glp_workspace": "",
+	}
+}
+
+//nolint:paralleltest
+func TestWriteGLConfigProfile(t *testing.T) {
+	t.Run("new file is flat", func(t *testing.T) {
+		workingDir, _ := setupDirs(t, "", "")
+		require.NoError(t, WriteGLConfig(testServiceBlock("new-project")))
+
+		contents, err := os.ReadFile(filepath.Join(workingDir, fileExtension))
+		require.NoError(t, err)
+		assert.Equal(t, "project_id: new-project\nrest_url: https://new-project.example.com\n", string(contents))
+	})
+
+	t.Run("other profiles are kept", func(t *testing.T) {
+		setupDirs(t, profilesConfig, "")
+		require.NoError(t, WriteGLConfigProfile("test", testServiceBlock("test-project")))
+
+		for profile, expProjectID := range map[string]string{
+			"prod": "prod-project",
+			"dev":  "dev-project",
+			"test": "test-project",
+		} {
+			gljwt, err := GetGLConfigProfile(profile)
+			require.NoError(t, err)
+			assert.Equal(t, expProjectID, gljwt.ProjectID)
+		}
+
+		// default_profile is kept too
+		gljwt, err := GetGLConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "prod-project", gljwt.ProjectID)
+	})
+
+	t.Run("selected profile is updated", func(t *testing.T) {
+		setupDirs(t, profilesConfig, "")
+		t.Setenv(ProfileEnvVar, "dev")
+		require.NoError(t, WriteGLConfig(testServiceBlock("updated-project")))
+
+		gljwt, err := GetGLConfigProfile("dev")
+		require.NoError(t, err)
+		assert.Equal(t, "updated-project", gljwt.ProjectID)
+
+		gljwt, err = GetGLConfigProfile("prod")
+		require.NoError(t, err)
+		assert.Equal(t, "prod-project", gljwt.ProjectID)
+	})
+
+	t.Run("flat file gains a profile", func(t *testing.T) {
+		setupDirs(t, flatConfig, "")
+		require.NoError(t, WriteGLConfigProfile("dev", testServiceBlock("dev-project")))
+
+		gljwt, err := GetGLConfigProfile(DefaultProfile)
+		require.NoError(t, err)
+		assert.Equal(t, "flat-project", gljwt.ProjectID)
+
+		gljwt, err = GetGLConfigProfile("dev")
+		require.NoError(t, err)
+		assert.Equal(t, "dev-project", gljwt.ProjectID)
+	})
+
+	t.Run("file is only readable by the user", func(t *testing.T) {
+		workingDir, _ := setupDirs(t, "", "")
+		path := filepath.Join(workingDir, fileExtension)
+		// An existing world-readable file is replaced
+		require.NoError(t, os.WriteFile(path, []byte(flatConfig), 0o644)) //nolint:gosec
+		require.NoError(t, WriteGLConfig(testServiceBlock("new-project")))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(filePermissions), info.Mode().Perm())
+
+		// The temporary files are removed, only the lock file is left
+		entries, err := os.ReadDir(workingDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+
+	t.Run("WithDir", func(t *testing.T) {
+		workingDir, _ := setupDirs(t, "", "")
+		dir := t.TempDir()
+		require.NoError(t, WriteGLConfigProfile("dev", testServiceBlock("dev-project"), WithDir(dir)))
+
+		_, err := os.Stat(filepath.Join(workingDir, fileExtension))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		gljwt, err := GetGLConfigProfile("dev", WithDir(dir))
+		require.NoError(t, err)
+		assert.Equal(t, "dev-project", gljwt.ProjectID)
+
+		_, err = GetGLConfigProfile("dev")
+		assert.Error(t, err)
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		setupDirs(t, "", "")
+		dir := t.TempDir()
+		const writers = 10
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				profile := fmt.Sprintf("profile-%d", i)
+				assert.NoError(t, WriteGLConfigProfile(profile, testServiceBlock(profile), WithDir(dir)))
+			}(i)
+		}
+		wg.Wait()
+
+		// No write is lost
+		for i := 0; i < writers; i++ {
+			profile := fmt.Sprintf("profile-%d", i)
+			gljwt, err := GetGLConfigProfile(profile, WithDir(dir))
+			require.NoError(t, err)
+			assert.Equal(t, profile, gljwt.ProjectID)
+		}
+	})
+
+	t.Run("leftover lock file", func(t *testing.T) {
+		// A lock file left by a process that died isn't locked
+		workingDir, _ := setupDirs(t, "", "")
+		lockFile := filepath.Join(workingDir, fileExtension+".lock")
+		require.NoError(t, os.WriteFile(lockFile, nil, 0o600))
+
+		require.NoError(t, WriteGLConfig(testServiceBlock("new-project")))
+		gljwt, err := GetGLConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "new-project", gljwt.ProjectID)
+	})
+}
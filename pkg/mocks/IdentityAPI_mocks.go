@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	common "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
 )
 
 // MockIdentityAPI is a mock of IdentityAPI interface.
@@ -35,10 +36,10 @@ func (m *MockIdentityAPI) EXPECT() *MockIdentityAPIMockRecorder {
 }
 
 // GenerateToken mocks base method.
-func (m *MockIdentityAPI) GenerateToken(arg0 context.Context, arg1, arg2, arg3, arg4 string) (string, error) {
+func (m *MockIdentityAPI) GenerateToken(arg0 context.Context, arg1, arg2, arg3, arg4 string) (common.AccessToken, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GenerateToken", arg0, arg1, arg2, arg3, arg4)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(common.AccessToken)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -0,0 +1,162 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+)
+
+// serviceBlockSchema returns the provider schema entry for the service block of service, the description is
+// annotated with the service metadata if there is any
+func serviceBlockSchema(service registration.ServiceRegistration) *schema.Schema {
+	s := convertToTypeSet(service.ProviderSchemaEntry())
+
+	m, ok := registration.MetadataOf(service)
+	if !ok {
+		return s
+	}
+
+	s.Description = serviceDescription(m)
+	if m.Maturity() == registration.MaturityDeprecated {
+		s.Deprecated = fmt.Sprintf("The %s service is deprecated.", service.Name())
+	}
+
+	return s
+}
+
+// serviceDescription returns the description of a service block
+func serviceDescription(m registration.ServiceMetadata) string {
+	parts := make([]string, 0, 4)
+	if m.Description() != "" {
+		parts = append(parts, strings.TrimSpace(m.Description()))
+	}
+
+	switch m.Maturity() {
+	case registration.MaturityBeta:
+		parts = append(parts, "This service is in beta.")
+	case registration.MaturityDeprecated:
+		parts = append(parts, "This service is deprecated.")
+	}
+
+	if m.Version() != "" {
+		parts = append(parts, fmt.Sprintf("Service version %s.", m.Version()))
+	}
+
+	if len(m.SupportedIAMVersions()) > 0 {
+		parts = append(parts, fmt.Sprintf("Supported IAM versions: %v.", m.SupportedIAMVersions()))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// validateMetadata checks the metadata of service, if it has any
+func validateMetadata(service registration.ServiceRegistration) diag.Diagnostics {
+	m, ok := registration.MetadataOf(service)
+	if !ok {
+		return nil
+	}
+
+	var diags diag.Diagnostics
+	if maturity := m.Maturity(); maturity != "" && !isMaturity(maturity) {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("service %s has unknown maturity %s", service.Name(), maturity),
+			Detail:   fmt.Sprintf("Maturity() must be one of %v.", registration.Maturities()),
+		})
+	}
+
+	for _, v := range m.SupportedIAMVersions() {
+		if _, err := iamversion.Lookup(v); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("service %s supports unknown IAM version %s", service.Name(), v),
+				Detail:   fmt.Sprintf("SupportedIAMVersions() must only return %v.", iamversion.Names()),
+			})
+		}
+	}
+
+	return diags
+}
+
+func isMaturity(maturity registration.Maturity) bool {
+	for _, m := range registration.Maturities() {
+		if maturity == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configureWithMetadata wraps configure with checks of the configured services in reg, i.e. those with a
+// service block in the provider stanza.  A warning is added for each configured beta service, and
+// configuration is refused if iam_version isn't supported by a configured service.
+func configureWithMetadata(
+	reg []registration.ServiceRegistration,
+	configure schema.ConfigureContextFunc,
+) schema.ConfigureContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		diags := checkConfiguredServices(reg, d)
+		if diags.HasError() || configure == nil {
+			return nil, diags
+		}
+
+		meta, configureDiags := configure(ctx, d)
+
+		return meta, append(diags, configureDiags...)
+	}
+}
+
+// checkConfiguredServices returns the diagnostics for the configured services in reg
+func checkConfiguredServices(reg []registration.ServiceRegistration, d *schema.ResourceData) diag.Diagnostics {
+	var diags diag.Diagnostics
+	iamVersion, _ := d.Get("iam_version").(string)
+	for _, service := range reg {
+		m, ok := registration.MetadataOf(service)
+		if !ok || service.ProviderSchemaEntry() == nil {
+			continue
+		}
+
+		if _, configured := d.GetOk(service.Name()); !configured {
+			continue
+		}
+
+		if m.Maturity() == registration.MaturityBeta {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("The %s service is in beta", service.Name()),
+				Detail:   "Beta services may change in ways that aren't backwards-compatible.",
+			})
+		}
+
+		if supported := m.SupportedIAMVersions(); len(supported) > 0 && !contains(supported, iamVersion) {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary: fmt.Sprintf("The %s service doesn't support IAM version %s",
+					service.Name(), iamVersion),
+				Detail: fmt.Sprintf("Set iam_version to one of %v, or remove the %s service block.",
+					supported, service.Name()),
+			})
+		}
+	}
+
+	return diags
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,402 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+)
+
+type MetadataRegistration struct {
+	Registration
+	maturity    registration.Maturity
+	iamVersions []string
+}
+
+func (r MetadataRegistration) ProviderSchemaEntry() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"workspace": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+func (r MetadataRegistration) Version() string {
+	return "1.2.0"
+}
+
+func (r MetadataRegistration) Description() string {
+	return "The test service."
+}
+
+func (r MetadataRegistration) Maturity() registration.Maturity {
+	return r.maturity
+}
+
+func (r MetadataRegistration) SupportedIAMVersions() []string {
+	return r.iamVersions
+}
+
+func TestServiceBlockSchema(t *testing.T) {
+	t.Parallel()
+	s := serviceBlockSchema(Registration{serviceName: "test"})
+	assert.Empty(t, s.Description)
+	assert.Empty(t, s.Deprecated)
+
+	s = serviceBlockSchema(MetadataRegistration{
+		Registration: Registration{serviceName: "test"},
+		maturity:     registration.MaturityBeta,
+		iamVersions:  []string{iamversion.GLP},
+	})
+	assert.Equal(t, "The test service. This service is in beta. Service version 1.2.0. Supported IAM versions: [glp].",
+		s.Description)
+	assert.Empty(t, s.Deprecated)
+
+	s = serviceBlockSchema(MetadataRegistration{
+		Registration: Registration{serviceName: "test"},
+		maturity:     registration.MaturityDeprecated,
+	})
+	assert.Equal(t, "The test service. This service is deprecated. Service version 1.2.0.", s.Description)
+	assert.Equal(t, "The test service is deprecated.", s.Deprecated)
+}
+
+func TestValidateMetadata(t *testing.T) {
+	t.Parallel()
+	diags := ValidateRegistrations([]registration.ServiceRegistration{
+		MetadataRegistration{
+			Registration: Registration{serviceName: "test"},
+			maturity:     "alpha",
+			iamVersions:  []string{iamversion.GLCS, "unknown"},
+		},
+	})
+	require.Len(t, diags, 2)
+	assert.Equal(t, "service test has unknown maturity alpha", diags[0].Summary)
+	assert.Equal(t, "service test supports unknown IAM version unknown", diags[1].Summary)
+}
+
+func TestConfigureWithMetadata(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		maturity    registration.Maturity
+		iamVersions []string
+		configured  bool
+		iamVersion  string
+		expErrors   []string
+		expWarnings []string
+	}{
+		{
+			name:        "beta service configured",
+			maturity:    registration.MaturityBeta,
+			configured:  true,
+			iamVersion:  iamversion.GLCS,
+			expWarnings: []string{"The test service is in beta"},
+		},
+		{
+			name:       "beta service not configured",
+			maturity:   registration.MaturityBeta,
+			iamVersion: iamversion.GLCS,
+		},
+		{
+			name:        "supported IAM version",
+			iamVersions: []string{iamversion.GLP},
+			configured:  true,
+			iamVersion:  iamversion.GLP,
+		},
+		{
+			name:        "unsupported IAM version",
+			iamVersions: []string{iamversion.GLP},
+			configured:  true,
+			iamVersion:  iamversion.GLCS,
+			expErrors:   []string{"The test service doesn't support IAM version glcs"},
+		},
+		{
+			name:        "unsupported IAM version not configured",
+			iamVersions: []string{iamversion.GLP},
+			iamVersion:  iamversion.GLCS,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			configured := false
+			pf := func(p *schema.Provider) schema.ConfigureContextFunc { // nolint staticcheck
+				return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+					configured = true
+
+					return "meta", nil
+				}
+			}
+
+			p := NewProviderFunc([]registration.ServiceRegistration{
+				MetadataRegistration{
+					Registration: Registration{serviceName: "test"},
+					maturity:     tc.maturity,
+					iamVersions:  tc.iamVersions,
+				},
+			}, pf)()
+
+			raw := map[string]interface{}{"iam_version": tc.iamVersion}
+			if tc.configured {
+				raw["test"] = []interface{}{map[string]interface{}{"workspace": "ws"}}
+			}
+			d := schema.TestResourceDataRaw(t, p.Schema, raw)
+
+			meta, diags := p.ConfigureContextFunc(context.Background(), d)
+			var errs, warnings []string
+			for _, d := range diags {
+				if d.Severity == diag.Error {
+					errs = append(errs, d.Summary)
+				} else {
+					warnings = append(warnings, d.Summary)
+				}
+			}
+			assert.Equal(t, tc.expErrors, errs)
+			assert.Equal(t, tc.expWarnings, warnings)
+
+			// Configuration is refused if there are errors
+			assert.Equal(t, tc.expErrors == nil, configured)
+			if tc.expErrors == nil {
+				assert.Equal(t, "meta", meta)
+			}
+		})
+	}
+}
+
+type FrameworkMetadataRegistration struct {
+	FrameworkRegistration
+	MetadataRegistration
+}
+
+func (r FrameworkMetadataRegistration) Name() string {
+	return r.FrameworkRegistration.Name()
+}
+
+func (r FrameworkMetadataRegistration) ProviderSchemaEntry() *schema.Resource {
+	return r.FrameworkRegistration.ProviderSchemaEntry()
+}
+
+func TestCombineRegistrationsMetadata(t *testing.T) {
+	t.Parallel()
+	combined := combineRegistrations(nil, []registration.FrameworkServiceRegistration{
+		FrameworkRegistration{serviceName: "test-framework"},
+		FrameworkMetadataRegistration{
+			FrameworkRegistration: FrameworkRegistration{serviceName: "test-framework2"},
+			MetadataRegistration:  MetadataRegistration{maturity: registration.MaturityBeta},
+		},
+	})
+	require.Len(t, combined, 2)
+
+	_, ok := registration.MetadataOf(combined[0])
+	assert.False(t, ok)
+	m, ok := registration.MetadataOf(combined[1])
+	require.True(t, ok)
+	assert.Equal(t, registration.MaturityBeta, m.Maturity())
+	assert.Contains(t, serviceBlockSchema(combined[1]).Description, "This service is in beta.")
+}
+
+func TestProviderForMuxV6Metadata(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		maturity    registration.Maturity
+		iamVersions []string
+		configured  bool
+		iamVersion  string
+		expErrors   []string
+		expWarnings []string
+	}{
+		{
+			name:        "beta framework service configured",
+			maturity:    registration.MaturityBeta,
+			configured:  true,
+			iamVersion:  iamversion.GLCS,
+			expWarnings: []string{"The test-framework service is in beta"},
+		},
+		{
+			name:       "beta framework service not configured",
+			maturity:   registration.MaturityBeta,
+			iamVersion: iamversion.GLCS,
+		},
+		{
+			name:        "unsupported IAM version",
+			iamVersions: []string{iamversion.GLP},
+			configured:  true,
+			iamVersion:  iamversion.GLCS,
+			expErrors:   []string{"The test-framework service doesn't support IAM version glcs"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fwRegs := []registration.FrameworkServiceRegistration{
+				FrameworkMetadataRegistration{
+					FrameworkRegistration: FrameworkRegistration{
+						serviceName: "test-framework",
+						server:      newFakeServerV6(),
+					},
+					MetadataRegistration: MetadataRegistration{
+						maturity:    tc.maturity,
+						iamVersions: tc.iamVersions,
+					},
+				},
+			}
+
+			setProviderSchema(t, nil, fwRegs)
+			server, err := ProviderForMuxV6(context.Background(), nil, fwRegs, providerConfigure)
+			require.NoError(t, err)
+			s, err := ProviderSchemaV6(context.Background(), nil, fwRegs)
+			require.NoError(t, err)
+
+			// Every attribute and block is null apart from iam_version and the service block
+			configType, ok := s.ValueType().(tftypes.Object)
+			require.True(t, ok)
+			attributes := make(map[string]tftypes.Value)
+			for name, attributeType := range configType.AttributeTypes {
+				attributes[name] = tftypes.NewValue(attributeType, nil)
+			}
+			attributes["iam_version"] = tftypes.NewValue(tftypes.String, tc.iamVersion)
+			if tc.configured {
+				blockType, ok := configType.AttributeTypes["test-framework"].(tftypes.Set)
+				require.True(t, ok)
+				attributes["test-framework"] = tftypes.NewValue(blockType, []tftypes.Value{
+					tftypes.NewValue(blockType.ElementType, map[string]tftypes.Value{
+						"workspace": tftypes.NewValue(tftypes.String, "ws"),
+					}),
+				})
+			}
+			config, err := tfprotov6.NewDynamicValue(configType, tftypes.NewValue(configType, attributes))
+			require.NoError(t, err)
+
+			resp, err := server().ConfigureProvider(context.Background(), &tfprotov6.ConfigureProviderRequest{
+				Config: &config,
+			})
+			require.NoError(t, err)
+			var errs, warnings []string
+			for _, d := range resp.Diagnostics {
+				if d.Severity == tfprotov6.DiagnosticSeverityError {
+					errs = append(errs, d.Summary)
+				} else {
+					warnings = append(warnings, d.Summary)
+				}
+			}
+			assert.Equal(t, tc.expErrors, errs)
+			assert.Equal(t, tc.expWarnings, warnings)
+		})
+	}
+}
+
+func TestProviderForMuxMetadata(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		maturity    registration.Maturity
+		iamVersions []string
+		configured  bool
+		expErrors   []string
+		expWarnings []string
+	}{
+		{
+			name:        "beta schema-only service configured",
+			maturity:    registration.MaturityBeta,
+			configured:  true,
+			expWarnings: []string{"The test-framework service is in beta"},
+		},
+		{
+			name:     "beta schema-only service not configured",
+			maturity: registration.MaturityBeta,
+		},
+		{
+			name:        "unsupported IAM version",
+			iamVersions: []string{iamversion.GLP},
+			configured:  true,
+			expErrors:   []string{"The test-framework service doesn't support IAM version glcs"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// The framework service only contributes its service block, so it doesn't have a sub-provider
+			servers, diags := ProviderForMuxE([]registration.ServiceRegistration{
+				Registration{
+					serviceName: "test-service",
+					resources:   map[string]*schema.Resource{"hpegl_test-service_resource": testResource()},
+				},
+				Registration{
+					serviceName: "test-service2",
+					resources:   map[string]*schema.Resource{"hpegl_test-service2_resource": testResource()},
+				},
+				MetadataRegistration{
+					Registration: Registration{serviceName: "test-framework"},
+					maturity:     tc.maturity,
+					iamVersions:  tc.iamVersions,
+				},
+			}, providerConfigure)
+			require.False(t, diags.HasError())
+			require.Len(t, servers, 2)
+
+			var errs, warnings []string
+			for _, server := range servers {
+				s := server()
+				schemaResp, err := s.GetProviderSchema(context.Background(), &tfprotov5.GetProviderSchemaRequest{})
+				require.NoError(t, err)
+
+				// Every attribute and block is null apart from iam_version and the service block
+				configType, ok := schemaResp.Provider.ValueType().(tftypes.Object)
+				require.True(t, ok)
+				attributes := make(map[string]tftypes.Value)
+				for name, attributeType := range configType.AttributeTypes {
+					attributes[name] = tftypes.NewValue(attributeType, nil)
+				}
+				attributes["iam_version"] = tftypes.NewValue(tftypes.String, iamversion.GLCS)
+				if tc.configured {
+					blockType, ok := configType.AttributeTypes["test-framework"].(tftypes.Set)
+					require.True(t, ok)
+					attributes["test-framework"] = tftypes.NewValue(blockType, []tftypes.Value{
+						tftypes.NewValue(blockType.ElementType, map[string]tftypes.Value{
+							"workspace": tftypes.NewValue(tftypes.String, "ws"),
+						}),
+					})
+				}
+				config, err := tfprotov5.NewDynamicValue(configType, tftypes.NewValue(configType, attributes))
+				require.NoError(t, err)
+
+				resp, err := s.ConfigureProvider(context.Background(), &tfprotov5.ConfigureProviderRequest{
+					Config: &config,
+				})
+				require.NoError(t, err)
+				for _, d := range resp.Diagnostics {
+					if d.Severity == tfprotov5.DiagnosticSeverityError {
+						errs = append(errs, d.Summary)
+					} else {
+						warnings = append(warnings, d.Summary)
+					}
+				}
+			}
+
+			// The service is only checked by one of the sub-providers
+			assert.Equal(t, tc.expErrors, errs)
+			assert.Equal(t, tc.expWarnings, warnings)
+		})
+	}
+}
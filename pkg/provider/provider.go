@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"net/url"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/credentials"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/transport"
 )
 
 // IAMVersion is a type definition for the IAM version
@@ -17,14 +22,11 @@ type IAMVersion string
 
 const (
 	// IAMVersionGLCS is the IAM version for GLCS
-	IAMVersionGLCS IAMVersion = "glcs"
+	IAMVersionGLCS IAMVersion = iamversion.GLCS
 	// IAMVersionGLP is the IAM version for GLP
-	IAMVersionGLP IAMVersion = "glp"
+	IAMVersionGLP IAMVersion = iamversion.GLP
 )
 
-// Update this list with any new IAM versions
-var iamVersionList = [...]IAMVersion{IAMVersionGLCS, IAMVersionGLP}
-
 // ConfigureFunc is a type definition of a function that returns a ConfigureContextFunc object
 // A function of this type is passed in to NewProviderFunc below
 type ConfigureFunc func(p *schema.Provider) schema.ConfigureContextFunc
@@ -32,53 +34,59 @@ type ConfigureFunc func(p *schema.Provider) schema.ConfigureContextFunc
 // NewProviderFunc is called from hpegl and service-repos to create a plugin.ProviderFunc which is used
 // to define the provider that is exposed to Terraform.  The hpegl repo will use this to create a provider
 // that spans all supported services.  A service repo will use this to create a "dummy" provider restricted
-// to just the service that can be used for development purposes and for acceptance testing
+// to just the service that can be used for development purposes and for acceptance testing.
+// The plugin.ProviderFunc panics if the registrations conflict, use NewProviderFuncE to get all of the
+// problems as diagnostics instead.
 func NewProviderFunc(reg []registration.ServiceRegistration, pf ConfigureFunc) plugin.ProviderFunc {
 	return func() *schema.Provider {
-		dataSources := make(map[string]*schema.Resource)
-		resources := make(map[string]*schema.Resource)
-		// providerSchema is the Schema for the provider
-		providerSchema := Schema()
-		for _, service := range reg {
-			for k, v := range service.SupportedDataSources() {
-				// We panic if the data-source name k is repeated in dataSources
-				if _, ok := dataSources[k]; ok {
-					panic(fmt.Sprintf("data-source name %s is repeated in service %s", k, service.Name()))
-				}
-				dataSources[k] = v
-			}
-			for k, v := range service.SupportedResources() {
-				// We panic if the resource name k is repeated in resources
-				if _, ok := resources[k]; ok {
-					panic(fmt.Sprintf("resource name %s is repeated in service %s", k, service.Name()))
-				}
-				resources[k] = v
-			}
-
-			// TODO we can add a set of reserved providerSchema keys here to check against
-
-			if service.ProviderSchemaEntry() != nil {
-				// We panic if the service.Name() key is repeated in providerSchema
-				if _, ok := providerSchema[service.Name()]; ok {
-					panic(fmt.Sprintf("service name %s is repeated", service.Name()))
-				}
-				providerSchema[service.Name()] = convertToTypeSet(service.ProviderSchemaEntry())
-			}
+		// We panic if the registrations conflict
+		if diags := ValidateRegistrations(reg); diags.HasError() {
+			panic((&RegistrationError{Diagnostics: diags}).Error())
 		}
 
-		p := schema.Provider{
-			Schema:         providerSchema,
-			ResourcesMap:   resources,
-			DataSourcesMap: dataSources,
-			// Don't use the following field, experimental
-			ProviderMetaSchema: nil,
-			TerraformVersion:   "",
-		}
+		return newProvider(reg, pf)
+	}
+}
 
-		p.ConfigureContextFunc = pf(&p) // nolint staticcheck
+// NewProviderFuncE is NewProviderFunc, but the registrations are validated up-front with ValidateRegistrations
+// and all of the problems found are returned as diagnostics rather than panicking on the first.  The
+// plugin.ProviderFunc is nil if there are any errors, warnings are returned along with it.
+func NewProviderFuncE(reg []registration.ServiceRegistration, pf ConfigureFunc) (plugin.ProviderFunc, diag.Diagnostics) {
+	diags := ValidateRegistrations(reg)
+	if diags.HasError() {
+		return nil, diags
+	}
 
-		return &p
+	return func() *schema.Provider {
+		return newProvider(reg, pf)
+	}, diags
+}
+
+// newProvider creates the provider that spans the services in reg, which must have been validated
+func newProvider(reg []registration.ServiceRegistration, pf ConfigureFunc) *schema.Provider {
+	dataSources := make(map[string]*schema.Resource)
+	resources := make(map[string]*schema.Resource)
+	for _, service := range reg {
+		for k, v := range service.SupportedDataSources() {
+			dataSources[k] = v
+		}
+		for k, v := range service.SupportedResources() {
+			resources[k] = v
+		}
 	}
+
+	p := schema.Provider{
+		Schema:         generateProviderSchema(reg),
+		ResourcesMap:   resources,
+		DataSourcesMap: dataSources,
+		// Don't use the following field, experimental
+		ProviderMetaSchema: nil,
+		TerraformVersion:   "",
+	}
+
+	p.ConfigureContextFunc = configureWithMetadata(reg, pf(&p)) // nolint staticcheck
+
+	return &p
 }
 
 func Schema() map[string]*schema.Schema {
@@ -91,6 +99,48 @@ func Schema() map[string]*schema.Schema {
                 an API client is used.  Passing-in a token means that tokens will not be generated or refreshed.`,
 	}
 
+	providerSchema["iam_token_verify"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_IAM_TOKEN_VERIFY", false),
+		Description: `Verify the signature and claims of a passed-in IAM token against the JWKS of the issuer.
+                The token must be issued by iam_token_issuer, the JWKS is fetched from that issuer and never from
+                the one named by the token.  Can be set by HPEGL_IAM_TOKEN_VERIFY env-var.`,
+	}
+
+	providerSchema["iam_token_issuer"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_IAM_TOKEN_ISSUER", ""),
+		Description: `The issuer that a passed-in IAM token must have when iam_token_verify is set, the default is
+                iam_service_url.  Can be set by HPEGL_IAM_TOKEN_ISSUER env-var.`,
+	}
+
+	providerSchema["iam_token_audience"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_IAM_TOKEN_AUDIENCE", ""),
+		Description: `The audience that a passed-in IAM token must have when iam_token_verify is set, the audience
+                isn't checked if this isn't set.  Can be set by HPEGL_IAM_TOKEN_AUDIENCE env-var.`,
+	}
+
+	providerSchema["token_cache"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_TOKEN_CACHE", false),
+		Description: `Cache IAM tokens on disk so that they are shared by the provider processes that Terraform
+                starts during a run, instead of each process generating its own.  Tokens are encrypted with a key
+                derived from user_secret.  Can be set by HPEGL_TOKEN_CACHE env-var.`,
+	}
+
+	providerSchema["token_cache_dir"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_TOKEN_CACHE_DIR", ""),
+		Description: `The directory of the token cache, the default is hpegl/tokens in the user's cache directory.
+                Can be set by HPEGL_TOKEN_CACHE_DIR env-var.`,
+	}
+
 	providerSchema["iam_service_url"] = &schema.Schema{
 		Type:         schema.TypeString,
 		Optional:     true,
@@ -107,7 +157,7 @@ func Schema() map[string]*schema.Schema {
 		DefaultFunc:  schema.EnvDefaultFunc("HPEGL_IAM_VERSION", string(IAMVersionGLCS)),
 		ValidateFunc: ValidateIAMVersion,
 		Description: `The IAM version to be used.  Can be set by HPEGL_IAM_VERSION env-var. Valid values are: 
-			` + fmt.Sprintf("%v", iamVersionList) + `The default is ` + string(IAMVersionGLCS) + `.`,
+			` + fmt.Sprintf("%v", iamversion.Names()) + `The default is ` + string(IAMVersionGLCS) + `.`,
 	}
 
 	providerSchema["api_vended_service_client"] = &schema.Schema{
@@ -139,6 +189,119 @@ func Schema() map[string]*schema.Schema {
 		Description: "The user secret to be used, can be set by HPEGL_USER_SECRET env-var",
 	}
 
+	providerSchema["profile"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CREDENTIALS_PROFILE", credentials.DefaultProfile),
+		Description: `The profile in credentials_file to take the API client credentials from, used if user_id
+            isn't set.  The default is "` + credentials.DefaultProfile + `".  Can be set by
+            HPEGL_CREDENTIALS_PROFILE env-var.`,
+	}
+
+	providerSchema["credentials_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CREDENTIALS_FILE", ""),
+		Description: `The path of a YAML or INI file of named profiles of API client credentials, the default is
+            ~/.hpegl/credentials.  Can be set by HPEGL_CREDENTIALS_FILE env-var.`,
+	}
+
+	providerSchema["credential_process"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CREDENTIAL_PROCESS", ""),
+		Description: `A command that prints the API client credentials as JSON, with "user_id", "user_secret" and
+            optionally "tenant_id" and "expiration", used if user_id isn't set.  Takes precedence over
+            credentials_file.  Can be set by HPEGL_CREDENTIAL_PROCESS env-var.`,
+	}
+
+	providerSchema["client_auth_method"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		DefaultFunc:  schema.EnvDefaultFunc("HPEGL_CLIENT_AUTH_METHOD", string(clientauth.MethodClientSecret)),
+		ValidateFunc: ValidateClientAuthMethod,
+		Description: `How the API client authenticates to IAM.  Valid values are: ` +
+			fmt.Sprintf("%v", clientauth.Methods()) + `.  The default is ` + string(clientauth.MethodClientSecret) + `
+            i.e. user_secret is sent.  ` + string(clientauth.MethodPrivateKeyJWT) + ` signs a client assertion with
+            client_private_key, ` + string(clientauth.MethodTLSClientAuth) + ` presents client_certificate_file.
+            Can be set by HPEGL_CLIENT_AUTH_METHOD env-var.`,
+	}
+
+	providerSchema["client_private_key"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CLIENT_PRIVATE_KEY", ""),
+		Description: `The PEM-encoded private key used to sign client assertions for private_key_jwt client
+            authentication, takes precedence over client_private_key_file.  Can be set by HPEGL_CLIENT_PRIVATE_KEY env-var.`,
+	}
+
+	providerSchema["client_private_key_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CLIENT_PRIVATE_KEY_FILE", ""),
+		Description: `The path of the PEM-encoded private key used to sign client assertions for private_key_jwt
+            client authentication.  Can be set by HPEGL_CLIENT_PRIVATE_KEY_FILE env-var.`,
+	}
+
+	providerSchema["client_key_id"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CLIENT_KEY_ID", ""),
+		Description: `The key id ("kid") of the private key used to sign client assertions, if IAM needs it.
+            Can be set by HPEGL_CLIENT_KEY_ID env-var.`,
+	}
+
+	providerSchema["client_certificate_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CLIENT_CERTIFICATE_FILE", ""),
+		Description: `The path of the PEM-encoded TLS client certificate for tls_client_auth client authentication.
+            Can be set by HPEGL_CLIENT_CERTIFICATE_FILE env-var.`,
+	}
+
+	providerSchema["client_certificate_key_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CLIENT_CERTIFICATE_KEY_FILE", ""),
+		Description: `The path of the PEM-encoded private key of the TLS client certificate for tls_client_auth
+            client authentication.  Can be set by HPEGL_CLIENT_CERTIFICATE_KEY_FILE env-var.`,
+	}
+
+	providerSchema["ca_bundle"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_CA_BUNDLE", ""),
+		Description: `The path of a PEM file of CA certificates to trust as well as the system roots, e.g. for a
+            corporate proxy with a private CA.  Can be set by HPEGL_CA_BUNDLE env-var.`,
+	}
+
+	providerSchema["https_proxy"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_HTTPS_PROXY", ""),
+		Description: `The URL of the proxy to use for IAM and service calls.  If it isn't set the standard
+            HTTPS_PROXY, HTTP_PROXY and NO_PROXY env-vars are used.  Can be set by HPEGL_HTTPS_PROXY env-var.`,
+	}
+
+	providerSchema["insecure_skip_verify"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		DefaultFunc: schema.EnvDefaultFunc("HPEGL_INSECURE_SKIP_VERIFY", false),
+		Description: `WARNING: disables verification of TLS server certificates, which makes connections to IAM and
+            services insecure.  Only use this for testing.  Can be set by HPEGL_INSECURE_SKIP_VERIFY env-var.`,
+	}
+
+	providerSchema["tls_min_version"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		DefaultFunc:  schema.EnvDefaultFunc("HPEGL_TLS_MIN_VERSION", transport.TLSVersion12),
+		ValidateFunc: ValidateTLSMinVersion,
+		Description: `The minimum TLS version for IAM and service calls.  Valid values are: ` +
+			fmt.Sprintf("%v", transport.TLSVersions()) + `.  The default is ` + transport.TLSVersion12 + `.
+            Can be set by HPEGL_TLS_MIN_VERSION env-var.`,
+	}
+
 	return providerSchema
 }
 
@@ -170,22 +333,45 @@ func ValidateIAMVersion(v interface{}, k string) ([]string, []error) {
 		return []string{}, []error{fmt.Errorf("IAM version must be a string")}
 	}
 
-	// check that versionInput is in iamVersionList
-	found := false
-	for _, version := range iamVersionList {
-		if string(version) == versionInput {
-			found = true
-			break
+	// check that versionInput is registered, IAM versions are added with iamversion.Register
+	es := make([]error, 0)
+	if _, err := iamversion.Lookup(versionInput); err != nil {
+		es = append(es, fmt.Errorf("IAM version must be one of %v", iamversion.Names()))
+	}
+
+	return []string{}, es
+}
+
+// ValidateClientAuthMethod is a ValidateFunc for the "client_auth_method" field in the provider schema
+func ValidateClientAuthMethod(v interface{}, k string) ([]string, []error) {
+	methodInput, ok := v.(string)
+	if !ok {
+		return []string{}, []error{fmt.Errorf("client authentication method must be a string")}
+	}
+
+	for _, method := range clientauth.Methods() {
+		if clientauth.Method(methodInput) == method {
+			return []string{}, []error{}
 		}
 	}
 
-	// add error if not found
-	es := make([]error, 0)
-	if !found {
-		es = append(es, fmt.Errorf("IAM version must be one of %v", iamVersionList))
+	return []string{}, []error{fmt.Errorf("client authentication method must be one of %v", clientauth.Methods())}
+}
+
+// ValidateTLSMinVersion is a ValidateFunc for the "tls_min_version" field in the provider schema
+func ValidateTLSMinVersion(v interface{}, k string) ([]string, []error) {
+	versionInput, ok := v.(string)
+	if !ok {
+		return []string{}, []error{fmt.Errorf("TLS version must be a string")}
 	}
 
-	return []string{}, es
+	for _, version := range transport.TLSVersions() {
+		if versionInput == version {
+			return []string{}, []error{}
+		}
+	}
+
+	return []string{}, []error{fmt.Errorf("TLS version must be one of %v", transport.TLSVersions())}
 }
 
 // ValidateServiceURL is a ValidateFunc for the "iam_service_url" field in the provider schema
@@ -3,8 +3,8 @@
 package provider
 
 import (
-	"fmt"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
@@ -15,33 +15,72 @@ import (
 // to support "legacy" provider code that use SDK v2.0 (i.e. metal, vmaas, caas on PCE) as well as newer provider
 // code that uses the new Hashicorp provider "framework".
 //
-// Note that we will need to add the ProviderSchemaEntry() functions for the newer providers.  This means that
-// registration.ServiceRegistration implementations for the newer providers that only contain ProviderSchemaEntry()
-// and no SupportedResource() or SupportedDataSources().
+// Newer providers built on the framework can only contribute a ProviderSchemaEntry() here, i.e. a
+// registration.ServiceRegistration with no SupportedResource() or SupportedDataSources().  To serve framework
+// resources and data-sources use ProviderForMuxV6 instead.
+//
+// ProviderForMux panics if the registrations conflict, use ProviderForMuxE to get all of the problems as
+// diagnostics instead.
 func ProviderForMux(reg []registration.ServiceRegistration, pf ConfigureFunc) []func() tfprotov5.ProviderServer {
+	// We panic if the registrations conflict
+	if diags := ValidateRegistrations(reg); diags.HasError() {
+		panic((&RegistrationError{Diagnostics: diags}).Error())
+	}
+
+	return providerServers(reg, pf)
+}
+
+// ProviderForMuxE is ProviderForMux, but all of the problems found by ValidateRegistrations are returned as
+// diagnostics rather than panicking on the first.  No provider servers are returned if there are any errors.
+func ProviderForMuxE(
+	reg []registration.ServiceRegistration,
+	pf ConfigureFunc,
+) ([]func() tfprotov5.ProviderServer, diag.Diagnostics) {
+	diags := ValidateRegistrations(reg)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	return providerServers(reg, pf), diags
+}
+
+// providerServers returns a provider server for each service in reg with resources or data sources, reg must
+// have been validated.  Each sub-provider checks the metadata of its own service when it is configured, the
+// first also checks the services without a sub-provider, e.g. framework services that only contribute a
+// ProviderSchemaEntry().
+func providerServers(reg []registration.ServiceRegistration, pf ConfigureFunc) []func() tfprotov5.ProviderServer {
 	providerSchema := generateProviderSchema(reg)
-	providerServerList := make([]func() tfprotov5.ProviderServer, 0)
+
+	var served, schemaOnly []registration.ServiceRegistration
 	for _, service := range reg {
 		// Only create a provider if it has resources or data sources
 		if service.SupportedResources() != nil || service.SupportedDataSources() != nil {
-			providerServerList = append(providerServerList, generateProvider(service, pf, providerSchema))
+			served = append(served, service)
+		} else {
+			schemaOnly = append(schemaOnly, service)
+		}
+	}
+
+	providerServerList := make([]func() tfprotov5.ProviderServer, 0, len(served))
+	for i, service := range served {
+		checked := ServiceRegistrationSlice(service)
+		if i == 0 {
+			checked = append(checked, schemaOnly...)
 		}
+		providerServerList = append(providerServerList, generateProvider(service, checked, pf, providerSchema))
 	}
 
 	return providerServerList
 }
 
 // generateProviderSchema generates the provider schema from the service registrations.  Note that this schema
-// needs to be added to each of the sub-providers.
+// needs to be added to each of the sub-providers.  The registrations must have been validated with
+// ValidateRegistrations.
 func generateProviderSchema(reg []registration.ServiceRegistration) map[string]*schema.Schema {
 	providerSchema := Schema()
 	for _, service := range reg {
 		if service.ProviderSchemaEntry() != nil {
-			// We panic if the service.Name() key is repeated in providerSchema
-			if _, ok := providerSchema[service.Name()]; ok {
-				panic(fmt.Sprintf("service name %s is repeated", service.Name()))
-			}
-			providerSchema[service.Name()] = convertToTypeSet(service.ProviderSchemaEntry())
+			providerSchema[service.Name()] = serviceBlockSchema(service)
 		}
 	}
 
@@ -49,8 +88,10 @@ func generateProviderSchema(reg []registration.ServiceRegistration) map[string]*
 }
 
 // generateProvider will generate a sub-provider for each service that can be used with the Hashicorp mux library.
+// The metadata of the services in checked is checked when the sub-provider is configured.
 func generateProvider(
 	service registration.ServiceRegistration,
+	checked []registration.ServiceRegistration,
 	pf ConfigureFunc,
 	providerSchema map[string]*schema.Schema,
 ) func() tfprotov5.ProviderServer {
@@ -63,7 +104,7 @@ func generateProvider(
 		TerraformVersion:   "",
 	}
 
-	p.ConfigureContextFunc = pf(&p) // nolint staticcheck
+	p.ConfigureContextFunc = configureWithMetadata(checked, pf(&p)) // nolint staticcheck
 
 	return p.GRPCProvider
 }
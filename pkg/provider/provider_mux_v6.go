@@ -0,0 +1,155 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-mux/tf5to6server"
+	"github.com/hashicorp/terraform-plugin-mux/tf6muxserver"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+)
+
+// ProviderForMuxV6 is the protocol version 6 equivalent of ProviderForMux.  It returns a single ProviderServer
+// function that can be passed to tf6server.Serve, the services are upgraded with tf5to6server and muxed with
+// tf6muxserver in here.
+//
+// The SDK v2.0 services in reg are served by one SDK v2.0 provider, upgraded to protocol version 6.  Its
+// provider schema is generated from both sets of registrations, and it checks the metadata of every configured
+// service, framework services included.  The servers of the framework services in frameworkReg are muxed with
+// it, they must serve the same provider schema, ProviderSchemaV6.
+//
+// A *RegistrationError is returned if the registrations conflict, an error if the provider schemas of the
+// servers differ or if two services implement the same resource, data-source, ephemeral resource or function.
+func ProviderForMuxV6(
+	ctx context.Context,
+	reg []registration.ServiceRegistration,
+	frameworkReg []registration.FrameworkServiceRegistration,
+	pf ConfigureFunc,
+) (func() tfprotov6.ProviderServer, error) {
+	combined := combineRegistrations(reg, frameworkReg)
+	if diags := ValidateRegistrations(combined); diags.HasError() {
+		return nil, &RegistrationError{Diagnostics: diags}
+	}
+
+	upgraded, err := tf5to6server.UpgradeServer(ctx, newProvider(combined, pf).GRPCProvider)
+	if err != nil {
+		return nil, fmt.Errorf("error upgrading the SDK v2.0 provider server: %w", err)
+	}
+
+	servers := make([]func() tfprotov6.ProviderServer, 0, len(frameworkReg)+1)
+	servers = append(servers, upgraded)
+	for _, service := range frameworkReg {
+		servers = append(servers, service.ProviderServer())
+	}
+
+	mux, err := tf6muxserver.NewMuxServer(ctx, servers...)
+	if err != nil {
+		return nil, fmt.Errorf("error muxing provider servers: %w", err)
+	}
+
+	// The mux server only reports conflicts between the servers in its responses, so get the schema now rather
+	// than failing when Terraform first calls the provider
+	resp, err := mux.ProviderServer().GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error muxing provider servers: %w", err)
+	}
+	if err := diagnosticsError(resp.Diagnostics); err != nil {
+		return nil, fmt.Errorf("error muxing provider servers: %w", err)
+	}
+
+	return mux.ProviderServer, nil
+}
+
+// ProviderSchemaV6 returns the protocol version 6 provider schema served by ProviderForMuxV6.  It is generated
+// from Schema() and the service blocks of both sets of registrations, and upgraded with tf5to6server so that it
+// is the same as the schema of the SDK v2.0 provider.  A *RegistrationError is returned if the registrations
+// conflict.
+func ProviderSchemaV6(
+	ctx context.Context,
+	reg []registration.ServiceRegistration,
+	frameworkReg []registration.FrameworkServiceRegistration,
+) (*tfprotov6.Schema, error) {
+	combined := combineRegistrations(reg, frameworkReg)
+	if diags := ValidateRegistrations(combined); diags.HasError() {
+		return nil, &RegistrationError{Diagnostics: diags}
+	}
+
+	p := &schema.Provider{
+		Schema: generateProviderSchema(combined),
+	}
+
+	upgraded, err := tf5to6server.UpgradeServer(ctx, p.GRPCProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := upgraded().GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := diagnosticsError(resp.Diagnostics); err != nil {
+		return nil, fmt.Errorf("error generating provider schema: %w", err)
+	}
+
+	return resp.Provider, nil
+}
+
+// diagnosticsError returns the error diagnostics in diags as an error, or nil if there aren't any
+func diagnosticsError(diags []*tfprotov6.Diagnostic) error {
+	var errs []error
+	for _, d := range diags {
+		if d != nil && d.Severity == tfprotov6.DiagnosticSeverityError {
+			errs = append(errs, fmt.Errorf("%s: %s", d.Summary, d.Detail))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// frameworkSchemaRegistration wraps a registration.FrameworkServiceRegistration so that its service block
+// and metadata are added to the SDK v2.0 provider.  It has no resources or data-sources, those are served by
+// the framework provider server.
+type frameworkSchemaRegistration struct {
+	registration.FrameworkServiceRegistration
+}
+
+func (f frameworkSchemaRegistration) SupportedDataSources() map[string]*schema.Resource {
+	return nil
+}
+
+func (f frameworkSchemaRegistration) SupportedResources() map[string]*schema.Resource {
+	return nil
+}
+
+// frameworkSchemaRegistrationWithMetadata is a frameworkSchemaRegistration for a framework service that
+// implements registration.ServiceMetadata, so that the metadata is used for its service block too
+type frameworkSchemaRegistrationWithMetadata struct {
+	frameworkSchemaRegistration
+	registration.ServiceMetadata
+}
+
+// combineRegistrations returns reg with the framework registrations appended
+func combineRegistrations(
+	reg []registration.ServiceRegistration,
+	frameworkReg []registration.FrameworkServiceRegistration,
+) []registration.ServiceRegistration {
+	combined := make([]registration.ServiceRegistration, 0, len(reg)+len(frameworkReg))
+	combined = append(combined, reg...)
+	for _, service := range frameworkReg {
+		if m, ok := registration.MetadataOf(service); ok {
+			combined = append(combined, frameworkSchemaRegistrationWithMetadata{frameworkSchemaRegistration{service}, m})
+
+			continue
+		}
+		combined = append(combined, frameworkSchemaRegistration{service})
+	}
+
+	return combined
+}
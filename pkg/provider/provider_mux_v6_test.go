@@ -0,0 +1,296 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+)
+
+type FrameworkRegistration struct {
+	serviceName string
+	server      tfprotov6.ProviderServer
+}
+
+func (r FrameworkRegistration) Name() string {
+	return r.serviceName
+}
+
+func (r FrameworkRegistration) ProviderSchemaEntry() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"workspace": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+		},
+	}
+}
+
+func (r FrameworkRegistration) ProviderServer() func() tfprotov6.ProviderServer {
+	return func() tfprotov6.ProviderServer {
+		return r.server
+	}
+}
+
+// fakeServerV6 is a protocol version 6 provider server that implements the methods used by the tests.  The
+// provider schema is set with setProviderSchema, since it must be the same as ProviderSchemaV6.
+type fakeServerV6 struct {
+	tfprotov6.ProviderServer
+	schema *tfprotov6.GetProviderSchemaResponse
+	config tftypes.Value
+}
+
+func newFakeServerV6(types ...string) *fakeServerV6 {
+	s := &fakeServerV6{
+		schema: &tfprotov6.GetProviderSchemaResponse{
+			ServerCapabilities: &tfprotov6.ServerCapabilities{GetProviderSchemaOptional: true},
+			ResourceSchemas:    make(map[string]*tfprotov6.Schema),
+			DataSourceSchemas:  make(map[string]*tfprotov6.Schema),
+		},
+	}
+	for _, t := range types {
+		s.schema.ResourceSchemas[t] = testSchemaV6("id")
+		s.schema.DataSourceSchemas[t] = testSchemaV6("id")
+	}
+
+	return s
+}
+
+// setProviderSchema sets the provider schema of the fake framework servers in fwRegs to ProviderSchemaV6
+func setProviderSchema(
+	t *testing.T,
+	regs []registration.ServiceRegistration,
+	fwRegs []registration.FrameworkServiceRegistration,
+) {
+	t.Helper()
+	s, err := ProviderSchemaV6(context.Background(), regs, fwRegs)
+	require.NoError(t, err)
+	for _, r := range fwRegs {
+		if server, ok := r.ProviderServer()().(*fakeServerV6); ok && server.schema.Provider == nil {
+			server.schema.Provider = s
+		}
+	}
+}
+
+func (s *fakeServerV6) GetMetadata(
+	_ context.Context,
+	_ *tfprotov6.GetMetadataRequest,
+) (*tfprotov6.GetMetadataResponse, error) {
+	resp := &tfprotov6.GetMetadataResponse{ServerCapabilities: s.schema.ServerCapabilities}
+	for t := range s.schema.ResourceSchemas {
+		resp.Resources = append(resp.Resources, tfprotov6.ResourceMetadata{TypeName: t})
+	}
+	for t := range s.schema.DataSourceSchemas {
+		resp.DataSources = append(resp.DataSources, tfprotov6.DataSourceMetadata{TypeName: t})
+	}
+
+	return resp, nil
+}
+
+func (s *fakeServerV6) GetProviderSchema(
+	_ context.Context,
+	_ *tfprotov6.GetProviderSchemaRequest,
+) (*tfprotov6.GetProviderSchemaResponse, error) {
+	return s.schema, nil
+}
+
+func (s *fakeServerV6) ConfigureProvider(
+	_ context.Context,
+	req *tfprotov6.ConfigureProviderRequest,
+) (*tfprotov6.ConfigureProviderResponse, error) {
+	v, err := req.Config.Unmarshal(s.schema.Provider.ValueType())
+	if err != nil {
+		return nil, err
+	}
+	s.config = v
+
+	return &tfprotov6.ConfigureProviderResponse{}, nil
+}
+
+// testSchemaV6 returns a schema with an optional string attribute for each name
+func testSchemaV6(names ...string) *tfprotov6.Schema {
+	s := &tfprotov6.Schema{Block: &tfprotov6.SchemaBlock{}}
+	for _, name := range names {
+		s.Block.Attributes = append(s.Block.Attributes, &tfprotov6.SchemaAttribute{
+			Name:     name,
+			Type:     tftypes.String,
+			Optional: true,
+		})
+	}
+
+	return s
+}
+
+func TestProviderForMuxV6(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name         string
+		regs         []registration.ServiceRegistration
+		fwRegs       func() []registration.FrameworkServiceRegistration
+		expResources []string
+		expError     string
+	}{
+		{
+			name: "sdk and framework services",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test-service",
+					resources: map[string]*schema.Resource{
+						"test-resource": testResource(),
+					},
+				},
+				// no resources or data-sources
+				Registration{
+					serviceName: "test-service2",
+				},
+			},
+			fwRegs: func() []registration.FrameworkServiceRegistration {
+				return []registration.FrameworkServiceRegistration{
+					FrameworkRegistration{
+						serviceName: "test-framework",
+						server:      newFakeServerV6("framework-resource"),
+					},
+				}
+			},
+			expResources: []string{"framework-resource", "test-resource"},
+		},
+		{
+			name: "framework services only",
+			fwRegs: func() []registration.FrameworkServiceRegistration {
+				return []registration.FrameworkServiceRegistration{
+					FrameworkRegistration{
+						serviceName: "test-framework",
+						server:      newFakeServerV6("framework-resource"),
+					},
+					FrameworkRegistration{
+						serviceName: "test-framework2",
+						server:      newFakeServerV6("framework-resource2"),
+					},
+				}
+			},
+			expResources: []string{"framework-resource", "framework-resource2"},
+		},
+		{
+			name: "repeated resource",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test-service",
+					resources: map[string]*schema.Resource{
+						"test-resource": testResource(),
+					},
+				},
+			},
+			fwRegs: func() []registration.FrameworkServiceRegistration {
+				return []registration.FrameworkServiceRegistration{
+					FrameworkRegistration{
+						serviceName: "test-framework",
+						server:      newFakeServerV6("test-resource"),
+					},
+				}
+			},
+			expError: "test-resource",
+		},
+		{
+			name: "framework provider schema differs",
+			fwRegs: func() []registration.FrameworkServiceRegistration {
+				server := newFakeServerV6()
+				server.schema.Provider = testSchemaV6("iam_token")
+
+				return []registration.FrameworkServiceRegistration{
+					FrameworkRegistration{
+						serviceName: "test-framework",
+						server:      server,
+					},
+				}
+			},
+			expError: "error muxing provider servers",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fwRegs := tc.fwRegs()
+			setProviderSchema(t, tc.regs, fwRegs)
+
+			server, err := ProviderForMuxV6(context.Background(), tc.regs, fwRegs, providerConfigure)
+			if tc.expError != "" {
+				// The conflicts are reported by the mux server
+				assert.ErrorContains(t, err, tc.expError)
+				assert.Nil(t, server)
+
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := server().GetMetadata(context.Background(), &tfprotov6.GetMetadataRequest{})
+			require.NoError(t, err)
+			resources := make([]string, 0, len(resp.Resources))
+			for _, r := range resp.Resources {
+				resources = append(resources, r.TypeName)
+			}
+			assert.ElementsMatch(t, tc.expResources, resources)
+
+			// The provider schema is the one generated from both sets of registrations
+			s, err := ProviderSchemaV6(context.Background(), tc.regs, fwRegs)
+			require.NoError(t, err)
+			schemaResp, err := server().GetProviderSchema(context.Background(), &tfprotov6.GetProviderSchemaRequest{})
+			require.NoError(t, err)
+			assert.Empty(t, schemaResp.Diagnostics)
+			assert.True(t, s.ValueType().Equal(schemaResp.Provider.ValueType()))
+		})
+	}
+}
+
+func TestProviderForMuxV6DuplicateServiceName(t *testing.T) {
+	t.Parallel()
+	regs := []registration.ServiceRegistration{Registration{serviceName: "test-service"}}
+	fwRegs := []registration.FrameworkServiceRegistration{FrameworkRegistration{serviceName: "test-service"}}
+
+	server, err := ProviderForMuxV6(context.Background(), regs, fwRegs, providerConfigure)
+	var regErr *RegistrationError
+	require.ErrorAs(t, err, &regErr)
+	assert.EqualError(t, err, "service name test-service is repeated")
+	assert.Nil(t, server)
+
+	_, err = ProviderSchemaV6(context.Background(), regs, fwRegs)
+	assert.ErrorAs(t, err, &regErr)
+}
+
+func TestProviderSchemaV6(t *testing.T) {
+	t.Parallel()
+	regs := []registration.ServiceRegistration{Registration{serviceName: "test-service"}}
+	fwRegs := []registration.FrameworkServiceRegistration{FrameworkRegistration{serviceName: "test-framework"}}
+
+	s, err := ProviderSchemaV6(context.Background(), regs, fwRegs)
+	assert.NoError(t, err)
+
+	attributes := make(map[string]*tfprotov6.SchemaAttribute)
+	for _, a := range s.Block.Attributes {
+		attributes[a.Name] = a
+	}
+	for k := range Schema() {
+		assert.Contains(t, attributes, k)
+	}
+
+	blocks := make(map[string]*tfprotov6.SchemaNestedBlock)
+	for _, b := range s.Block.BlockTypes {
+		blocks[b.TypeName] = b
+	}
+	assert.Contains(t, blocks, "test-service")
+	assert.Contains(t, blocks, "test-framework")
+	assert.Equal(t, tfprotov6.SchemaNestedBlockNestingModeSet, blocks["test-framework"].Nesting)
+	assert.Equal(t, int64(1), blocks["test-framework"].MaxItems)
+	assert.Equal(t, "workspace", blocks["test-framework"].Block.Attributes[0].Name)
+	assert.True(t, blocks["test-framework"].Block.Attributes[0].Required)
+}
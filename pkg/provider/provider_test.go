@@ -11,6 +11,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/transport"
 )
 
 func testResource() *schema.Resource {
@@ -156,18 +158,17 @@ func TestNewProviderFunc(t *testing.T) {
 				}
 			}
 
-			defer func() {
-				r := recover()
-				if r != nil {
-					if tc.panicMsg != "" {
-						assert.Equal(t, tc.panicMsg, r)
-					} else {
-						assert.Equal(t, nil, r)
-					}
-				}
-			}()
+			if tc.panicMsg != "" {
+				assert.PanicsWithValue(t, tc.panicMsg, func() {
+					NewProviderFunc(regs, providerConfigure)()
+				})
+
+				return
+			}
 
-			NewProviderFunc(regs, providerConfigure)()
+			assert.NotPanics(t, func() {
+				NewProviderFunc(regs, providerConfigure)()
+			})
 		})
 	}
 }
@@ -242,3 +243,84 @@ func TestValidateServiceURL(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateClientAuthMethod(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name     string
+		method   string
+		hasError bool
+	}{
+		{
+			name:     "client secret",
+			method:   string(clientauth.MethodClientSecret),
+			hasError: false,
+		},
+		{
+			name:     "private key JWT",
+			method:   string(clientauth.MethodPrivateKeyJWT),
+			hasError: false,
+		},
+		{
+			name:     "TLS client auth",
+			method:   string(clientauth.MethodTLSClientAuth),
+			hasError: false,
+		},
+		{
+			name:     "invalid method",
+			method:   "invalid",
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, es := ValidateClientAuthMethod(tc.method, "client_auth_method")
+			if tc.hasError {
+				assert.NotEmpty(t, es)
+			} else {
+				assert.Empty(t, es)
+			}
+		})
+	}
+}
+
+func TestValidateTLSMinVersion(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name     string
+		version  string
+		hasError bool
+	}{
+		{
+			name:     "TLS 1.2",
+			version:  transport.TLSVersion12,
+			hasError: false,
+		},
+		{
+			name:     "TLS 1.3",
+			version:  transport.TLSVersion13,
+			hasError: false,
+		},
+		{
+			name:     "invalid version",
+			version:  "1.0",
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, es := ValidateTLSMinVersion(tc.version, "tls_min_version")
+			if tc.hasError {
+				assert.NotEmpty(t, es)
+			} else {
+				assert.Empty(t, es)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+)
+
+// namePrefix is the prefix of all resource and data-source names, see the README
+const namePrefix = "hpegl_"
+
+// RegistrationError is returned when service registrations conflict, it holds every problem found by
+// ValidateRegistrations so that they can all be reported at once
+type RegistrationError struct {
+	Diagnostics diag.Diagnostics
+}
+
+// Error returns the summaries of the error diagnostics, warnings are left out
+func (e *RegistrationError) Error() string {
+	summaries := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		if d.Severity == diag.Error {
+			summaries = append(summaries, d.Summary)
+		}
+	}
+
+	if len(summaries) == 1 {
+		return summaries[0]
+	}
+
+	return fmt.Sprintf("%d registration conflicts: %s", len(summaries), strings.Join(summaries, "; "))
+}
+
+// ValidateRegistrations checks the service registrations and returns a diagnostic for every problem found,
+// rather than stopping at the first.  The following are errors:
+//   - a resource or data-source name that is empty, or that is repeated across services
+//   - a service name that is empty, repeated, or that is a key of the provider Schema(), these are only checked
+//     for services with a ProviderSchemaEntry() since the name is the key of the service block
+//   - registration.ServiceMetadata with an unknown maturity or IAM version
+//
+// Resource and data-source names that don't follow the hpegl_<service mnemonic>_<name> format are warnings.
+func ValidateRegistrations(reg []registration.ServiceRegistration) diag.Diagnostics {
+	var diags diag.Diagnostics
+	reserved := Schema()
+	services := make(map[string]bool)
+	dataSources := make(map[string]string)
+	resources := make(map[string]string)
+
+	for _, service := range reg {
+		name := service.Name()
+		hasBlock := service.ProviderSchemaEntry() != nil
+		switch {
+		case !hasBlock:
+			// The service doesn't contribute a service block, so its name can't clash
+		case name == "":
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "service name is empty",
+				Detail:   "Name() is the key of the service block in the provider schema, and must be set.",
+			})
+		case reserved[name] != nil:
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("service name %s is reserved by the provider schema", name),
+				Detail:   "The service block would shadow the provider argument of the same name.",
+			})
+		case services[name]:
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("service name %s is repeated", name),
+				Detail:   "Each service must have a unique Name().",
+			})
+		}
+		if hasBlock {
+			services[name] = true
+		}
+
+		diags = append(diags, validateMetadata(service)...)
+
+		diags = append(diags, validateNames("data-source", name, service.SupportedDataSources(), dataSources)...)
+		diags = append(diags, validateNames("resource", name, service.SupportedResources(), resources)...)
+	}
+
+	return diags
+}
+
+// validateNames checks the names of the resources or data-sources of service, seen holds the service of each
+// name already registered and is updated
+func validateNames(
+	kind, service string,
+	m map[string]*schema.Resource,
+	seen map[string]string,
+) diag.Diagnostics {
+	// Sort the names so that the diagnostics are in a stable order
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var diags diag.Diagnostics
+	for _, k := range names {
+		otherService, repeated := seen[k]
+		switch {
+		case k == "":
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("%s name is empty in service %s", kind, service),
+			})
+
+			continue
+		case repeated:
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("%s name %s is repeated in service %s", kind, k, service),
+				Detail:   fmt.Sprintf("The %s is already registered by service %s.", kind, otherService),
+			})
+
+			continue
+		}
+		seen[k] = service
+
+		if prefix := namePrefix + service + "_"; service != "" && !strings.HasPrefix(k, prefix) {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("%s name %s in service %s doesn't start with %s", kind, k, service, prefix),
+				Detail:   "Resource and data-source names should be hpegl_<service mnemonic>_<name>.",
+			})
+		}
+	}
+
+	return diags
+}
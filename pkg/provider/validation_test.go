@@ -0,0 +1,176 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/registration"
+)
+
+// noBlockRegistration is a service that doesn't contribute a service block
+type noBlockRegistration struct {
+	Registration
+}
+
+func (r noBlockRegistration) ProviderSchemaEntry() *schema.Resource {
+	return nil
+}
+
+func TestValidateRegistrations(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		regs        []registration.ServiceRegistration
+		expErrors   []string
+		expWarnings []string
+	}{
+		{
+			name: "valid",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test",
+					resources:   map[string]*schema.Resource{"hpegl_test_resource": testResource()},
+					datasources: map[string]*schema.Resource{"hpegl_test_datasource": testResource()},
+				},
+				Registration{
+					serviceName: "test2",
+					resources:   map[string]*schema.Resource{"hpegl_test2_resource": testResource()},
+				},
+			},
+		},
+		{
+			name: "missing prefix is a warning",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test",
+					resources:   map[string]*schema.Resource{"test_resource": testResource()},
+					datasources: map[string]*schema.Resource{"hpegl_other_datasource": testResource()},
+				},
+			},
+			expWarnings: []string{
+				"data-source name hpegl_other_datasource in service test doesn't start with hpegl_test_",
+				"resource name test_resource in service test doesn't start with hpegl_test_",
+			},
+		},
+		{
+			name: "all conflicts are reported",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test",
+					resources: map[string]*schema.Resource{
+						"hpegl_test_resource":  testResource(),
+						"hpegl_test_resource2": testResource(),
+					},
+					datasources: map[string]*schema.Resource{"hpegl_test_datasource": testResource()},
+				},
+				Registration{
+					serviceName: "test",
+					resources: map[string]*schema.Resource{
+						"hpegl_test_resource":  testResource(),
+						"hpegl_test_resource2": testResource(),
+					},
+					datasources: map[string]*schema.Resource{"hpegl_test_datasource": testResource()},
+				},
+				Registration{serviceName: "iam_token"},
+				Registration{
+					resources: map[string]*schema.Resource{"": testResource()},
+				},
+			},
+			expErrors: []string{
+				"service name test is repeated",
+				"data-source name hpegl_test_datasource is repeated in service test",
+				"resource name hpegl_test_resource is repeated in service test",
+				"resource name hpegl_test_resource2 is repeated in service test",
+				"service name iam_token is reserved by the provider schema",
+				"service name is empty",
+				"resource name is empty in service ",
+			},
+		},
+		{
+			name: "service names are only checked for services with a provider block",
+			regs: []registration.ServiceRegistration{
+				Registration{
+					serviceName: "test",
+					resources:   map[string]*schema.Resource{"hpegl_test_resource": testResource()},
+				},
+				noBlockRegistration{Registration{serviceName: "test"}},
+				noBlockRegistration{Registration{serviceName: "profile"}},
+				noBlockRegistration{},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var errs, warnings []string
+			for _, d := range ValidateRegistrations(tc.regs) {
+				if d.Severity == diag.Error {
+					errs = append(errs, d.Summary)
+				} else {
+					warnings = append(warnings, d.Summary)
+				}
+			}
+			assert.Equal(t, tc.expErrors, errs)
+			assert.Equal(t, tc.expWarnings, warnings)
+		})
+	}
+}
+
+func TestRegistrationError(t *testing.T) {
+	t.Parallel()
+	err := &RegistrationError{Diagnostics: diag.Diagnostics{
+		{Severity: diag.Error, Summary: "service name test is repeated"},
+		{Severity: diag.Warning, Summary: "resource name test_resource in service test doesn't start with hpegl_test_"},
+		{Severity: diag.Error, Summary: "service name iam_token is reserved by the provider schema"},
+	}}
+	assert.EqualError(t, err, "2 registration conflicts: service name test is repeated; "+
+		"service name iam_token is reserved by the provider schema")
+
+	err.Diagnostics = err.Diagnostics[:2]
+	assert.EqualError(t, err, "service name test is repeated")
+}
+
+func TestNewProviderFuncE(t *testing.T) {
+	t.Parallel()
+	regs := []registration.ServiceRegistration{
+		Registration{serviceName: "test", resources: map[string]*schema.Resource{"test_resource": testResource()}},
+	}
+	pf, diags := NewProviderFuncE(regs, providerConfigure)
+	require.False(t, diags.HasError())
+	assert.Len(t, diags, 1)
+	require.NotNil(t, pf)
+	assert.Contains(t, pf().ResourcesMap, "test_resource")
+
+	regs = append(regs, Registration{serviceName: "test"}, Registration{serviceName: "tenant_id"})
+	pf, diags = NewProviderFuncE(regs, providerConfigure)
+	assert.Nil(t, pf)
+	assert.True(t, diags.HasError())
+	assert.Len(t, diags, 3)
+}
+
+func TestProviderForMuxE(t *testing.T) {
+	t.Parallel()
+	regs := []registration.ServiceRegistration{
+		Registration{serviceName: "test", resources: map[string]*schema.Resource{"hpegl_test_resource": testResource()}},
+		Registration{serviceName: "test2"},
+	}
+	servers, diags := ProviderForMuxE(regs, providerConfigure)
+	assert.Empty(t, diags)
+	assert.Len(t, servers, 1)
+
+	regs = append(regs, Registration{serviceName: "user_id"})
+	servers, diags = ProviderForMuxE(regs, providerConfigure)
+	assert.Nil(t, servers)
+	assert.True(t, diags.HasError())
+	assert.PanicsWithValue(t, "service name user_id is reserved by the provider schema", func() {
+		ProviderForMux(regs, providerConfigure)
+	})
+}
@@ -0,0 +1,30 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package registration
+
+import (
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// FrameworkServiceRegistration is the registration interface for services that are built on the
+// Hashicorp plugin "framework" rather than SDK v2.0.  These services serve their resources and
+// data-sources over protocol version 6, and are muxed with the SDK v2.0 services by
+// provider.ProviderForMuxV6.
+type FrameworkServiceRegistration interface {
+	// Name is the name of this Service - a mnemonic.  The value will be used to
+	// set the name used for this service's entry in the provider schema
+	Name() string
+
+	// ProviderSchemaEntry returns the provider-level resource schema block for this service
+	// This is expressed as an SDK v2.0 *schema.Resource so that the provider schema shared by the
+	// SDK v2.0 and framework services can be generated from a single definition.  May be nil if the
+	// service doesn't need a service block.
+	ProviderSchemaEntry() *schema.Resource
+
+	// ProviderServer returns the protocol version 6 provider server for this service, normally
+	// created with providerserver.NewProtocol6 from the framework.  The provider schema served must
+	// be the same as provider.ProviderSchemaV6, since tf6muxserver requires all of the servers to
+	// serve the same provider schema.
+	ProviderServer() func() tfprotov6.ProviderServer
+}
@@ -0,0 +1,46 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package registration
+
+// Maturity is the maturity of a service
+type Maturity string
+
+const (
+	// MaturityGA is a generally available service, this is assumed if a service doesn't implement ServiceMetadata
+	MaturityGA Maturity = "ga"
+	// MaturityBeta is a service in beta, a warning is emitted when its service block is used
+	MaturityBeta Maturity = "beta"
+	// MaturityDeprecated is a deprecated service, its service block is marked as deprecated
+	MaturityDeprecated Maturity = "deprecated"
+)
+
+// Maturities returns the valid maturities
+func Maturities() []Maturity {
+	return []Maturity{MaturityGA, MaturityBeta, MaturityDeprecated}
+}
+
+// ServiceMetadata is an optional interface that a ServiceRegistration or FrameworkServiceRegistration can
+// implement to describe the service.  The provider package uses it to annotate the description of the service
+// block in the provider schema, to warn when the service block of a beta service is used, and to refuse
+// configuration when the iam_version in use isn't supported by a configured service.
+type ServiceMetadata interface {
+	// Version is the version of the service provider code, may be empty
+	Version() string
+
+	// Description is a human-readable description of the service, may be empty
+	Description() string
+
+	// Maturity is the maturity of the service, an empty Maturity is taken as MaturityGA
+	Maturity() Maturity
+
+	// SupportedIAMVersions returns the names of the IAM versions that the service supports, see
+	// pkg/token/iamversion.  If it is empty all IAM versions are supported.
+	SupportedIAMVersions() []string
+}
+
+// MetadataOf returns the ServiceMetadata of a service registration, if it implements it
+func MetadataOf(service interface{}) (ServiceMetadata, bool) {
+	m, ok := service.(ServiceMetadata)
+
+	return m, ok
+}
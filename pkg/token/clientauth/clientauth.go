@@ -0,0 +1,224 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package clientauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// Method is the way that an API client authenticates to IAM when requesting a token
+type Method string
+
+const (
+	// MethodClientSecret authenticates with client_id and client_secret in the request body, the default
+	MethodClientSecret Method = "client_secret"
+	// MethodPrivateKeyJWT authenticates with a JWT signed by the client's private key (RFC 7523)
+	MethodPrivateKeyJWT Method = "private_key_jwt"
+	// MethodTLSClientAuth authenticates with a TLS client certificate (RFC 8705)
+	MethodTLSClientAuth Method = "tls_client_auth"
+)
+
+// ClientAssertionType is the client_assertion_type sent with a private_key_jwt client assertion
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// assertionLifetime is how long a client assertion is valid for, it only needs to outlive the request
+const assertionLifetime = 5 * time.Minute
+
+// Methods returns the supported client authentication methods
+func Methods() []Method {
+	return []Method{MethodClientSecret, MethodPrivateKeyJWT, MethodTLSClientAuth}
+}
+
+// Config is the client authentication configuration taken from the provider fields
+type Config struct {
+	Method Method
+	// PrivateKey is a PEM-encoded private key used to sign client assertions, it takes precedence over
+	// PrivateKeyFile
+	PrivateKey string
+	// PrivateKeyFile is the path of a PEM-encoded private key used to sign client assertions
+	PrivateKeyFile string
+	// KeyID is the kid header of client assertions, it is optional
+	KeyID string
+	// CertificateFile is the path of the PEM-encoded TLS client certificate
+	CertificateFile string
+	// CertificateKeyFile is the path of the PEM-encoded private key of the TLS client certificate
+	CertificateKeyFile string
+}
+
+// Auth is a loaded client authentication method, it is safe for concurrent use
+type Auth struct {
+	method      Method
+	signer      jose.Signer
+	certificate *tls.Certificate
+}
+
+// Load reads the keys and certificates needed by c.Method.  A nil *Auth is returned for
+// MethodClientSecret, or if no method is set, since nothing needs to be loaded.
+func (c Config) Load() (*Auth, error) {
+	switch c.Method {
+	case "", MethodClientSecret:
+		return nil, nil //nolint:nilnil
+	case MethodPrivateKeyJWT:
+		signer, err := c.loadSigner()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Auth{method: c.Method, signer: signer}, nil
+	case MethodTLSClientAuth:
+		if c.CertificateFile == "" || c.CertificateKeyFile == "" {
+			return nil, fmt.Errorf("client authentication method %s needs a client certificate and key", c.Method)
+		}
+
+		certificate, err := tls.LoadX509KeyPair(c.CertificateFile, c.CertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+
+		return &Auth{method: c.Method, certificate: &certificate}, nil
+	default:
+		return nil, fmt.Errorf("invalid client authentication method %s, must be one of %v", c.Method, Methods())
+	}
+}
+
+// loadSigner parses the private key and creates a jose.Signer for it
+func (c Config) loadSigner() (jose.Signer, error) {
+	keyPEM := []byte(c.PrivateKey)
+	if len(keyPEM) == 0 {
+		if c.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("client authentication method %s needs a private key", c.Method)
+		}
+
+		var err error
+		keyPEM, err = os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading private key: %w", err)
+		}
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if c.KeyID != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), c.KeyID)
+	}
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+}
+
+// Method returns the client authentication method, it is MethodClientSecret for a nil *Auth
+func (a *Auth) Method() Method {
+	if a == nil {
+		return MethodClientSecret
+	}
+
+	return a.method
+}
+
+// Assertion returns an RFC 7523 client assertion for clientID, audience is the token URL.  An empty
+// assertion is returned if the method isn't MethodPrivateKeyJWT.
+func (a *Auth) Assertion(clientID, audience string) (string, error) {
+	if a == nil || a.signer == nil {
+		return "", nil
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{audience},
+		ID:       hex.EncodeToString(jti),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(assertionLifetime)),
+	}
+
+	return jwt.Signed(a.signer).Claims(claims).CompactSerialize()
+}
+
+// TLSCertificate returns the TLS client certificate, it is nil if the method isn't MethodTLSClientAuth
+func (a *Auth) TLSCertificate() *tls.Certificate {
+	if a == nil {
+		return nil
+	}
+
+	return a.certificate
+}
+
+// parsePrivateKey parses a PEM-encoded PKCS #8, PKCS #1 or SEC 1 private key
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM-encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("private key must be PKCS #8, PKCS #1 or SEC 1 encoded")
+}
+
+// signatureAlgorithm returns the JWS algorithm to sign with key
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+
+		return "", fmt.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
@@ -0,0 +1,203 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package clientauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	return path
+}
+
+func pkcs8PEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestPrivateKeyJWT(t *testing.T) {
+	t.Parallel()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name   string
+		config Config
+		public crypto.PublicKey
+		expAlg string
+	}{
+		{
+			name: "PKCS #1 RSA key",
+			config: Config{
+				PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+					Type:  "RSA PRIVATE KEY",
+					Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+				})),
+				KeyID: "kid",
+			},
+			public: rsaKey.Public(),
+			expAlg: "RS256",
+		},
+		{
+			name: "SEC 1 EC key file",
+			config: Config{
+				PrivateKeyFile: writeFile(t, "ec.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})),
+			},
+			public: ecKey.Public(),
+			expAlg: "ES384",
+		},
+		{
+			name:   "PKCS #8 Ed25519 key",
+			config: Config{PrivateKey: string(pkcs8PEM(t, edKey))},
+			public: edKey.Public(),
+			expAlg: "EdDSA",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.config.Method = MethodPrivateKeyJWT
+			a, err := tc.config.Load()
+			require.NoError(t, err)
+			assert.Equal(t, MethodPrivateKeyJWT, a.Method())
+			assert.Nil(t, a.TLSCertificate())
+
+			assertion, err := a.Assertion("clientID", "https://iam/token")
+			require.NoError(t, err)
+
+			parsed, err := jwt.ParseSigned(assertion)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expAlg, parsed.Headers[0].Algorithm)
+			assert.Equal(t, tc.config.KeyID, parsed.Headers[0].KeyID)
+
+			var claims jwt.Claims
+			require.NoError(t, parsed.Claims(tc.public, &claims))
+			assert.NoError(t, claims.Validate(jwt.Expected{
+				Issuer:   "clientID",
+				Subject:  "clientID",
+				Audience: jwt.Audience{"https://iam/token"},
+				Time:     time.Now(),
+			}))
+			assert.NotEmpty(t, claims.ID)
+		})
+	}
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "clientID"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	a, err := Config{
+		Method:             MethodTLSClientAuth,
+		CertificateFile:    writeFile(t, "cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CertificateKeyFile: writeFile(t, "key.pem", pkcs8PEM(t, key)),
+	}.Load()
+	require.NoError(t, err)
+	assert.Equal(t, MethodTLSClientAuth, a.Method())
+	require.NotNil(t, a.TLSCertificate())
+	assert.Equal(t, der, a.TLSCertificate().Certificate[0])
+
+	assertion, err := a.Assertion("clientID", "https://iam/token")
+	assert.NoError(t, err)
+	assert.Empty(t, assertion)
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name     string
+		config   Config
+		hasError bool
+	}{
+		{
+			name:   "default",
+			config: Config{},
+		},
+		{
+			name:   "client secret",
+			config: Config{Method: MethodClientSecret},
+		},
+		{
+			name:     "invalid method",
+			config:   Config{Method: "invalid"},
+			hasError: true,
+		},
+		{
+			name:     "private_key_jwt without a key",
+			config:   Config{Method: MethodPrivateKeyJWT},
+			hasError: true,
+		},
+		{
+			name:     "private_key_jwt with a missing key file",
+			config:   Config{Method: MethodPrivateKeyJWT, PrivateKeyFile: "missing.pem"},
+			hasError: true,
+		},
+		{
+			name:     "private_key_jwt with a key that isn't PEM-encoded",
+			config:   Config{Method: MethodPrivateKeyJWT, PrivateKey: "not-pem"},
+			hasError: true,
+		},
+		{
+			name:     "tls_client_auth without a certificate",
+			config:   Config{Method: MethodTLSClientAuth},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			a, err := tc.config.Load()
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Nil(t, a)
+			assert.Equal(t, MethodClientSecret, a.Method())
+		})
+	}
+}
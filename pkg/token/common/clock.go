@@ -0,0 +1,77 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package common
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for the token subsystem.  The real clock is used in normal operation, tests
+// can substitute a fake clock, see pkg/token/tokentest, so that expiry, retries and refreshes can be
+// tested without waiting.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a Timer that fires once d has passed
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of *time.Timer that is used with a Clock
+type Timer interface {
+	// C returns the channel on which the time is sent when the Timer fires
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing, it returns false if the Timer has already fired or been stopped
+	Stop() bool
+}
+
+// RealClock returns the Clock backed by the time package
+func RealClock() Clock {
+	return realClock{}
+}
+
+// ClockOrReal returns c, or the real clock if c is nil
+func ClockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock()
+	}
+
+	return c
+}
+
+// Sleep waits for d to pass on c, it returns ctx.Err() early if ctx is done first
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	timer := c.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// realTimer is the Timer backed by *time.Timer
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTimer) Stop() bool {
+	return r.t.Stop()
+}
@@ -1,7 +1,13 @@
-// (C) Copyright 2021 Hewlett Packard Enterprise Development LP
+// (C) Copyright 2021-2024 Hewlett Packard Enterprise Development LP
 
 package common
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
 const (
 	TokenRetrieveFunctionKey = "tokenRetrieveFunc"
 	// TimeToTokenExpiry is seconds in int64, not time.Second
@@ -9,6 +15,20 @@ const (
 	TimeToTokenExpiry = 120
 )
 
+// AccessToken a token generated by IAM, along with the details reported by IAM in the token response
+type AccessToken struct {
+	// Value is the access token itself, this may be an opaque string or a JWT
+	Value string
+	// TokenType is the token type reported by IAM, e.g. "Bearer"
+	TokenType string
+	// Scope is the scope of the token reported by IAM
+	Scope string
+	// Expiry is when the token expires, it is zero if IAM didn't report an expiry
+	Expiry time.Time
+	// RefreshToken is the refresh token issued alongside the access token, if any
+	RefreshToken string
+}
+
 // Result the result struct sent back on the resultCh of a token Handler
 type Result struct {
 	Token string
@@ -20,3 +40,17 @@ type Result struct {
 type TokenChannelInterface interface {
 	TokenChannels() (chan Result, chan int)
 }
+
+// TokenHandlerCloser is implemented by token Handlers whose retrieve thread can be stopped
+type TokenHandlerCloser interface {
+	Close(ctx context.Context) error
+}
+
+// TokenReader is implemented by token Handlers that cache tokens and can serve them to any number of
+// concurrent readers without going through the channels returned by TokenChannels
+type TokenReader interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ErrHandlerClosed is returned by a token retrieve function once the Handler retrieve thread has exited
+var ErrHandlerClosed = errors.New("token handler is closed")
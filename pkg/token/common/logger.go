@@ -0,0 +1,227 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Field keys used in the structured log entries of the token subsystem
+const (
+	LogFieldIAMVersion  = "iam_version"
+	LogFieldURLHost     = "url_host"
+	LogFieldGrantType   = "grant_type"
+	LogFieldClientID    = "client_id"
+	LogFieldAttempt     = "attempt"
+	LogFieldStatus      = "status_code"
+	LogFieldDelay       = "delay"
+	LogFieldTokenExpiry = "token_expiry"
+	LogFieldNextRefresh = "next_refresh"
+	LogFieldError       = "error"
+)
+
+// redactedValue replaces masked values
+const redactedValue = "***"
+
+// Logger is the structured logger used by the token subsystem.  The default is backed by tflog, so that
+// entries honour TF_LOG and TF_LOG_PROVIDER, but tests and other hosts can supply their own.  Whatever the
+// Logger, it is wrapped by LoggerOrDefault so that secrets are masked before they reach it.
+type Logger interface {
+	Debug(ctx context.Context, msg string, fields map[string]interface{})
+	Info(ctx context.Context, msg string, fields map[string]interface{})
+	Warn(ctx context.Context, msg string, fields map[string]interface{})
+	Error(ctx context.Context, msg string, fields map[string]interface{})
+}
+
+// TFLogger returns the Logger backed by tflog.  Note that tflog only writes entries for a context that
+// Terraform has set up, i.e. one derived from the context passed to a provider function, entries for other
+// contexts are written with the standard log package, which the plugin SDK sends to Terraform too.
+func TFLogger() Logger {
+	return tfLogger{}
+}
+
+// LoggerOrDefault returns l, or TFLogger() if l is nil, wrapped so that secrets are masked: the values of
+// sensitive fields such as client_secret, Authorization headers and anything that looks like a bearer
+// token, a JWT or a secret request parameter in the message or field values.
+func LoggerOrDefault(l Logger) Logger {
+	if l == nil {
+		l = TFLogger()
+	}
+
+	if _, ok := l.(redactingLogger); ok {
+		return l
+	}
+
+	return redactingLogger{next: l}
+}
+
+// tfLoggerSubsystem is the tflog subsystem used to check for a Terraform logger
+const tfLoggerSubsystem = "hpegl"
+
+// tfLogger is the Logger backed by tflog, it falls back to the standard log package
+type tfLogger struct{}
+
+func (tfLogger) Debug(ctx context.Context, msg string, fields map[string]interface{}) {
+	if !hasTFLogger(ctx) {
+		stdLog("DEBUG", msg, fields)
+
+		return
+	}
+	tflog.Debug(ctx, msg, fields)
+}
+
+func (tfLogger) Info(ctx context.Context, msg string, fields map[string]interface{}) {
+	if !hasTFLogger(ctx) {
+		stdLog("INFO", msg, fields)
+
+		return
+	}
+	tflog.Info(ctx, msg, fields)
+}
+
+func (tfLogger) Warn(ctx context.Context, msg string, fields map[string]interface{}) {
+	if !hasTFLogger(ctx) {
+		stdLog("WARN", msg, fields)
+
+		return
+	}
+	tflog.Warn(ctx, msg, fields)
+}
+
+func (tfLogger) Error(ctx context.Context, msg string, fields map[string]interface{}) {
+	if !hasTFLogger(ctx) {
+		stdLog("ERROR", msg, fields)
+
+		return
+	}
+	tflog.Error(ctx, msg, fields)
+}
+
+// hasTFLogger reports whether Terraform has set up a tflog logger in ctx.  tflog doesn't export this, but
+// NewSubsystem returns ctx unchanged if there is no logger.
+func hasTFLogger(ctx context.Context) bool {
+	return tflog.NewSubsystem(ctx, tfLoggerSubsystem) != ctx
+}
+
+// stdLog writes an entry with the standard log package, with the level prefix that the plugin SDK expects and
+// the fields in key order
+func stdLog(level, msg string, fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", level, msg)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+
+	log.Print(b.String())
+}
+
+// sensitiveFields are the field keys whose values are always masked, compared case-insensitively
+var sensitiveFields = map[string]bool{
+	"authorization":      true,
+	"client_secret":      true,
+	"user_secret":        true,
+	"client_assertion":   true,
+	"client_private_key": true,
+	"access_token":       true,
+	"refresh_token":      true,
+	"iam_token":          true,
+	"token":              true,
+}
+
+// sensitivePatterns match secrets in messages and field values, the first group of each is kept
+var sensitivePatterns = []*regexp.Regexp{
+	// Authorization header values
+	regexp.MustCompile(`(?i)((?:bearer|basic)\s+)[^\s"',]+`),
+	// Secret parameters in form and JSON request bodies
+	regexp.MustCompile(`(?i)((?:client_secret|client_assertion|access_token|refresh_token)(?:=|"\s*:\s*"))[^&"\s]+`),
+	// JWTs
+	regexp.MustCompile(`()eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+}
+
+// redactingLogger masks secrets before passing entries to next
+type redactingLogger struct {
+	next Logger
+}
+
+func (r redactingLogger) Debug(ctx context.Context, msg string, fields map[string]interface{}) {
+	r.next.Debug(ctx, redactString(msg), redactFields(fields))
+}
+
+func (r redactingLogger) Info(ctx context.Context, msg string, fields map[string]interface{}) {
+	r.next.Info(ctx, redactString(msg), redactFields(fields))
+}
+
+func (r redactingLogger) Warn(ctx context.Context, msg string, fields map[string]interface{}) {
+	r.next.Warn(ctx, redactString(msg), redactFields(fields))
+}
+
+func (r redactingLogger) Error(ctx context.Context, msg string, fields map[string]interface{}) {
+	r.next.Error(ctx, redactString(msg), redactFields(fields))
+}
+
+// redactString masks the secrets in s that match sensitivePatterns
+func redactString(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.ReplaceAllString(s, "${1}"+redactedValue)
+	}
+
+	return s
+}
+
+// redactFields returns a copy of fields with the sensitive values masked.  Strings, errors and
+// fmt.Stringers are converted to strings so that they can be checked for secrets.
+func redactFields(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if sensitiveFields[strings.ToLower(k)] {
+			redacted[k] = redactedValue
+
+			continue
+		}
+
+		switch value := v.(type) {
+		case string:
+			redacted[k] = redactString(value)
+		case error:
+			redacted[k] = redactString(value.Error())
+		case http.Header:
+			redacted[k] = redactHeader(value)
+		case fmt.Stringer:
+			redacted[k] = redactString(value.String())
+		default:
+			redacted[k] = v
+		}
+	}
+
+	return redacted
+}
+
+// redactHeader returns a copy of h with the values of sensitive headers masked
+func redactHeader(h http.Header) http.Header {
+	redacted := h.Clone()
+	for k := range redacted {
+		if sensitiveFields[strings.ToLower(k)] || strings.EqualFold(k, "Cookie") {
+			redacted[k] = []string{redactedValue}
+		}
+	}
+
+	return redacted
+}
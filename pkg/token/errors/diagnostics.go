@@ -0,0 +1,75 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package errors
+
+import (
+	stderrors "errors"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// tokenError is implemented by all of the error types in this package
+type tokenError interface {
+	error
+	base() *BaseError
+	recommendedActions() []string
+}
+
+// Diagnostics converts err into diag.Diagnostics for return from a provider function.  If err is, or wraps,
+// one of the error types in this package the summary is the error message and the detail renders the
+// ErrorResponse details, error code, HTTP status, request ID and recommended actions.  Types that carry no
+// recommended actions get defaults pointing at the provider fields to check.  Other errors are converted with
+// diag.FromErr.
+func Diagnostics(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var te tokenError
+	if !stderrors.As(err, &te) {
+		return diag.FromErr(err)
+	}
+
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  te.Error(),
+		Detail:   detail(te),
+	}}
+}
+
+// detail renders the detail of the diagnostic for te
+func detail(te tokenError) string {
+	b := te.base()
+	response := b.ErrorResponse
+
+	parts := make([]string, 0, 3)
+	if response.Details != "" {
+		parts = append(parts, response.Details)
+	}
+
+	codes := make([]string, 0, 3)
+	if response.ErrorCode != "" {
+		codes = append(codes, "Error code: "+response.ErrorCode)
+	}
+	if b.StatusCode != 0 {
+		codes = append(codes, "HTTP status: "+strconv.Itoa(b.StatusCode))
+	}
+	if b.RequestID != "" {
+		codes = append(codes, "Request ID: "+b.RequestID)
+	}
+	if len(codes) > 0 {
+		parts = append(parts, strings.Join(codes, "\n"))
+	}
+
+	actions := response.RecommendedActions
+	if len(actions) == 0 {
+		actions = te.recommendedActions()
+	}
+	if len(actions) > 0 {
+		parts = append(parts, "Recommended actions:\n  - "+strings.Join(actions, "\n  - "))
+	}
+
+	return strings.Join(parts, "\n\n")
+}
@@ -1,15 +1,23 @@
-// (C) Copyright 2021 Hewlett Packard Enterprise Development LP
+// (C) Copyright 2021-2024 Hewlett Packard Enterprise Development LP
 
 //nolint:lll
 package errors
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 // BaseError is an error type that all other error types embed.
 type BaseError struct {
 	ErrorResponse ErrorResponse
 	Info          string
 	OriginalError error
+	// StatusCode is the HTTP status of the IAM response that caused the error, if any
+	StatusCode int
+	// RequestID is the ID of the IAM request that caused the error, if IAM reported one.  It should be quoted
+	// in support tickets.
+	RequestID string
 }
 
 // ErrorResponse should be used to return details of a problem
@@ -46,6 +54,27 @@ func (e *BaseError) Error() string {
 	return "An error occurred."
 }
 
+// Unwrap returns the original error, if any, so that it can be matched with errors.Is and errors.As
+func (e *BaseError) Unwrap() error {
+	return e.OriginalError
+}
+
+// SetHTTPDetails records the HTTP status and request ID of the IAM response that caused the error
+func (e *BaseError) SetHTTPDetails(statusCode int, requestID string) {
+	e.StatusCode = statusCode
+	e.RequestID = requestID
+}
+
+// base returns the embedded BaseError, it is used to find token errors in a chain, see Diagnostics
+func (e *BaseError) base() *BaseError {
+	return e
+}
+
+// recommendedActions returns the actions suggested when the ErrorResponse doesn't have any
+func (e *BaseError) recommendedActions() []string {
+	return nil
+}
+
 type ErrBadRequest struct {
 	BaseError
 }
@@ -55,6 +84,21 @@ func MakeErrBadRequest(errorResponse ErrorResponse) *ErrBadRequest {
 	return &ErrBadRequest{BaseError{ErrorResponse: errorResponse}}
 }
 
+// Is reports whether target is an *ErrBadRequest, so that errors.Is(err, &ErrBadRequest{}) matches any
+// bad request
+func (e *ErrBadRequest) Is(target error) bool {
+	_, ok := target.(*ErrBadRequest)
+
+	return ok
+}
+
+func (e *ErrBadRequest) recommendedActions() []string {
+	return []string{
+		"Check that iam_version / HPEGL_IAM_VERSION matches the IAM at iam_service_url / HPEGL_IAM_SERVICE_URL",
+		"Check that api_vended_service_client / HPEGL_API_VENDED_SERVICE_CLIENT is set correctly for the API client",
+	}
+}
+
 // ErrForbidden is error type that can be returned from a function and propogated to be handled appropriately
 // Used to indicate insufficient access
 type ErrForbidden struct {
@@ -64,9 +108,29 @@ type ErrForbidden struct {
 
 // MakeErrForbidden helper to create ErrForbidden
 func MakeErrForbidden(forbiddenThings ...string) *ErrForbidden {
+	info := "Forbidden"
+	if things := strings.Join(forbiddenThings, ", "); things != "" {
+		info += ": " + things
+	}
+
 	return &ErrForbidden{
 		ForbiddenThings: forbiddenThings,
-		BaseError:       BaseError{Info: "Forbidden: " + strings.Join(forbiddenThings, ", ")},
+		BaseError:       BaseError{Info: info},
+	}
+}
+
+// Is reports whether target is an *ErrForbidden, so that errors.Is(err, &ErrForbidden{}) matches any
+// forbidden error
+func (e *ErrForbidden) Is(target error) bool {
+	_, ok := target.(*ErrForbidden)
+
+	return ok
+}
+
+func (e *ErrForbidden) recommendedActions() []string {
+	return []string{
+		"Check that the API client user_id / HPEGL_USER_ID has been assigned the roles needed",
+		"Check tenant_id / HPEGL_TENANT_ID",
 	}
 }
 
@@ -80,9 +144,29 @@ type ErrUnauthorized struct {
 
 // MakeErrUnauthorized helper to create ErrUnauthorized
 func MakeErrUnauthorized(reason string) *ErrUnauthorized {
+	info := "Unauthorized access"
+	if reason != "" {
+		info += ": " + reason
+	}
+
 	return &ErrUnauthorized{
 		UnauthorizedReason: reason,
-		BaseError:          BaseError{Info: "Unauthorized access: " + reason},
+		BaseError:          BaseError{Info: info},
+	}
+}
+
+// Is reports whether target is an *ErrUnauthorized, so that errors.Is(err, &ErrUnauthorized{}) matches any
+// unauthorized error
+func (e *ErrUnauthorized) Is(target error) bool {
+	_, ok := target.(*ErrUnauthorized)
+
+	return ok
+}
+
+func (e *ErrUnauthorized) recommendedActions() []string {
+	return []string{
+		"Check user_id / HPEGL_USER_ID and user_secret / HPEGL_USER_SECRET",
+		"Check that iam_service_url / HPEGL_IAM_SERVICE_URL is correct",
 	}
 }
 
@@ -97,3 +181,218 @@ type ErrInternalError struct {
 func MakeErrInternalError(errorResponse ErrorResponse) *ErrInternalError {
 	return &ErrInternalError{BaseError{ErrorResponse: errorResponse}}
 }
+
+// Is reports whether target is an *ErrInternalError, so that errors.Is(err, &ErrInternalError{}) matches
+// any internal error
+func (e *ErrInternalError) Is(target error) bool {
+	_, ok := target.(*ErrInternalError)
+
+	return ok
+}
+
+func (e *ErrInternalError) recommendedActions() []string {
+	return []string{
+		"Check that iam_service_url / HPEGL_IAM_SERVICE_URL is correct",
+		"Try again later, IAM may be unavailable",
+	}
+}
+
+// ErrMalformedJWT is an error type returned when a token can't be parsed as a JWT
+type ErrMalformedJWT struct {
+	BaseError
+}
+
+// MakeErrMalformedJWT helper to create ErrMalformedJWT
+func MakeErrMalformedJWT(err error) *ErrMalformedJWT {
+	return &ErrMalformedJWT{BaseError{Info: "oidc: malformed jwt: " + err.Error(), OriginalError: err}}
+}
+
+// Is reports whether target is an *ErrMalformedJWT
+func (e *ErrMalformedJWT) Is(target error) bool {
+	_, ok := target.(*ErrMalformedJWT)
+
+	return ok
+}
+
+func (e *ErrMalformedJWT) recommendedActions() []string {
+	return []string{"Check that iam_token / HPEGL_IAM_TOKEN is a complete access token"}
+}
+
+// ErrInvalidClaims is an error type returned when the claims in a JWT can't be decoded, or are invalid
+type ErrInvalidClaims struct {
+	BaseError
+}
+
+// MakeErrInvalidClaims helper to create ErrInvalidClaims
+func MakeErrInvalidClaims(err error) *ErrInvalidClaims {
+	return &ErrInvalidClaims{BaseError{Info: "oidc: invalid claims: " + err.Error(), OriginalError: err}}
+}
+
+// Is reports whether target is an *ErrInvalidClaims
+func (e *ErrInvalidClaims) Is(target error) bool {
+	_, ok := target.(*ErrInvalidClaims)
+
+	return ok
+}
+
+// ErrExpiredToken is an error type returned when a token has expired
+type ErrExpiredToken struct {
+	BaseError
+	Expiry time.Time
+}
+
+// MakeErrExpiredToken helper to create ErrExpiredToken
+func MakeErrExpiredToken(expiry time.Time) *ErrExpiredToken {
+	return &ErrExpiredToken{
+		Expiry:    expiry,
+		BaseError: BaseError{Info: "oidc: token expired at " + expiry.UTC().Format(time.RFC3339)},
+	}
+}
+
+// Is reports whether target is an *ErrExpiredToken
+func (e *ErrExpiredToken) Is(target error) bool {
+	_, ok := target.(*ErrExpiredToken)
+
+	return ok
+}
+
+func (e *ErrExpiredToken) recommendedActions() []string {
+	return []string{"Generate a new iam_token / HPEGL_IAM_TOKEN"}
+}
+
+// ErrInvalidSignature is an error type returned when the signature of a JWT can't be verified
+type ErrInvalidSignature struct {
+	BaseError
+}
+
+// MakeErrInvalidSignature helper to create ErrInvalidSignature
+func MakeErrInvalidSignature(err error) *ErrInvalidSignature {
+	return &ErrInvalidSignature{BaseError{Info: "oidc: failed to verify signature: " + err.Error(), OriginalError: err}}
+}
+
+// Is reports whether target is an *ErrInvalidSignature
+func (e *ErrInvalidSignature) Is(target error) bool {
+	_, ok := target.(*ErrInvalidSignature)
+
+	return ok
+}
+
+func (e *ErrInvalidSignature) recommendedActions() []string {
+	return []string{"Check that iam_token / HPEGL_IAM_TOKEN was issued by the IAM at iam_service_url / HPEGL_IAM_SERVICE_URL"}
+}
+
+// ErrInvalidGrant is an error type returned when IAM rejects the grant in a token request with an OAuth2
+// invalid_grant error, e.g. because a refresh token has expired or been revoked
+type ErrInvalidGrant struct {
+	BaseError
+}
+
+// MakeErrInvalidGrant helper to create ErrInvalidGrant
+func MakeErrInvalidGrant(errorResponse ErrorResponse) *ErrInvalidGrant {
+	return &ErrInvalidGrant{BaseError{ErrorResponse: errorResponse}}
+}
+
+// Is reports whether target is an *ErrInvalidGrant
+func (e *ErrInvalidGrant) Is(target error) bool {
+	_, ok := target.(*ErrInvalidGrant)
+
+	return ok
+}
+
+// ErrNotFound is an error type returned when IAM responds with a 404, e.g. because the token URL is wrong
+type ErrNotFound struct {
+	BaseError
+}
+
+// MakeErrNotFound helper to create ErrNotFound
+func MakeErrNotFound(errorResponse ErrorResponse) *ErrNotFound {
+	return &ErrNotFound{BaseError{ErrorResponse: errorResponse}}
+}
+
+// Is reports whether target is an *ErrNotFound, it also matches *ErrInternalError which was returned for a
+// 404 before this type was added
+func (e *ErrNotFound) Is(target error) bool {
+	switch target.(type) {
+	case *ErrNotFound, *ErrInternalError:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *ErrNotFound) recommendedActions() []string {
+	return []string{
+		"Check that iam_service_url / HPEGL_IAM_SERVICE_URL and iam_version / HPEGL_IAM_VERSION are correct",
+	}
+}
+
+// ErrConflict is an error type returned when IAM responds with a 409
+type ErrConflict struct {
+	BaseError
+}
+
+// MakeErrConflict helper to create ErrConflict
+func MakeErrConflict(errorResponse ErrorResponse) *ErrConflict {
+	return &ErrConflict{BaseError{ErrorResponse: errorResponse}}
+}
+
+// Is reports whether target is an *ErrConflict, it also matches *ErrInternalError which was returned for a
+// 409 before this type was added
+func (e *ErrConflict) Is(target error) bool {
+	switch target.(type) {
+	case *ErrConflict, *ErrInternalError:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrTooManyRequests is an error type returned when IAM responds with a 429, i.e. it is rate-limiting requests
+type ErrTooManyRequests struct {
+	BaseError
+}
+
+// MakeErrTooManyRequests helper to create ErrTooManyRequests
+func MakeErrTooManyRequests(errorResponse ErrorResponse) *ErrTooManyRequests {
+	return &ErrTooManyRequests{BaseError{ErrorResponse: errorResponse}}
+}
+
+// Is reports whether target is an *ErrTooManyRequests, it also matches *ErrInternalError which was returned
+// for a 429 before this type was added
+func (e *ErrTooManyRequests) Is(target error) bool {
+	switch target.(type) {
+	case *ErrTooManyRequests, *ErrInternalError:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *ErrTooManyRequests) recommendedActions() []string {
+	return []string{"Try again later, IAM is rate-limiting requests"}
+}
+
+// ErrServerError is an error type returned when IAM responds with a 5xx status
+type ErrServerError struct {
+	BaseError
+}
+
+// MakeErrServerError helper to create ErrServerError
+func MakeErrServerError(errorResponse ErrorResponse) *ErrServerError {
+	return &ErrServerError{BaseError{ErrorResponse: errorResponse}}
+}
+
+// Is reports whether target is an *ErrServerError, it also matches *ErrInternalError which was returned for
+// a 5xx status before this type was added
+func (e *ErrServerError) Is(target error) bool {
+	switch target.(type) {
+	case *ErrServerError, *ErrInternalError:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *ErrServerError) recommendedActions() []string {
+	return []string{"Try again later, IAM may be unavailable"}
+}
@@ -0,0 +1,107 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIs(t *testing.T) {
+	t.Parallel()
+	wrapped := fmt.Errorf("error retrieving token: %w", MakeErrUnauthorized("clientID"))
+
+	assert.True(t, stderrors.Is(wrapped, &ErrUnauthorized{}))
+	assert.False(t, stderrors.Is(wrapped, &ErrForbidden{}))
+	assert.True(t, stderrors.Is(MakeErrForbidden("clientID"), &ErrForbidden{}))
+	assert.True(t, stderrors.Is(MakeErrBadRequest(ErrorResponse{}), &ErrBadRequest{}))
+	assert.True(t, stderrors.Is(MakeErrInternalError(ErrorResponse{}), &ErrInternalError{}))
+	assert.True(t, stderrors.Is(MakeErrExpiredToken(time.Now()), &ErrExpiredToken{}))
+	assert.True(t, stderrors.Is(MakeErrInvalidGrant(ErrorResponse{}), &ErrInvalidGrant{}))
+
+	// The original error is unwrapped
+	original := stderrors.New("bad payload")
+	assert.ErrorIs(t, MakeErrMalformedJWT(original), original)
+	assert.ErrorIs(t, MakeErrMalformedJWT(original), &ErrMalformedJWT{})
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name    string
+		err     error
+		expDiag diag.Diagnostics
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:    "other error",
+			err:     stderrors.New("other"),
+			expDiag: diag.Diagnostics{{Severity: diag.Error, Summary: "other"}},
+		},
+		{
+			name: "wrapped unauthorized error with default actions",
+			err:  fmt.Errorf("error retrieving token: %w", MakeErrUnauthorized("clientID")),
+			expDiag: diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  "Unauthorized access: clientID",
+				Detail: "Recommended actions:\n" +
+					"  - Check user_id / HPEGL_USER_ID and user_secret / HPEGL_USER_SECRET\n" +
+					"  - Check that iam_service_url / HPEGL_IAM_SERVICE_URL is correct",
+			}},
+		},
+		{
+			name: "error response",
+			err: MakeErrBadRequest(ErrorResponse{
+				Message:            "Bad request",
+				Details:            "tenant_id is required",
+				ErrorCode:          "ErrGenerateTokenBadRequest",
+				RecommendedActions: []string{"Set tenant_id"},
+			}),
+			expDiag: diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  "Bad request",
+				Detail: "tenant_id is required\n\n" +
+					"Error code: ErrGenerateTokenBadRequest\n\n" +
+					"Recommended actions:\n  - Set tenant_id",
+			}},
+		},
+		{
+			name: "HTTP details",
+			err: func() error {
+				e := MakeErrServerError(ErrorResponse{Message: "Server error 503", ErrorCode: "ErrGenerateTokenServerError"})
+				e.SetHTTPDetails(503, "req-1")
+
+				return e
+			}(),
+			expDiag: diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  "Server error 503",
+				Detail: "Error code: ErrGenerateTokenServerError\nHTTP status: 503\nRequest ID: req-1\n\n" +
+					"Recommended actions:\n  - Try again later, IAM may be unavailable",
+			}},
+		},
+		{
+			name: "no recommended actions",
+			err:  MakeErrInvalidClaims(stderrors.New("missing exp claim")),
+			expDiag: diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  "oidc: invalid claims: missing exp claim",
+			}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expDiag, Diagnostics(tc.err))
+		})
+	}
+}
@@ -4,11 +4,14 @@ package httpclient
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/identitytoken"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/issuertoken"
 	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
 )
@@ -18,36 +21,197 @@ type Client struct {
 	identityServiceURL  string
 	httpClient          tokenutil.HttpClient
 	vendedServiceClient bool
+	// clientAuth is how the client authenticates to IAM, it is nil for client secrets
+	clientAuth *clientauth.Auth
+	// transport is the base transport for IAM calls, it is nil for the default transport
+	transport *http.Transport
+	// retryPolicy is the retry policy for IAM calls
+	retryPolicy tokenutil.RetryPolicy
+	// clock is used to time IAM calls
+	clock common.Clock
+	// logger logs IAM calls
+	logger common.Logger
+}
+
+// CreateOpt - function option definition
+type CreateOpt func(c *Client)
+
+// WithClientAuth set the client authentication method used to request tokens, if it isn't set the client
+// authenticates with its secret
+func WithClientAuth(a *clientauth.Auth) CreateOpt {
+	return func(c *Client) {
+		c.clientAuth = a
+	}
+}
+
+// WithTransport set the base transport used for IAM calls, e.g. one returned by transport.Shared.  The
+// transport isn't modified, it is cloned if a TLS client certificate has to be added.
+func WithTransport(t *http.Transport) CreateOpt {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithRetryPolicy set the tokenutil.RetryPolicy used for IAM calls, the default is
+// tokenutil.DefaultRetryPolicy()
+func WithRetryPolicy(p tokenutil.RetryPolicy) CreateOpt {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// WithClock set the common.Clock used to time IAM calls and wait between retries, the default is
+// common.RealClock()
+func WithClock(clock common.Clock) CreateOpt {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// WithLogger set the common.Logger used to log IAM calls, the default is common.TFLogger()
+func WithLogger(l common.Logger) CreateOpt {
+	return func(c *Client) {
+		c.logger = l
+	}
 }
 
 // New creates a new identity Client object
-func New(identityServiceURL string, vendedServiceClient bool, passedInToken string) *Client {
-	client := &http.Client{Timeout: 120 * time.Second}
+func New(identityServiceURL string, vendedServiceClient bool, passedInToken string, opts ...CreateOpt) *Client {
 	identityServiceURL = strings.TrimRight(identityServiceURL, "/")
 
-	return &Client{
+	c := &Client{
 		passedInToken:       passedInToken,
 		identityServiceURL:  identityServiceURL,
-		httpClient:          client,
 		vendedServiceClient: vendedServiceClient,
+		retryPolicy:         tokenutil.DefaultRetryPolicy(),
+		clock:               common.RealClock(),
+	}
+
+	// run overrides
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
 	}
+
+	c.httpClient = newHTTPClient(c.transport, c.clientAuth.TLSCertificate())
+
+	return c
 }
 
-func (c *Client) GenerateToken(ctx context.Context, tenantID, clientID, clientSecret, iamVersion string) (string, error) {
-	// we don't have a passed-in token, so we need to actually generate a token
-	if c.passedInToken == "" {
-		if c.vendedServiceClient {
-			token, err := issuertoken.GenerateToken(
-				ctx, clientID, clientSecret, c.identityServiceURL, c.httpClient, iamVersion)
+// newHTTPClient creates the http.Client used for IAM calls with base as its transport, presenting
+// certificate if it isn't nil
+func newHTTPClient(base *http.Transport, certificate *tls.Certificate) *http.Client {
+	client := &http.Client{Timeout: 120 * time.Second}
+	if base != nil {
+		client.Transport = base
+	}
 
-			return token, err
-		}
+	if certificate == nil {
+		return client
+	}
+
+	// Don't modify base, it may be shared
+	var transport *http.Transport
+	if base != nil {
+		transport = base.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{*certificate}
+	client.Transport = transport
+
+	return client
+}
 
-		token, err := identitytoken.GenerateToken(ctx, tenantID, clientID, clientSecret, c.identityServiceURL, c.httpClient)
+// GenerateToken generates a token from IAM, or returns the passed-in token if there is one.  Note that
+// no expiry is reported for a passed-in token.
+func (c *Client) GenerateToken(
+	ctx context.Context,
+	tenantID,
+	clientID,
+	clientSecret,
+	iamVersion string,
+) (common.AccessToken, error) {
+	// we don't have a passed-in token, so we need to actually generate a token, the request is built
+	// by the iamversion.IAMVersion registered for iamVersion
+	if c.passedInToken == "" {
+		creds := iamversion.Credentials{
+			TenantID:            tenantID,
+			ClientID:            clientID,
+			ClientSecret:        clientSecret,
+			VendedServiceClient: c.vendedServiceClient,
+		}
 
-		return token, err
+		return c.requestToken(ctx, creds, iamVersion)
 	}
 
 	// we have a passed-in token, return it
-	return c.passedInToken, nil
+	return common.AccessToken{Value: c.passedInToken}, nil
+}
+
+// RefreshToken renews a token from IAM with the refresh_token grant, or returns the passed-in token if
+// there is one.  An *errors.ErrInvalidGrant is returned if IAM rejects refreshToken.
+func (c *Client) RefreshToken(
+	ctx context.Context,
+	tenantID,
+	clientID,
+	clientSecret,
+	refreshToken,
+	iamVersion string,
+) (common.AccessToken, error) {
+	if c.passedInToken != "" {
+		return common.AccessToken{Value: c.passedInToken}, nil
+	}
+
+	creds := iamversion.Credentials{
+		TenantID:            tenantID,
+		ClientID:            clientID,
+		ClientSecret:        clientSecret,
+		VendedServiceClient: c.vendedServiceClient,
+		RefreshToken:        refreshToken,
+	}
+
+	return c.requestToken(ctx, creds, iamVersion)
+}
+
+// requestToken adds the client authentication details to creds and requests a token from IAM.  A
+// private_key_jwt client assertion is created for every request, its audience is the token URL.  The IAM
+// version decides how non-API-vended service clients are handled, and may reject them.
+func (c *Client) requestToken(
+	ctx context.Context,
+	creds iamversion.Credentials,
+	iamVersion string,
+) (common.AccessToken, error) {
+	switch c.clientAuth.Method() {
+	case clientauth.MethodPrivateKeyJWT:
+		version, err := iamversion.Lookup(iamVersion)
+		if err != nil {
+			return common.AccessToken{}, err
+		}
+
+		creds.ClientAssertion, err = c.clientAuth.Assertion(creds.ClientID, version.TokenURL(c.identityServiceURL))
+		if err != nil {
+			return common.AccessToken{}, err
+		}
+	case clientauth.MethodTLSClientAuth:
+		creds.TLSClientAuth = true
+	case clientauth.MethodClientSecret:
+		// the client secret is sent in the request body
+	}
+
+	return issuertoken.GenerateTokenWithCredentials(
+		ctx,
+		creds,
+		c.identityServiceURL,
+		c.httpClient,
+		iamVersion,
+		issuertoken.WithRetryPolicy(c.retryPolicy),
+		issuertoken.WithClock(c.clock),
+		issuertoken.WithLogger(c.logger),
+	)
 }
@@ -5,15 +5,25 @@ package httpclient
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/identitytoken"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/issuertoken"
 )
@@ -115,33 +125,43 @@ func setTestCase() ([]testCaseIssuer, []testCaseIdentity) {
 					AccessToken: "access-token",
 				},
 			},
+			{
+				name:       "success expires_in",
+				ctx:        context.Background(),
+				url:        "https://hpe-greenlake-tenant.okta.com/oauth2/default",
+				statusCode: http.StatusOK,
+				token: issuertoken.TokenResponse{
+					AccessToken: "access-token",
+					ExpiresIn:   900,
+				},
+			},
 			{
 				name:       "status code 404",
 				url:        "https://hpe-greenlake-tenant.okta.com/oauth2/default",
 				ctx:        context.Background(),
 				statusCode: http.StatusNotFound,
-				err:        errors.New("Unexpected status code 404"),
+				err:        errors.New("Not found: unrecognised response body: {\"token_type\":\"\",\"expires_in\":0,\"access_token\":\"\",\"scope\":\"\"}"),
 			},
 			{
 				name:       "status code 400",
 				url:        "https://hpe-greenlake-tenant.okta.com/oauth2/default",
 				ctx:        context.Background(),
 				statusCode: http.StatusBadRequest,
-				err:        errors.New("Bad request: {\"token_type\":\"\",\"expires_in\":0,\"access_token\":\"\",\"scope\":\"\"}"),
+				err:        errors.New("Bad request: unrecognised response body: {\"token_type\":\"\",\"expires_in\":0,\"access_token\":\"\",\"scope\":\"\"}"),
 			},
 			{
 				name:       "status code 401",
 				url:        "https://hpe-greenlake-tenant.okta.com/oauth2/default",
 				ctx:        context.Background(),
 				statusCode: http.StatusUnauthorized,
-				err:        errors.New("Unauthorized access: "),
+				err:        errors.New("Unauthorized access: unrecognised response body: {\"token_type\":\"\",\"expires_in\":0,\"access_token\":\"\",\"scope\":\"\"}"),
 			},
 			{
 				name:       "status code 403",
 				url:        "https://hpe-greenlake-tenant.okta.com/oauth2/default",
 				ctx:        context.Background(),
 				statusCode: http.StatusForbidden,
-				err:        errors.New("Forbidden: "),
+				err:        errors.New("Forbidden: unrecognised response body: {\"token_type\":\"\",\"expires_in\":0,\"access_token\":\"\",\"scope\":\"\"}"),
 			},
 		}, []testCaseIdentity{
 			{
@@ -153,33 +173,44 @@ func setTestCase() ([]testCaseIssuer, []testCaseIdentity) {
 					AccessToken: "access-token",
 				},
 			},
+			{
+				name:       "success expiry",
+				ctx:        context.Background(),
+				url:        "https://client.greenlake.hpe.com/api/iam/identity",
+				statusCode: http.StatusOK,
+				token: identitytoken.TokenResponse{
+					AccessToken:  "access-token",
+					RefreshToken: "refresh-token",
+					Expiry:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
 			{
 				name:       "status code 404",
 				url:        "https://client.greenlake.hpe.com/api/iam/identity",
 				ctx:        context.Background(),
 				statusCode: http.StatusNotFound,
-				err:        errors.New("Unexpected status code 404"),
+				err:        errors.New("Not found: unrecognised response body: {\"token_type\":\"\",\"access_token\":\"\",\"refresh_token\":\"\",\"expiry\":\"0001-01-01T00:00:00Z\",\"expires_in\":0,\"scope\":\"\",\"accessTokenOnly\":false}"),
 			},
 			{
 				name:       "status code 400",
 				url:        "https://client.greenlake.hpe.com/api/iam/identity",
 				ctx:        context.Background(),
 				statusCode: http.StatusBadRequest,
-				err:        errors.New("Bad request: {\"token_type\":\"\",\"access_token\":\"\",\"refresh_token\":\"\",\"expiry\":\"0001-01-01T00:00:00Z\",\"expires_in\":0,\"scope\":\"\",\"accessTokenOnly\":false}"),
+				err:        errors.New("Bad request: unrecognised response body: {\"token_type\":\"\",\"access_token\":\"\",\"refresh_token\":\"\",\"expiry\":\"0001-01-01T00:00:00Z\",\"expires_in\":0,\"scope\":\"\",\"accessTokenOnly\":false}"),
 			},
 			{
 				name:       "status code 401",
 				url:        "https://client.greenlake.hpe.com/api/iam/identity",
 				ctx:        context.Background(),
 				statusCode: http.StatusUnauthorized,
-				err:        errors.New("Unauthorized access: "),
+				err:        errors.New("Unauthorized access: unrecognised response body: {\"token_type\":\"\",\"access_token\":\"\",\"refresh_token\":\"\",\"expiry\":\"0001-01-01T00:00:00Z\",\"expires_in\":0,\"scope\":\"\",\"accessTokenOnly\":false}"),
 			},
 			{
 				name:       "status code 403",
 				url:        "https://client.greenlake.hpe.com/api/iam/identity",
 				ctx:        context.Background(),
 				statusCode: http.StatusForbidden,
-				err:        errors.New("Forbidden: "),
+				err:        errors.New("Forbidden: unrecognised response body: {\"token_type\":\"\",\"access_token\":\"\",\"refresh_token\":\"\",\"expiry\":\"0001-01-01T00:00:00Z\",\"expires_in\":0,\"scope\":\"\",\"accessTokenOnly\":false}"),
 			},
 		}
 }
@@ -200,7 +231,8 @@ func TestGenerateToken(t *testing.T) {
 			assert.EqualError(t, err, tc.err.Error())
 		}
 
-		assert.Equal(t, tc.token.AccessToken, token)
+		assert.Equal(t, tc.token.AccessToken, token.Value)
+		assert.Equal(t, tc.token.ExpiresIn == 0, token.Expiry.IsZero())
 	}
 
 	// Tests for identitytoken package
@@ -214,7 +246,9 @@ func TestGenerateToken(t *testing.T) {
 			assert.EqualError(t, err, tc.err.Error())
 		}
 
-		assert.Equal(t, tc.token.AccessToken, token)
+		assert.Equal(t, tc.token.AccessToken, token.Value)
+		assert.Equal(t, tc.token.RefreshToken, token.RefreshToken)
+		assert.True(t, tc.token.Expiry.Equal(token.Expiry))
 	}
 }
 
@@ -223,6 +257,132 @@ func TestGenerateTokenPassedInToken(t *testing.T) {
 	c := createTestClient("", "testToken", http.StatusAccepted, nil, true)
 
 	token, err := c.GenerateToken(context.Background(), "", "", "", "")
-	assert.Equal(t, "testToken", token)
+	assert.Equal(t, "testToken", token.Value)
+	assert.True(t, token.Expiry.IsZero())
 	assert.NoError(t, err)
 }
+
+// recordingHTTPClient records the form of the last request and returns an empty token
+type recordingHTTPClient struct {
+	form url.Values
+}
+
+func (r *recordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	r.form = req.PostForm
+
+	return &http.Response{StatusCode: http.StatusOK, Body: &bodyReadCloser{body: []byte(`{}`)}}, nil
+}
+
+func TestGenerateTokenPrivateKeyJWT(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	auth, err := clientauth.Config{
+		Method:     clientauth.MethodPrivateKeyJWT,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}.Load()
+	require.NoError(t, err)
+
+	c := New("https://iam.example.com/", true, "", WithClientAuth(auth))
+	recorder := &recordingHTTPClient{}
+	c.httpClient = recorder
+
+	_, err = c.GenerateToken(context.Background(), "", "clientID", "clientSecret", string(provider.IAMVersionGLCS))
+	require.NoError(t, err)
+
+	// The secret isn't sent, the client assertion is signed by key and its audience is the token URL
+	assert.Empty(t, recorder.form.Get("client_secret"))
+	assert.Equal(t, clientauth.ClientAssertionType, recorder.form.Get("client_assertion_type"))
+	parsed, err := jwt.ParseSigned(recorder.form.Get("client_assertion"))
+	require.NoError(t, err)
+	var claims jwt.Claims
+	require.NoError(t, parsed.Claims(key.Public(), &claims))
+	assert.Equal(t, jwt.Audience{"https://iam.example.com/v1/token"}, claims.Audience)
+	assert.Equal(t, "clientID", claims.Subject)
+}
+
+// urlRecordingHTTPClient records the URL and Content-Type of the last request and returns an empty token
+type urlRecordingHTTPClient struct {
+	url         string
+	contentType string
+}
+
+func (r *urlRecordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	r.url = req.URL.String()
+	r.contentType = req.Header.Get("Content-Type")
+
+	return &http.Response{StatusCode: http.StatusOK, Body: &bodyReadCloser{body: []byte(`{}`)}}, nil
+}
+
+func TestGenerateTokenNonVendedIAMVersion(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name       string
+		iamVersion string
+		vended     bool
+		expURL     string
+		expErr     string
+	}{
+		{
+			name:       "GLCS non-API-vended",
+			iamVersion: string(provider.IAMVersionGLCS),
+			expURL:     "https://iam.example.com/v1/token",
+		},
+		{
+			name:       "GLP non-API-vended is rejected",
+			iamVersion: string(provider.IAMVersionGLP),
+			expErr:     "IAM version glp only supports API-vended service clients",
+		},
+		{
+			name:       "GLP API-vended",
+			iamVersion: string(provider.IAMVersionGLP),
+			vended:     true,
+			expURL:     "https://iam.example.com",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c := New("https://iam.example.com/", tc.vended, "")
+			recorder := &urlRecordingHTTPClient{}
+			c.httpClient = recorder
+
+			_, err := c.GenerateToken(context.Background(), "tenantID", "clientID", "clientSecret", tc.iamVersion)
+			if tc.expErr != "" {
+				require.EqualError(t, err, tc.expErr)
+				assert.Empty(t, recorder.url)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expURL, recorder.url)
+			if !tc.vended {
+				assert.Equal(t, "application/json", recorder.contentType)
+			}
+		})
+	}
+}
+
+func TestNewWithTransport(t *testing.T) {
+	t.Parallel()
+	base := &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS13}} //nolint:gosec
+
+	// The base transport is used as-is without a client certificate
+	c := New("https://iam.example.com", true, "", WithTransport(base))
+	assert.Same(t, base, c.httpClient.(*http.Client).Transport)
+
+	// The base transport is cloned, not modified, to add a client certificate
+	certificate := &tls.Certificate{Certificate: [][]byte{[]byte("cert")}}
+	httpClient := newHTTPClient(base, certificate)
+	transport, ok := httpClient.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.NotSame(t, base, transport)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+	assert.Len(t, transport.TLSClientConfig.Certificates, 1)
+	assert.Empty(t, base.TLSClientConfig.Certificates)
+}
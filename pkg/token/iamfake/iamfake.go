@@ -0,0 +1,456 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package iamfake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+const (
+	// GLCSTokenPath is the path of the GLCS token endpoint, relative to the iam_service_url
+	GLCSTokenPath = "/v1/token"
+	// GLPTokenPath is the path of the GLP token endpoint, the iam_service_url for GLP is the full token URL
+	GLPTokenPath = "/token"
+	// JWKSPath is the path of the JWKS, it is advertised in the OIDC discovery document
+	JWKSPath = "/jwks"
+	// DiscoveryPath is the path of the OIDC discovery document
+	DiscoveryPath = "/.well-known/openid-configuration"
+	// KeyID is the kid of the key that tokens are signed with
+	KeyID = "iamfake"
+)
+
+const (
+	defaultTokenLifetime = time.Hour
+	contentTypeJSON      = "application/json"
+)
+
+// Server is a fake IAM server for tests.  It serves the GLCS and GLP token endpoints, accepting both form and
+// JSON request bodies, and mints access tokens that are JWTs signed with an RSA key whose JWKS is served for
+// verification.  Failures can be scripted with FailNext and the requests received are recorded.
+type Server struct {
+	*httptest.Server
+
+	key   *rsa.PrivateKey
+	clock common.Clock
+
+	mu            sync.Mutex
+	tokenLifetime time.Duration
+	claims        map[string]interface{}
+	clientID      string
+	clientSecret  string
+	refreshTokens bool
+	issued        map[string]string
+	failures      []Failure
+	requests      []Request
+}
+
+// Option is an option for New
+type Option func(s *Server)
+
+// WithTokenLifetime set the lifetime of the access tokens minted, the default is 1 hour
+func WithTokenLifetime(d time.Duration) Option {
+	return func(s *Server) {
+		s.tokenLifetime = d
+	}
+}
+
+// WithClaims set extra claims to add to the access tokens minted, these override the standard claims
+func WithClaims(claims map[string]interface{}) Option {
+	return func(s *Server) {
+		s.claims = claims
+	}
+}
+
+// WithCredentials set the client id and secret that are accepted, requests with other credentials get a 401.
+// By default any credentials are accepted.
+func WithCredentials(clientID, clientSecret string) Option {
+	return func(s *Server) {
+		s.clientID = clientID
+		s.clientSecret = clientSecret
+	}
+}
+
+// WithRefreshTokens issue a refresh token with every access token, and accept the refresh_token grant
+func WithRefreshTokens() Option {
+	return func(s *Server) {
+		s.refreshTokens = true
+	}
+}
+
+// WithClock set the common.Clock used to set the iat and exp claims, the default is common.RealClock()
+func WithClock(c common.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
+}
+
+// Failure is a scripted response from the token endpoints, see FailNext
+type Failure struct {
+	// StatusCode of the response, if it is zero the request is served normally after Delay
+	StatusCode int
+	// Body of the response
+	Body string
+	// RetryAfter is the value of the Retry-After header, it isn't set if empty
+	RetryAfter string
+	// Delay before the response is sent, or until the request is cancelled
+	Delay time.Duration
+}
+
+// Unauthorized returns a Failure that responds with a 401
+func Unauthorized() Failure {
+	return Failure{StatusCode: http.StatusUnauthorized}
+}
+
+// Forbidden returns a Failure that responds with a 403
+func Forbidden() Failure {
+	return Failure{StatusCode: http.StatusForbidden}
+}
+
+// TooManyRequests returns a Failure that responds with a 429 and the Retry-After header set to retryAfter
+func TooManyRequests(retryAfter string) Failure {
+	return Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+// InternalServerError returns a Failure that responds with a 500
+func InternalServerError() Failure {
+	return Failure{StatusCode: http.StatusInternalServerError}
+}
+
+// InvalidGrant returns a Failure that responds with a 400 and an OAuth2 invalid_grant error
+func InvalidGrant() Failure {
+	return Failure{StatusCode: http.StatusBadRequest, Body: `{"error":"invalid_grant"}`}
+}
+
+// Slow returns a Failure that serves the request normally after d
+func Slow(d time.Duration) Failure {
+	return Failure{Delay: d}
+}
+
+// Request is a request received by the token endpoints
+type Request struct {
+	Method      string
+	Path        string
+	ContentType string
+	Header      http.Header
+	// Params are the request parameters, from either the form or JSON body
+	Params url.Values
+}
+
+// New starts a new Server, it must be closed with Close
+func New(opts ...Option) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("iamfake: error generating key: %v", err))
+	}
+
+	s := &Server{
+		key:           key,
+		clock:         common.RealClock(),
+		tokenLifetime: defaultTokenLifetime,
+		issued:        make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(GLCSTokenPath, s.serveToken)
+	mux.HandleFunc(GLPTokenPath, s.serveToken)
+	mux.HandleFunc(JWKSPath, s.serveJWKS)
+	mux.HandleFunc(DiscoveryPath, s.serveDiscovery)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// GLCSServiceURL returns the iam_service_url for the GLCS IAM version
+func (s *Server) GLCSServiceURL() string {
+	return s.URL
+}
+
+// GLPServiceURL returns the iam_service_url for the GLP IAM version, this is the token URL
+func (s *Server) GLPServiceURL() string {
+	return s.URL + GLPTokenPath
+}
+
+// Issuer returns the iss claim of the tokens minted, it serves the OIDC discovery document
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// JWKS returns the public JWKS that the tokens minted can be verified with
+func (s *Server) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &s.key.PublicKey,
+		KeyID:     KeyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}}
+}
+
+// MintToken returns a signed JWT for clientID with the standard and extra claims, claims overrides these
+func (s *Server) MintToken(clientID string, claims map[string]interface{}) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mintLocked(clientID, "", claims)
+}
+
+// FailNext scripts the responses to the next token requests, one Failure is used for each request
+func (s *Server) FailNext(failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failures...)
+}
+
+// Requests returns the token requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// tokenResponse is the token response body, it is the same for both IAM versions
+type tokenResponse struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// serveToken serves the GLCS and GLP token endpoints
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Header:      r.Header.Clone(),
+		Params:      params,
+	})
+	var failure *Failure
+	if len(s.failures) > 0 {
+		failure = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != nil {
+		if !delay(r, failure.Delay) {
+			return
+		}
+
+		if failure.StatusCode != 0 {
+			if failure.RetryAfter != "" {
+				w.Header().Set("Retry-After", failure.RetryAfter)
+			}
+			w.WriteHeader(failure.StatusCode)
+			_, _ = io.WriteString(w, failure.Body)
+
+			return
+		}
+	}
+
+	s.issueToken(w, params)
+}
+
+// issueToken checks the grant in params and writes a token response
+func (s *Server) issueToken(w http.ResponseWriter, params url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clientID := params.Get("client_id")
+	if s.clientID != "" && clientID != s.clientID {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+
+		return
+	}
+
+	switch params.Get("grant_type") {
+	case "client_credentials":
+		// Client assertions and TLS client certificates aren't checked
+		if s.clientSecret != "" && params.Get("client_assertion") == "" && params.Get("client_secret") != s.clientSecret {
+			writeError(w, http.StatusUnauthorized, "invalid_client")
+
+			return
+		}
+	case "refresh_token":
+		if !s.refreshTokens || s.issued[params.Get("refresh_token")] != clientID {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+
+			return
+		}
+		delete(s.issued, params.Get("refresh_token"))
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+
+		return
+	}
+
+	accessToken, err := s.mintLocked(clientID, params.Get("tenant_id"), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+
+		return
+	}
+
+	resp := tokenResponse{
+		TokenType:   "Bearer",
+		AccessToken: accessToken,
+		ExpiresIn:   int(s.tokenLifetime / time.Second),
+		Scope:       params.Get("scope"),
+	}
+
+	if s.refreshTokens {
+		resp.RefreshToken = randomString()
+		s.issued[resp.RefreshToken] = clientID
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// mintLocked returns a signed JWT, s.mu must be held
+func (s *Server) mintLocked(clientID, tenantID string, claims map[string]interface{}) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: s.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.clock.Now()
+	allClaims := map[string]interface{}{
+		"iss": s.URL,
+		"sub": clientID,
+		"cid": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.tokenLifetime).Unix(),
+		"jti": randomString(),
+	}
+	if tenantID != "" {
+		allClaims["tenantId"] = tenantID
+	}
+
+	for k, v := range s.claims {
+		allClaims[k] = v
+	}
+
+	for k, v := range claims {
+		allClaims[k] = v
+	}
+
+	return jwt.Signed(signer).Claims(allClaims).CompactSerialize()
+}
+
+// serveJWKS serves the public JWKS
+func (s *Server) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.JWKS())
+}
+
+// serveDiscovery serves the OIDC discovery document
+func (s *Server) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":         s.Issuer(),
+		"jwks_uri":       s.URL + JWKSPath,
+		"token_endpoint": s.URL + GLCSTokenPath,
+	})
+}
+
+// requestParams returns the parameters of a form or JSON request body
+func requestParams(r *http.Request) (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeJSON {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+
+		return r.PostForm, nil
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	for k, v := range body {
+		params.Set(k, fmt.Sprint(v))
+	}
+
+	return params, nil
+}
+
+// delay waits for d, it returns false if the request is cancelled first
+func delay(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// writeError writes an OAuth2 error response
+func writeError(w http.ResponseWriter, statusCode int, oauthErr string) {
+	writeJSON(w, statusCode, map[string]string{"error": oauthErr})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// randomString returns a random hex string
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("iamfake: error generating random string: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,170 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package iamfake
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/identitytoken"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/issuertoken"
+	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/verify"
+)
+
+func fastRetries() issuertoken.Option {
+	p := tokenutil.DefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = 10 * time.Millisecond
+	p.AttemptTimeout = 100 * time.Millisecond
+
+	return issuertoken.WithRetryPolicy(p)
+}
+
+func TestTokenEndpoints(t *testing.T) {
+	t.Parallel()
+	s := New(WithCredentials("clientID", "clientSecret"), WithClaims(map[string]interface{}{"isHPE": true}))
+	defer s.Close()
+	ctx := context.Background()
+
+	// GLCS API-vended, form body
+	token, err := issuertoken.GenerateToken(ctx, "clientID", "clientSecret", s.GLCSServiceURL(), s.Client(), iamversion.GLCS)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, time.Minute)
+
+	// GLP API-vended, form body
+	_, err = issuertoken.GenerateToken(ctx, "clientID", "clientSecret", s.GLPServiceURL(), s.Client(), iamversion.GLP)
+	require.NoError(t, err)
+
+	// GLCS non-API-vended, JSON body
+	token, err = identitytoken.GenerateToken(ctx, "tenantID", "clientID", "clientSecret", s.GLCSServiceURL(), s.Client())
+	require.NoError(t, err)
+
+	// The access token is a JWT signed with the key in the JWKS
+	details, err := verify.New(verify.WithHTTPClient(s.Client())).Verify(ctx, token.Value)
+	require.NoError(t, err)
+	assert.Equal(t, s.Issuer(), details.Issuer)
+	assert.Equal(t, "tenantID", details.TenantID)
+	assert.True(t, details.IsHPE)
+	assert.Len(t, s.JWKS().Keys, 1)
+
+	requests := s.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, GLCSTokenPath, requests[0].Path)
+	assert.Equal(t, "hpe-tenant", requests[0].Params.Get("scope"))
+	assert.Equal(t, GLPTokenPath, requests[1].Path)
+	assert.Equal(t, "application/json", requests[2].ContentType)
+	assert.Equal(t, "tenantID", requests[2].Params.Get("tenant_id"))
+	assert.Equal(t, "client_credentials", requests[2].Params.Get("grant_type"))
+
+	// Other credentials are rejected
+	_, err = issuertoken.GenerateToken(ctx, "clientID", "wrongSecret", s.GLCSServiceURL(), s.Client(), iamversion.GLCS)
+	var unauthorized *tokenerrors.ErrUnauthorized
+	assert.ErrorAs(t, err, &unauthorized)
+}
+
+func TestFailNext(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name     string
+		failures []Failure
+		checkErr func(t *testing.T, err error)
+		expReqs  int
+	}{
+		{
+			name:     "retried failures",
+			failures: []Failure{TooManyRequests("0"), InternalServerError(), Slow(time.Second)},
+			checkErr: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+			expReqs: 4,
+		},
+		{
+			name:     "unauthorized",
+			failures: []Failure{Unauthorized()},
+			checkErr: func(t *testing.T, err error) {
+				var unauthorized *tokenerrors.ErrUnauthorized
+				assert.ErrorAs(t, err, &unauthorized)
+			},
+			expReqs: 1,
+		},
+		{
+			name:     "forbidden",
+			failures: []Failure{Forbidden()},
+			checkErr: func(t *testing.T, err error) {
+				var forbidden *tokenerrors.ErrForbidden
+				assert.ErrorAs(t, err, &forbidden)
+			},
+			expReqs: 1,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := New()
+			defer s.Close()
+
+			s.FailNext(tc.failures...)
+			_, err := issuertoken.GenerateToken(
+				context.Background(), "clientID", "clientSecret", s.GLCSServiceURL(), s.Client(), iamversion.GLCS, fastRetries(),
+			)
+			tc.checkErr(t, err)
+			assert.Len(t, s.Requests(), tc.expReqs)
+		})
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	t.Parallel()
+	s := New(WithRefreshTokens(), WithTokenLifetime(10*time.Minute))
+	defer s.Close()
+	ctx := context.Background()
+
+	token, err := identitytoken.GenerateToken(ctx, "tenantID", "clientID", "clientSecret", s.GLCSServiceURL(), s.Client())
+	require.NoError(t, err)
+	require.NotEmpty(t, token.RefreshToken)
+
+	refreshed, err := identitytoken.RefreshToken(
+		ctx, "tenantID", "clientID", "clientSecret", token.RefreshToken, s.GLCSServiceURL(), s.Client(),
+	)
+	require.NoError(t, err)
+	assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+	assert.Equal(t, "refresh_token", s.Requests()[1].Params.Get("grant_type"))
+
+	// Refresh tokens are single use
+	_, err = identitytoken.RefreshToken(
+		ctx, "tenantID", "clientID", "clientSecret", token.RefreshToken, s.GLCSServiceURL(), s.Client(),
+	)
+	var invalidGrant *tokenerrors.ErrInvalidGrant
+	assert.ErrorAs(t, err, &invalidGrant)
+}
+
+func TestMintToken(t *testing.T) {
+	t.Parallel()
+	s := New()
+	defer s.Close()
+
+	// An expired token fails verification
+	token, err := s.MintToken("clientID", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, err)
+
+	_, err = verify.New(verify.WithHTTPClient(s.Client())).Verify(context.Background(), token)
+	var expired *tokenerrors.ErrExpiredToken
+	assert.ErrorAs(t, err, &expired)
+
+	// Discovery and JWKS are served
+	resp, err := s.Client().Get(s.URL + DiscoveryPath) //nolint:noctx
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,197 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package iamversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+const (
+	// GLCS is the name of the IAM version for GLCS
+	GLCS = "glcs"
+	// GLP is the name of the IAM version for GLP
+	GLP = "glp"
+)
+
+const (
+	contentTypeForm = "application/x-www-form-urlencoded"
+	contentTypeJSON = "application/json"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeRefreshToken      = "refresh_token"
+)
+
+// The built-in IAM versions, GLCS is registered first since it is the default
+func init() {
+	Register(glcs{})
+	Register(glp{})
+}
+
+// TokenResponse is the token response body returned by the built-in IAM versions.  API-vended service
+// clients get expires_in, non-API-vended service clients may get an absolute expiry and a refresh token.
+type TokenResponse struct {
+	TokenType       string    `json:"token_type"`
+	AccessToken     string    `json:"access_token"`
+	RefreshToken    string    `json:"refresh_token"`
+	Expiry          time.Time `json:"expiry"`
+	ExpiresIn       int       `json:"expires_in"`
+	Scope           string    `json:"scope"`
+	AccessTokenOnly bool      `json:"accessTokenOnly"`
+}
+
+// IdentityRequest is the request body for non-API-vended service clients
+type IdentityRequest struct {
+	TenantID     string `json:"tenant_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	GrantType    string `json:"grant_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// glcs is the IAMVersion for GLCS
+type glcs struct{}
+
+func (glcs) Name() string {
+	return GLCS
+}
+
+func (glcs) TokenURL(identityServiceURL string) string {
+	return fmt.Sprintf("%s/v1/token", identityServiceURL)
+}
+
+func (g glcs) EncodeRequest(creds Credentials) ([]byte, string, error) {
+	if !creds.VendedServiceClient {
+		if creds.ClientAssertion != "" || creds.TLSClientAuth {
+			return nil, "", fmt.Errorf("IAM version %s only supports client secrets for non-API-vended service clients", GLCS)
+		}
+
+		b, err := json.Marshal(IdentityRequest{
+			TenantID:     creds.TenantID,
+			ClientID:     creds.ClientID,
+			ClientSecret: creds.ClientSecret,
+			GrantType:    grantType(creds),
+			RefreshToken: creds.RefreshToken,
+		})
+
+		return b, contentTypeJSON, err
+	}
+
+	params := clientCredentialsParams(creds)
+	params.Add("scope", strings.Join(scopes(g, creds), " "))
+
+	return []byte(params.Encode()), contentTypeForm, nil
+}
+
+func (glcs) DecodeResponse(body []byte, issuedAt time.Time) (common.AccessToken, error) {
+	return decodeTokenResponse(body, issuedAt)
+}
+
+func (glcs) DefaultScopes() []string {
+	return []string{"hpe-tenant"}
+}
+
+// glp is the IAMVersion for GLP, the iam_service_url is the "Token URL" from the GLP API screen
+type glp struct{}
+
+func (glp) Name() string {
+	return GLP
+}
+
+func (glp) TokenURL(identityServiceURL string) string {
+	return identityServiceURL
+}
+
+func (g glp) EncodeRequest(creds Credentials) ([]byte, string, error) {
+	if !creds.VendedServiceClient {
+		return nil, "", fmt.Errorf("IAM version %s only supports API-vended service clients", GLP)
+	}
+
+	params := clientCredentialsParams(creds)
+	if s := scopes(g, creds); len(s) > 0 {
+		params.Add("scope", strings.Join(s, " "))
+	}
+
+	return []byte(params.Encode()), contentTypeForm, nil
+}
+
+func (glp) DecodeResponse(body []byte, issuedAt time.Time) (common.AccessToken, error) {
+	return decodeTokenResponse(body, issuedAt)
+}
+
+func (glp) DefaultScopes() []string {
+	return nil
+}
+
+// clientCredentialsParams returns the common form parameters for an API client, the client authenticates
+// in the same way for both the client_credentials and refresh_token grants.  The client_secret is only sent
+// if the client isn't authenticated with a client assertion or a TLS client certificate.
+func clientCredentialsParams(creds Credentials) url.Values {
+	params := url.Values{}
+	params.Add("client_id", creds.ClientID)
+	switch {
+	case creds.ClientAssertion != "":
+		params.Add("client_assertion_type", clientauth.ClientAssertionType)
+		params.Add("client_assertion", creds.ClientAssertion)
+	case creds.TLSClientAuth:
+		// the client is authenticated by its certificate during the TLS handshake
+	default:
+		params.Add("client_secret", creds.ClientSecret)
+	}
+	params.Add("grant_type", grantType(creds))
+	if creds.RefreshToken != "" {
+		params.Add("refresh_token", creds.RefreshToken)
+	}
+
+	return params
+}
+
+// grantType returns the OAuth2 grant type to request for creds
+func grantType(creds Credentials) string {
+	if creds.RefreshToken != "" {
+		return grantTypeRefreshToken
+	}
+
+	return grantTypeClientCredentials
+}
+
+// scopes returns the scopes in creds, or the default scopes for v if there are none
+func scopes(v IAMVersion, creds Credentials) []string {
+	if len(creds.Scopes) > 0 {
+		return creds.Scopes
+	}
+
+	return v.DefaultScopes()
+}
+
+// decodeTokenResponse decodes a TokenResponse body into a common.AccessToken.  An absolute expiry is used if
+// present, otherwise expires_in is converted to an absolute expiry time relative to issuedAt.
+func decodeTokenResponse(body []byte, issuedAt time.Time) (common.AccessToken, error) {
+	var t TokenResponse
+	if err := json.Unmarshal(body, &t); err != nil {
+		return common.AccessToken{}, err
+	}
+
+	accessToken := common.AccessToken{
+		Value:        t.AccessToken,
+		TokenType:    t.TokenType,
+		Scope:        t.Scope,
+		RefreshToken: t.RefreshToken,
+	}
+
+	if !t.Expiry.IsZero() {
+		accessToken.Expiry = t.Expiry
+	} else if t.ExpiresIn > 0 {
+		accessToken.Expiry = issuedAt.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+
+	return accessToken, nil
+}
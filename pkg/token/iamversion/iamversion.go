@@ -0,0 +1,99 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package iamversion
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+// IAMVersion is the strategy interface for an IAM flavour.  It covers everything that differs between
+// IAM flavours when generating a token: the token URL, how the request is encoded and how the response
+// is parsed.  Implementations are added to the registry with Register, normally from an init function.
+type IAMVersion interface {
+	// Name is the value of the iam_version provider field that selects this IAM version
+	Name() string
+
+	// TokenURL returns the URL to request tokens from, given the iam_service_url provider field
+	TokenURL(identityServiceURL string) string
+
+	// EncodeRequest returns the body and Content-Type of the token request for creds, a refresh_token
+	// grant is requested if creds.RefreshToken is set
+	EncodeRequest(creds Credentials) ([]byte, string, error)
+
+	// DecodeResponse parses the body of a successful token response.  issuedAt is the time that the
+	// request was made, for converting relative expiry times to absolute ones.
+	DecodeResponse(body []byte, issuedAt time.Time) (common.AccessToken, error)
+
+	// DefaultScopes returns the scopes requested if none are set in Credentials
+	DefaultScopes() []string
+}
+
+// Credentials are the API client credentials used to request a token
+type Credentials struct {
+	// TenantID is only used by non-API-vended service clients
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// VendedServiceClient is true for API-vended service clients
+	VendedServiceClient bool
+	// Scopes to request, if empty the IAMVersion DefaultScopes are requested
+	Scopes []string
+	// RefreshToken is set to renew a token with the refresh_token grant instead of client_credentials
+	RefreshToken string
+	// ClientAssertion is an RFC 7523 JWT that authenticates the client instead of ClientSecret
+	ClientAssertion string
+	// TLSClientAuth is true if the client authenticates with a TLS client certificate (RFC 8705) instead
+	// of ClientSecret
+	TLSClientAuth bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]IAMVersion)
+	// names is the list of registered names in registration order
+	names []string
+)
+
+// Register adds an IAMVersion to the registry.  Downstream repos can call this from an init function
+// to add their own IAM flavours.  We panic if the name is empty or has already been registered.
+func Register(v IAMVersion) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := v.Name()
+	if name == "" {
+		panic("IAM version name must not be empty")
+	}
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("IAM version %s is already registered", name))
+	}
+
+	registry[name] = v
+	names = append(names, name)
+}
+
+// Lookup returns the registered IAMVersion with name
+func Lookup(name string) (IAMVersion, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	v, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid IAM version %s, must be one of %v", name, names)
+	}
+
+	return v, nil
+}
+
+// Names returns the names of the registered IAM versions in registration order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return append([]string(nil), names...)
+}
@@ -0,0 +1,294 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package iamversion
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+type testIAMVersion struct {
+	glp
+	name string
+}
+
+func (v testIAMVersion) Name() string {
+	return v.name
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{GLCS, GLP}, Names()[:2])
+
+	v, err := Lookup(GLCS)
+	require.NoError(t, err)
+	assert.Equal(t, GLCS, v.Name())
+
+	_, err = Lookup("invalid")
+	assert.ErrorContains(t, err, "invalid IAM version invalid")
+
+	Register(testIAMVersion{name: "test-registry"})
+	v, err = Lookup("test-registry")
+	require.NoError(t, err)
+	assert.Equal(t, "test-registry", v.Name())
+	assert.Contains(t, Names(), "test-registry")
+
+	assert.Panics(t, func() { Register(testIAMVersion{name: GLCS}) })
+	assert.Panics(t, func() { Register(testIAMVersion{}) })
+}
+
+func TestEncodeRequest(t *testing.T) {
+	t.Parallel()
+
+	formParams := func(scope, refreshToken string) []byte {
+		params := url.Values{}
+		params.Add("client_id", "clientID")
+		params.Add("client_secret", "clientSecret")
+		if refreshToken != "" {
+			params.Add("grant_type", "refresh_token")
+			params.Add("refresh_token", refreshToken)
+		} else {
+			params.Add("grant_type", "client_credentials")
+		}
+		if scope != "" {
+			params.Add("scope", scope)
+		}
+
+		return []byte(params.Encode())
+	}
+
+	identityBody, err := json.Marshal(IdentityRequest{
+		TenantID:     "tenantID",
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		GrantType:    "client_credentials",
+	})
+	require.NoError(t, err)
+
+	identityRefreshBody, err := json.Marshal(IdentityRequest{
+		TenantID:     "tenantID",
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		GrantType:    "refresh_token",
+		RefreshToken: "refreshToken",
+	})
+	require.NoError(t, err)
+
+	assertionParams := []byte(url.Values{
+		"client_id":             {"clientID"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {"assertion"},
+		"grant_type":            {"client_credentials"},
+	}.Encode())
+
+	tlsParams := []byte(url.Values{
+		"client_id":  {"clientID"},
+		"grant_type": {"client_credentials"},
+	}.Encode())
+
+	testcases := []struct {
+		name           string
+		iamVersion     string
+		vended         bool
+		scopes         []string
+		refreshToken   string
+		assertion      string
+		tlsClientAuth  bool
+		expURL         string
+		expBody        []byte
+		expContentType string
+		hasError       bool
+	}{
+		{
+			name:           "GLCS API-vended",
+			iamVersion:     GLCS,
+			vended:         true,
+			expURL:         "identityServiceURL/v1/token",
+			expBody:        formParams("hpe-tenant", ""),
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLCS API-vended with scopes",
+			iamVersion:     GLCS,
+			vended:         true,
+			scopes:         []string{"scope1", "scope2"},
+			expURL:         "identityServiceURL/v1/token",
+			expBody:        formParams("scope1 scope2", ""),
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLCS non-API-vended",
+			iamVersion:     GLCS,
+			expURL:         "identityServiceURL/v1/token",
+			expBody:        identityBody,
+			expContentType: contentTypeJSON,
+		},
+		{
+			name:           "GLP API-vended",
+			iamVersion:     GLP,
+			vended:         true,
+			expURL:         "identityServiceURL",
+			expBody:        formParams("", ""),
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLCS API-vended refresh",
+			iamVersion:     GLCS,
+			vended:         true,
+			refreshToken:   "refreshToken",
+			expURL:         "identityServiceURL/v1/token",
+			expBody:        formParams("hpe-tenant", "refreshToken"),
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLCS non-API-vended refresh",
+			iamVersion:     GLCS,
+			refreshToken:   "refreshToken",
+			expURL:         "identityServiceURL/v1/token",
+			expBody:        identityRefreshBody,
+			expContentType: contentTypeJSON,
+		},
+		{
+			name:           "GLP API-vended refresh",
+			iamVersion:     GLP,
+			vended:         true,
+			refreshToken:   "refreshToken",
+			expURL:         "identityServiceURL",
+			expBody:        formParams("", "refreshToken"),
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLP private_key_jwt",
+			iamVersion:     GLP,
+			vended:         true,
+			assertion:      "assertion",
+			expURL:         "identityServiceURL",
+			expBody:        assertionParams,
+			expContentType: contentTypeForm,
+		},
+		{
+			name:           "GLP tls_client_auth",
+			iamVersion:     GLP,
+			vended:         true,
+			tlsClientAuth:  true,
+			expURL:         "identityServiceURL",
+			expBody:        tlsParams,
+			expContentType: contentTypeForm,
+		},
+		{
+			name:       "GLCS non-API-vended private_key_jwt",
+			iamVersion: GLCS,
+			assertion:  "assertion",
+			expURL:     "identityServiceURL/v1/token",
+			hasError:   true,
+		},
+		{
+			name:       "GLP non-API-vended",
+			iamVersion: GLP,
+			expURL:     "identityServiceURL",
+			hasError:   true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			v, err := Lookup(tc.iamVersion)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expURL, v.TokenURL("identityServiceURL"))
+
+			body, contentType, err := v.EncodeRequest(Credentials{
+				TenantID:            "tenantID",
+				ClientID:            "clientID",
+				ClientSecret:        "clientSecret",
+				VendedServiceClient: tc.vended,
+				Scopes:              tc.scopes,
+				RefreshToken:        tc.refreshToken,
+				ClientAssertion:     tc.assertion,
+				TLSClientAuth:       tc.tlsClientAuth,
+			})
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, string(tc.expBody), string(body))
+			assert.Equal(t, tc.expContentType, contentType)
+		})
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	t.Parallel()
+	issuedAt := time.Now()
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name     string
+		body     string
+		expToken common.AccessToken
+		hasError bool
+	}{
+		{
+			name: "expires_in",
+			body: `{"token_type":"Bearer","expires_in":900,"access_token":"opaque-token","scope":"hpe-tenant"}`,
+			expToken: common.AccessToken{
+				Value:     "opaque-token",
+				TokenType: "Bearer",
+				Scope:     "hpe-tenant",
+				Expiry:    issuedAt.Add(900 * time.Second),
+			},
+		},
+		{
+			name: "absolute expiry",
+			body: `{"access_token":"opaque-token","refresh_token":"refresh-token","expiry":"2030-01-01T00:00:00Z","expires_in":900}`,
+			expToken: common.AccessToken{
+				Value:        "opaque-token",
+				RefreshToken: "refresh-token",
+				Expiry:       expiry,
+			},
+		},
+		{
+			name:     "no expiry",
+			body:     `{"access_token":"opaque-token"}`,
+			expToken: common.AccessToken{Value: "opaque-token"},
+		},
+		{
+			name:     "invalid body",
+			body:     `not-json`,
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			for _, name := range []string{GLCS, GLP} {
+				v, err := Lookup(name)
+				require.NoError(t, err)
+
+				token, err := v.DecodeResponse([]byte(tc.body), issuedAt)
+				if tc.hasError {
+					assert.Error(t, err)
+
+					continue
+				}
+				require.NoError(t, err)
+				assert.True(t, tc.expToken.Expiry.Equal(token.Expiry))
+				token.Expiry = tc.expToken.Expiry
+				assert.Equal(t, tc.expToken, token)
+			}
+		})
+	}
+}
@@ -4,37 +4,21 @@ package identitytoken
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"strings"
-	"time"
 
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/issuertoken"
 	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
 )
 
-const (
-	retryLimit = 3
-)
-
-type GenerateTokenInput struct {
-	TenantID     string `json:"tenant_id"`
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	GrantType    string `json:"grant_type"`
-}
+// GenerateTokenInput is the token request body for non-API-vended service clients
+type GenerateTokenInput = iamversion.IdentityRequest
 
-type TokenResponse struct {
-	TokenType       string    `json:"token_type"`
-	AccessToken     string    `json:"access_token"`
-	RefreshToken    string    `json:"refresh_token"`
-	Expiry          time.Time `json:"expiry"`
-	ExpiresIn       int       `json:"expires_in"`
-	Scope           string    `json:"scope"`
-	AccessTokenOnly bool      `json:"accessTokenOnly"`
-}
+// TokenResponse is the token response body for non-API-vended service clients
+type TokenResponse = iamversion.TokenResponse
 
+// GenerateToken generates a token for a non-API-vended service client, the returned common.AccessToken has
+// its Expiry set from the expiry or expires_in reported by IAM
 func GenerateToken(
 	ctx context.Context,
 	tenantID,
@@ -42,70 +26,36 @@ func GenerateToken(
 	clientSecret string,
 	identityServiceURL string,
 	httpClient tokenutil.HttpClient,
-) (string, error) {
-	params := GenerateTokenInput{
+	opts ...issuertoken.Option,
+) (common.AccessToken, error) {
+	creds := iamversion.Credentials{
 		TenantID:     tenantID,
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		GrantType:    "client_credentials",
-	}
-
-	url := fmt.Sprintf("%s/v1/token", identityServiceURL)
-
-	b, err := json.Marshal(params)
-	if err != nil {
-		return "", err
-	}
-
-	// Create a slice of cancel functions to be returned by the retries
-	cancelFuncs := make([]context.CancelFunc, 0)
-
-	resp, err := tokenutil.DoRetries(
-		ctx,
-		&cancelFuncs,
-		func(reqCtx context.Context) (*http.Request, *http.Response, error) {
-			req, errReq := http.NewRequestWithContext(reqCtx, http.MethodPost, url, strings.NewReader(string(b)))
-			if errReq != nil {
-				return nil, nil, errReq
-			}
-			req.Header.Set("Content-Type", "application/json")
-			respFromDo, errResp := httpClient.Do(req)
-
-			return req, respFromDo, errResp
-		},
-		retryLimit,
-	)
-	// Defer execution of cancelFuncs
-	defer executeCancelFuncs(&cancelFuncs)
-
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	err = tokenutil.ManageHTTPErrorCodes(resp, clientID)
-	if err != nil {
-		return "", err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var token TokenResponse
-
-	err = json.Unmarshal(body, &token)
-	if err != nil {
-		return "", err
-	}
-
-	return token.AccessToken, nil
+	return issuertoken.GenerateTokenWithCredentials(ctx, creds, identityServiceURL, httpClient, iamversion.GLCS, opts...)
 }
 
-// executeCancelFuncs executes all cancel functions in the slice
-func executeCancelFuncs(cancelFuncs *[]context.CancelFunc) {
-	for _, cancel := range *cancelFuncs {
-		cancel()
+// RefreshToken renews a token for a non-API-vended service client with the refresh_token grant.  An
+// *errors.ErrInvalidGrant is returned if IAM rejects refreshToken, in which case the caller should fall
+// back to GenerateToken.
+func RefreshToken(
+	ctx context.Context,
+	tenantID,
+	clientID,
+	clientSecret,
+	refreshToken string,
+	identityServiceURL string,
+	httpClient tokenutil.HttpClient,
+	opts ...issuertoken.Option,
+) (common.AccessToken, error) {
+	creds := iamversion.Credentials{
+		TenantID:     tenantID,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RefreshToken: refreshToken,
 	}
+
+	return issuertoken.GenerateTokenWithCredentials(ctx, creds, identityServiceURL, httpClient, iamversion.GLCS, opts...)
 }
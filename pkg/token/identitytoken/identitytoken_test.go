@@ -0,0 +1,93 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package identitytoken
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamfake"
+)
+
+func TestGenerateToken(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name         string
+		clientSecret string
+		checkErr     func(t *testing.T, err error)
+	}{
+		{
+			name:         "success",
+			clientSecret: "clientSecret",
+		},
+		{
+			name:         "wrong secret",
+			clientSecret: "wrongSecret",
+			checkErr: func(t *testing.T, err error) {
+				var unauthorized *tokenerrors.ErrUnauthorized
+				assert.ErrorAs(t, err, &unauthorized)
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := iamfake.New(iamfake.WithCredentials("clientID", "clientSecret"))
+			defer s.Close()
+
+			token, err := GenerateToken(context.Background(), "tenantID", "clientID", tc.clientSecret,
+				s.GLCSServiceURL(), s.Client())
+			if tc.checkErr != nil {
+				require.Error(t, err)
+				tc.checkErr(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotEmpty(t, token.Value)
+
+			// The request is a JSON body sent to the identity service's /v1/token endpoint
+			requests := s.Requests()
+			require.Len(t, requests, 1)
+			assert.Equal(t, iamfake.GLCSTokenPath, requests[0].Path)
+			assert.Equal(t, "application/json", requests[0].ContentType)
+			assert.Equal(t, "tenantID", requests[0].Params.Get("tenant_id"))
+			assert.Equal(t, "client_credentials", requests[0].Params.Get("grant_type"))
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+	s := iamfake.New(iamfake.WithCredentials("clientID", "clientSecret"), iamfake.WithRefreshTokens())
+	defer s.Close()
+	ctx := context.Background()
+
+	token, err := GenerateToken(ctx, "tenantID", "clientID", "clientSecret", s.GLCSServiceURL(), s.Client())
+	require.NoError(t, err)
+	require.NotEmpty(t, token.RefreshToken)
+
+	refreshed, err := RefreshToken(ctx, "tenantID", "clientID", "clientSecret", token.RefreshToken,
+		s.GLCSServiceURL(), s.Client())
+	require.NoError(t, err)
+	assert.NotEmpty(t, refreshed.Value)
+	assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+
+	requests := s.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "refresh_token", requests[1].Params.Get("grant_type"))
+	assert.Equal(t, token.RefreshToken, requests[1].Params.Get("refresh_token"))
+
+	// A refresh token can only be used once
+	_, err = RefreshToken(ctx, "tenantID", "clientID", "clientSecret", token.RefreshToken,
+		s.GLCSServiceURL(), s.Client())
+	var invalidGrant *tokenerrors.ErrInvalidGrant
+	assert.ErrorAs(t, err, &invalidGrant)
+}
@@ -3,22 +3,74 @@
 package issuertoken
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
 
-	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
 	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
 )
 
-const (
-	retryLimit = 3
-)
+// Option is an optional setting for token generation
+type Option func(o *options)
+
+// options are the optional settings for token generation
+type options struct {
+	retryPolicy tokenutil.RetryPolicy
+	clock       common.Clock
+	logger      common.Logger
+}
+
+// WithRetryPolicy set the tokenutil.RetryPolicy used for the token request, the default is
+// tokenutil.DefaultRetryPolicy()
+func WithRetryPolicy(p tokenutil.RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = p
+	}
+}
+
+// WithClock set the common.Clock used to time the token request and wait between retries, the default is
+// common.RealClock()
+func WithClock(c common.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithLogger set the common.Logger used to log the token request and its retries, the default is
+// common.TFLogger().  Secrets are masked whatever the Logger.
+func WithLogger(l common.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// newOptions returns the default options with opts applied
+func newOptions(opts []Option) options {
+	o := options{retryPolicy: tokenutil.DefaultRetryPolicy(), clock: common.RealClock()}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
 
+	o.logger = common.LoggerOrDefault(o.logger)
+
+	// The retry policy uses our clock and logger unless it has its own
+	if o.retryPolicy.Clock == nil {
+		o.retryPolicy.Clock = o.clock
+	}
+	if o.retryPolicy.Logger == nil {
+		o.retryPolicy.Logger = o.logger
+	}
+
+	return o
+}
+
+// TokenResponse is the token response body for API-vended service clients
 type TokenResponse struct {
 	TokenType   string `json:"token_type"`
 	ExpiresIn   int    `json:"expires_in"`
@@ -26,6 +78,8 @@ type TokenResponse struct {
 	Scope       string `json:"scope"`
 }
 
+// GenerateToken generates a token for an API client, the returned common.AccessToken has its
+// Expiry set from the expires_in reported by IAM
 func GenerateToken(
 	ctx context.Context,
 	clientID,
@@ -33,23 +87,57 @@ func GenerateToken(
 	identityServiceURL string,
 	httpClient tokenutil.HttpClient,
 	iamVersion string,
-) (string, error) {
-	// Generate the parameters and URL for the request
-	params, clientURL, err := generateParamsAndURL(clientID, clientSecret, identityServiceURL, iamVersion)
+	opts ...Option,
+) (common.AccessToken, error) {
+	creds := iamversion.Credentials{
+		ClientID:            clientID,
+		ClientSecret:        clientSecret,
+		VendedServiceClient: true,
+	}
+
+	return GenerateTokenWithCredentials(ctx, creds, identityServiceURL, httpClient, iamVersion, opts...)
+}
+
+// GenerateTokenWithCredentials generates a token for creds using the registered iamversion.IAMVersion
+// named iamVersion to build the request and parse the response
+func GenerateTokenWithCredentials(
+	ctx context.Context,
+	creds iamversion.Credentials,
+	identityServiceURL string,
+	httpClient tokenutil.HttpClient,
+	iamVersion string,
+	opts ...Option,
+) (common.AccessToken, error) {
+	o := newOptions(opts)
+
+	version, err := iamversion.Lookup(iamVersion)
 	if err != nil {
-		return "", err
+		return common.AccessToken{}, err
 	}
 
+	// Generate the body and URL for the request
+	body, contentType, err := version.EncodeRequest(creds)
+	if err != nil {
+		return common.AccessToken{}, err
+	}
+	clientURL := version.TokenURL(identityServiceURL)
+
+	fields := requestFields(creds, iamVersion, clientURL)
+	o.logger.Debug(ctx, "Requesting token", fields)
+
+	// Note the time before the request is made, expires_in is relative to when the token was issued
+	issuedAt := o.clock.Now()
+
 	// Create a slice of cancel functions to be returned by the retries
 	cancelFuncs := make([]context.CancelFunc, 0)
 
 	// Execute the request, with retries
-	resp, err := tokenutil.DoRetries(
+	resp, err := tokenutil.DoRetriesWithPolicy(
 		ctx,
 		&cancelFuncs,
 		func(reqCtx context.Context) (*http.Request, *http.Response, error) {
 			// Create the request
-			req, errReq := createRequest(reqCtx, params, clientURL)
+			req, errReq := createRequest(reqCtx, body, contentType, clientURL)
 			if errReq != nil {
 				return nil, nil, errReq
 			}
@@ -61,34 +149,70 @@ func GenerateToken(
 
 			return req, respFromDo, errResp
 		},
-		retryLimit,
+		o.retryPolicy,
 	)
 	// Defer execution of cancel functions
 	defer executeCancelFuncs(&cancelFuncs)
 
 	if err != nil {
-		return "", err
+		// The last response is returned if the retries are exhausted
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		fields[common.LogFieldError] = err
+		o.logger.Warn(ctx, "Token request failed", fields)
+
+		return common.AccessToken{}, err
 	}
 	defer resp.Body.Close()
 
-	err = tokenutil.ManageHTTPErrorCodes(resp, clientID)
+	fields[common.LogFieldStatus] = resp.StatusCode
+	err = tokenutil.ManageHTTPErrorCodes(resp, creds.ClientID)
 	if err != nil {
-		return "", err
+		fields[common.LogFieldError] = err
+		o.logger.Warn(ctx, "Token request failed", fields)
+
+		return common.AccessToken{}, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return common.AccessToken{}, err
 	}
 
-	var token TokenResponse
-
-	err = json.Unmarshal(body, &token)
+	token, err := version.DecodeResponse(respBody, issuedAt)
 	if err != nil {
-		return "", err
+		fields[common.LogFieldError] = err
+		o.logger.Warn(ctx, "Token response could not be decoded", fields)
+
+		return common.AccessToken{}, err
 	}
 
-	return token.AccessToken, nil
+	if !token.Expiry.IsZero() {
+		fields[common.LogFieldTokenExpiry] = token.Expiry
+	}
+	o.logger.Debug(ctx, "Token generated", fields)
+
+	return token, nil
+}
+
+// requestFields returns the log fields for a token request, no secrets are included
+func requestFields(creds iamversion.Credentials, iamVersion, clientURL string) map[string]interface{} {
+	grantType := "client_credentials"
+	if creds.RefreshToken != "" {
+		grantType = "refresh_token"
+	}
+
+	fields := map[string]interface{}{
+		common.LogFieldIAMVersion: iamVersion,
+		common.LogFieldClientID:   creds.ClientID,
+		common.LogFieldGrantType:  grantType,
+	}
+	if u, err := url.Parse(clientURL); err == nil {
+		fields[common.LogFieldURLHost] = u.Host
+	}
+
+	return fields
 }
 
 // executeCancelFuncs executes all cancel functions in the slice
@@ -99,38 +223,12 @@ func executeCancelFuncs(cancelFuncs *[]context.CancelFunc) {
 }
 
 // createRequest creates a new http request
-func createRequest(ctx context.Context, params url.Values, clientURL string) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, clientURL, strings.NewReader(params.Encode()))
+func createRequest(ctx context.Context, body []byte, contentType, clientURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, clientURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
 
 	return req, nil
 }
-
-// generateParamsAndURL generates the parameters and URL for the request
-func generateParamsAndURL(clientID, clientSecret, identityServiceURL, iamVersion string) (url.Values, string, error) {
-	params := url.Values{}
-
-	// Add common parameters for an API Client
-	params.Add("client_id", clientID)
-	params.Add("client_secret", clientSecret)
-	params.Add("grant_type", "client_credentials")
-
-	// Add specific parameters and generate URL for the IAM version
-	var clientURL string
-	switch provider.IAMVersion(iamVersion) {
-	case provider.IAMVersionGLCS:
-		params.Add("scope", "hpe-tenant")
-		clientURL = fmt.Sprintf("%s/v1/token", identityServiceURL)
-
-	case provider.IAMVersionGLP:
-		clientURL = identityServiceURL
-
-	default:
-		return nil, "", fmt.Errorf("invalid IAM version")
-	}
-
-	return params, clientURL, nil
-}
@@ -3,52 +3,101 @@
 package issuertoken
 
 import (
-	"net/url"
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
-	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamfake"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamversion"
+	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
 )
 
-func generateExpParams(iamVersion provider.IAMVersion) url.Values {
-	expParams := url.Values{}
-	expParams.Add("client_id", "clientID")
-	expParams.Add("client_secret", "clientSecret")
-	expParams.Add("grant_type", "client_credentials")
-	if iamVersion == provider.IAMVersionGLCS {
-		expParams.Add("scope", "hpe-tenant")
-	}
+func fastRetries() Option {
+	p := tokenutil.DefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = 10 * time.Millisecond
+	p.AttemptTimeout = time.Second
 
-	return expParams
+	return WithRetryPolicy(p)
 }
 
-func TestGenerateParamsAndURL(t *testing.T) {
+func TestGenerateTokenWithCredentials(t *testing.T) {
 	t.Parallel()
-
 	testcases := []struct {
-		name       string
-		iamVersion provider.IAMVersion
-		expParams  url.Values
-		hasError   bool
+		name           string
+		iamVersion     string
+		creds          iamversion.Credentials
+		glpURL         bool
+		failures       []iamfake.Failure
+		expPath        string
+		expContentType string
+		expReqs        int
+		checkErr       func(t *testing.T, err error)
 	}{
 		{
-			name:       "valid IAM version GLCS",
-			iamVersion: provider.IAMVersionGLCS,
-			expParams:  generateExpParams(provider.IAMVersionGLCS),
-			hasError:   false,
+			name:           "GLCS API-vended",
+			iamVersion:     iamversion.GLCS,
+			creds:          iamversion.Credentials{ClientID: "clientID", ClientSecret: "clientSecret", VendedServiceClient: true},
+			expPath:        iamfake.GLCSTokenPath,
+			expContentType: "application/x-www-form-urlencoded",
+			expReqs:        1,
+		},
+		{
+			name:           "GLCS non-API-vended",
+			iamVersion:     iamversion.GLCS,
+			creds:          iamversion.Credentials{TenantID: "tenantID", ClientID: "clientID", ClientSecret: "clientSecret"},
+			expPath:        iamfake.GLCSTokenPath,
+			expContentType: "application/json",
+			expReqs:        1,
+		},
+		{
+			name:           "GLP API-vended",
+			iamVersion:     iamversion.GLP,
+			creds:          iamversion.Credentials{ClientID: "clientID", ClientSecret: "clientSecret", VendedServiceClient: true},
+			glpURL:         true,
+			expPath:        iamfake.GLPTokenPath,
+			expContentType: "application/x-www-form-urlencoded",
+			expReqs:        1,
 		},
 		{
-			name:       "valid IAM version GLP",
-			iamVersion: provider.IAMVersionGLP,
-			expParams:  generateExpParams(provider.IAMVersionGLP),
-			hasError:   false,
+			name:       "GLP non-API-vended is rejected before any request",
+			iamVersion: iamversion.GLP,
+			creds:      iamversion.Credentials{TenantID: "tenantID", ClientID: "clientID", ClientSecret: "clientSecret"},
+			glpURL:     true,
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "IAM version glp only supports API-vended service clients")
+			},
 		},
 		{
-			name:       "invalid IAM version",
-			iamVersion: "invalid",
-			expParams:  nil,
-			hasError:   true,
+			name:       "unknown IAM version",
+			iamVersion: "unknown",
+			creds:      iamversion.Credentials{ClientID: "clientID", ClientSecret: "clientSecret", VendedServiceClient: true},
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "invalid IAM version unknown")
+			},
+		},
+		{
+			name:       "wrong secret",
+			iamVersion: iamversion.GLCS,
+			creds:      iamversion.Credentials{ClientID: "clientID", ClientSecret: "wrongSecret", VendedServiceClient: true},
+			expReqs:    1,
+			checkErr: func(t *testing.T, err error) {
+				var unauthorized *tokenerrors.ErrUnauthorized
+				assert.ErrorAs(t, err, &unauthorized)
+			},
+		},
+		{
+			name:           "server error is retried",
+			iamVersion:     iamversion.GLCS,
+			creds:          iamversion.Credentials{ClientID: "clientID", ClientSecret: "clientSecret", VendedServiceClient: true},
+			failures:       []iamfake.Failure{iamfake.InternalServerError()},
+			expPath:        iamfake.GLCSTokenPath,
+			expContentType: "application/x-www-form-urlencoded",
+			expReqs:        2,
 		},
 	}
 
@@ -56,13 +105,54 @@ func TestGenerateParamsAndURL(t *testing.T) {
 		tc := testcase
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			params, _, err := generateParamsAndURL("clientID", "clientSecret", "identityServiceURL", string(tc.iamVersion))
-			assert.Equal(t, tc.expParams, params)
-			if tc.hasError {
-				assert.NotNil(t, err)
-			} else {
-				assert.Nil(t, err)
+			s := iamfake.New(iamfake.WithCredentials("clientID", "clientSecret"))
+			defer s.Close()
+			s.FailNext(tc.failures...)
+
+			serviceURL := s.GLCSServiceURL()
+			if tc.glpURL {
+				serviceURL = s.GLPServiceURL()
 			}
+
+			token, err := GenerateTokenWithCredentials(context.Background(), tc.creds, serviceURL, s.Client(),
+				tc.iamVersion, fastRetries())
+			requests := s.Requests()
+			assert.Len(t, requests, tc.expReqs)
+			if tc.checkErr != nil {
+				require.Error(t, err)
+				tc.checkErr(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "Bearer", token.TokenType)
+			assert.NotEmpty(t, token.Value)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, time.Minute)
+
+			last := requests[len(requests)-1]
+			assert.Equal(t, tc.expPath, last.Path)
+			assert.Equal(t, tc.expContentType, last.ContentType)
+			assert.Equal(t, "client_credentials", last.Params.Get("grant_type"))
+			assert.Equal(t, tc.creds.ClientID, last.Params.Get("client_id"))
+			assert.Equal(t, tc.creds.TenantID, last.Params.Get("tenant_id"))
 		})
 	}
 }
+
+func TestGenerateToken(t *testing.T) {
+	t.Parallel()
+	s := iamfake.New(iamfake.WithCredentials("clientID", "clientSecret"))
+	defer s.Close()
+
+	// GenerateToken is for API-vended service clients
+	token, err := GenerateToken(context.Background(), "clientID", "clientSecret", s.GLPServiceURL(), s.Client(),
+		iamversion.GLP)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.Value)
+
+	requests := s.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, iamfake.GLPTokenPath, requests[0].Path)
+	assert.Equal(t, "clientID", requests[0].Params.Get("client_id"))
+}
@@ -1,4 +1,4 @@
-// (C) Copyright 2021 Hewlett Packard Enterprise Development LP
+// (C) Copyright 2021-2024 Hewlett Packard Enterprise Development LP
 
 package retrieve
 
@@ -14,20 +14,53 @@ type TokenRetrieveFuncCtx func(ctx context.Context) (string, error)
 // NewTokenRetrieveFunc takes a common.TokenChannelInterface as an input and returns a
 // TokenRetrieveFuncCtx.  Exit from loop if a token is received on resCh, or if the
 // context passed-in is cancelled.  On cancellation of context a signal is sent
-// on exitCh to tell the token Handler retrieve thread to exit
+// on exitCh to tell the token Handler retrieve thread to exit.  If the retrieve thread
+// has already exited common.ErrHandlerClosed is returned.
+// If the Handler implements common.TokenReader the token is read directly from its cache rather than
+// being received on resCh.
 func NewTokenRetrieveFunc(channelInterface common.TokenChannelInterface) TokenRetrieveFuncCtx {
 	resCh, exitCh := channelInterface.TokenChannels()
+	reader, isReader := channelInterface.(common.TokenReader)
 
 	return func(ctx context.Context) (string, error) {
-		for {
-			select {
-			case tok := <-resCh:
-				return tok.Token, tok.Err
-			case <-ctx.Done():
-				exitCh <- 1
+		// Check for a cancelled context first, so that we don't return a token after cancellation
+		if ctx.Err() != nil {
+			sendExit(exitCh)
+
+			return "", nil
+		}
+
+		if isReader {
+			token, err := reader.Token(ctx)
+			if ctx.Err() != nil {
+				sendExit(exitCh)
 
 				return "", nil
 			}
+
+			return token, err
+		}
+
+		select {
+		case tok, ok := <-resCh:
+			if !ok {
+				return "", common.ErrHandlerClosed
+			}
+
+			return tok.Token, tok.Err
+		case <-ctx.Done():
+			sendExit(exitCh)
+
+			return "", nil
 		}
 	}
 }
+
+// sendExit sends an exit signal on exitCh without blocking, if a signal is already pending
+// there is no need to send another one
+func sendExit(exitCh chan int) {
+	select {
+	case exitCh <- 1:
+	default:
+	}
+}
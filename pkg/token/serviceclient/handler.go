@@ -5,37 +5,81 @@ package serviceclient
 import (
 	"context"
 	"errors"
-	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/credentials"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/clientauth"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
 	httpc "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/httpclient"
 	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokencache"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/verify"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/transport"
 )
 
-const retryLimit = 3
-
 // Assert that Handler implements common.TokenChannelInterface
 var _ common.TokenChannelInterface = (*Handler)(nil)
 
+// Assert that Handler implements common.TokenHandlerCloser
+var _ common.TokenHandlerCloser = (*Handler)(nil)
+
+// Assert that Handler implements common.TokenReader
+var _ common.TokenReader = (*Handler)(nil)
+
 //go:generate mockgen -build_flags=-mod=mod -destination=../../mocks/IdentityAPI_mocks.go -package=mocks github.com/hewlettpackard/hpegl-provider-lib/pkg/token/serviceclient IdentityAPI
 type IdentityAPI interface {
-	GenerateToken(context.Context, string, string, string, string) (string, error)
+	GenerateToken(context.Context, string, string, string, string) (common.AccessToken, error)
+}
+
+// TokenRefresher is implemented by IdentityAPI clients that can renew a token with the OAuth2
+// refresh_token grant, the extra string parameter is the refresh token
+type TokenRefresher interface {
+	RefreshToken(context.Context, string, string, string, string, string) (common.AccessToken, error)
 }
 
+// Assert that the default IdentityAPI supports the refresh_token grant
+var _ TokenRefresher = (*httpc.Client)(nil)
+
 // Handler the handler for service-client creds
 type Handler struct {
-	iamServiceURL       string
-	token               string
-	tenantID            string
-	clientID            string
-	clientSecret        string
+	iamServiceURL string
+	tenantID      string
+	clientID      string
+	clientSecret  string
+	// providerTenantID is the tenant_id provider field, it is used if the credentials don't have a tenant
+	providerTenantID string
+	// credentials is the source of clientID, clientSecret and tenantID, they are resolved before each IAM call
+	credentials         credentials.Provider
 	iamVersion          string
 	vendedServiceClient bool
-	numRetries          int
 	client              IdentityAPI
 	resultCh            chan common.Result
 	exitCh              chan int
+	ctx                 context.Context
+	cancel              context.CancelFunc
+	doneCh              chan struct{}
+	// cache holds the current token, it is written by the refresh thread and read lock-free
+	cache           atomic.Pointer[cachedToken]
+	readyCh         chan struct{}
+	readyOnce       sync.Once
+	updateCh        chan struct{}
+	refreshFraction float64
+	refreshJitter   float64
+	// verifier is used to verify passed-in tokens, it is nil if tokens aren't verified
+	verifier *verify.Verifier
+	// retryPolicy is the retry policy for IAM calls made by the default IdentityAPI
+	retryPolicy tokenutil.RetryPolicy
+	// clock is used to time tokens and schedule refreshes
+	clock common.Clock
+	// logger logs token refreshes and IAM calls
+	logger common.Logger
+	// tokenCache is the on-disk token cache shared with other provider processes, it is nil if tokens
+	// aren't cached
+	tokenCache *tokencache.Cache
 }
 
 // CreateOpt - function option definition
@@ -48,6 +92,78 @@ func WithIdentityAPI(i IdentityAPI) CreateOpt {
 	}
 }
 
+// WithContext set the parent context for the Handler, the retrieve thread exits and any in-flight
+// IAM call is aborted when this context is cancelled
+func WithContext(ctx context.Context) CreateOpt {
+	return func(h *Handler) {
+		h.ctx = ctx
+	}
+}
+
+// WithRefreshFraction set the fraction of a token's lifetime after which it is refreshed in the
+// background, this must be greater than 0 and less than 1
+func WithRefreshFraction(f float64) CreateOpt {
+	return func(h *Handler) {
+		h.refreshFraction = f
+	}
+}
+
+// WithRefreshJitter set the maximum jitter subtracted from the refresh time, as a fraction of the
+// token's lifetime.  Jitter spreads the refreshes of several providers started at the same time.
+func WithRefreshJitter(j float64) CreateOpt {
+	return func(h *Handler) {
+		h.refreshJitter = j
+	}
+}
+
+// WithVerifier override the verify.Verifier used to verify tokens, tokens are verified even if
+// they aren't passed-in
+func WithVerifier(v *verify.Verifier) CreateOpt {
+	return func(h *Handler) {
+		h.verifier = v
+	}
+}
+
+// WithRetryPolicy set the tokenutil.RetryPolicy used for IAM calls, it has no effect if the IdentityAPI
+// is overridden with WithIdentityAPI
+func WithRetryPolicy(p tokenutil.RetryPolicy) CreateOpt {
+	return func(h *Handler) {
+		h.retryPolicy = p
+	}
+}
+
+// WithClock override the common.Clock used to time tokens and schedule refreshes, it is also used by the
+// default IdentityAPI and verify.Verifier.  This is intended for tests, see pkg/token/tokentest.
+func WithClock(c common.Clock) CreateOpt {
+	return func(h *Handler) {
+		h.clock = c
+	}
+}
+
+// WithLogger set the common.Logger used to log token refreshes, it is also used by the default IdentityAPI.
+// The default is common.TFLogger(), pass the provider's context with WithContext for its entries to be written.
+func WithLogger(l common.Logger) CreateOpt {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
+
+// WithCredentials override the credentials.Provider that the client ID, secret and tenant ID are taken from,
+// the default is credentials.FromResourceData
+func WithCredentials(p credentials.Provider) CreateOpt {
+	return func(h *Handler) {
+		h.credentials = p
+	}
+}
+
+// WithTokenCache set the on-disk tokencache.Cache that is consulted before generating a token, generated
+// tokens are stored in it.  This overrides the token_cache provider field.
+func WithTokenCache(c *tokencache.Cache) CreateOpt {
+	return func(h *Handler) {
+		h.tokenCache = c
+	}
+}
+
 // resourceData is a generic model which implements Get function.
 type resourceData interface {
 	Get(key string) interface{}
@@ -66,12 +182,26 @@ func NewHandler(d resourceData, opts ...CreateOpt) (common.TokenChannelInterface
 	h.tenantID = d.Get("tenant_id").(string)
 	h.clientID = d.Get("user_id").(string)
 	h.clientSecret = d.Get("user_secret").(string)
+	h.providerTenantID = h.tenantID
+
+	// the credentials are resolved lazily, before each IAM call, so that rotated credentials are picked up
+	h.credentials = credentials.FromResourceData(d)
 	h.vendedServiceClient = d.Get("api_vended_service_client").(bool)
 
 	// get passed-in token, if present
 	passedInToken := d.Get("iam_token").(string)
 
-	h.client = httpc.New(h.iamServiceURL, h.vendedServiceClient, passedInToken)
+	// load the client authentication method, the client_auth fields may not be present in all models
+	clientAuth, err := clientAuthConfig(d).Load()
+	if err != nil {
+		return nil, err
+	}
+
+	h.ctx = context.Background()
+	h.refreshFraction = defaultRefreshFraction
+	h.refreshJitter = defaultRefreshJitter
+	h.retryPolicy = tokenutil.DefaultRetryPolicy()
+	h.clock = common.RealClock()
 
 	// run overrides
 	for _, opt := range opts {
@@ -79,13 +209,88 @@ func NewHandler(d resourceData, opts ...CreateOpt) (common.TokenChannelInterface
 			opt(h)
 		}
 	}
+	h.logger = common.LoggerOrDefault(h.logger)
+
+	// the transport is shared with any service clients that use the same transport settings
+	t, err := transport.Shared(
+		transport.ConfigFromResourceData(d),
+		transport.WithLogger(h.logger),
+		transport.WithContext(h.ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the default IdentityAPI if it hasn't been overridden
+	if h.client == nil {
+		h.client = httpc.New(
+			h.iamServiceURL,
+			h.vendedServiceClient,
+			passedInToken,
+			httpc.WithClientAuth(clientAuth),
+			httpc.WithTransport(t),
+			httpc.WithRetryPolicy(h.retryPolicy),
+			httpc.WithClock(h.clock),
+			httpc.WithLogger(h.logger),
+		)
+	}
+
+	// a passed-in token isn't generated from credentials, so they aren't resolved and the token isn't cached.
+	// Otherwise set-up the token cache if asked to, the token_cache fields may not be present in all models.
+	if passedInToken != "" {
+		h.credentials = nil
+		h.tokenCache = nil
+	} else if h.tokenCache == nil {
+		h.tokenCache = newTokenCache(h, d)
+	}
 
-	// make channels
+	// verify passed-in tokens if asked to, iam_token_verify may not be present in all models.  The issuer is
+	// pinned so that the JWKS isn't fetched from an issuer named by the unverified token.
+	verifyToken, _ := d.Get("iam_token_verify").(bool)
+	if h.verifier == nil && verifyToken && passedInToken != "" {
+		issuer, _ := d.Get("iam_token_issuer").(string)
+		if issuer == "" {
+			issuer = h.iamServiceURL
+		}
+		audience, _ := d.Get("iam_token_audience").(string)
+
+		h.verifier = verify.New(
+			verify.WithIssuer(issuer),
+			verify.WithAudience(audience),
+			verify.WithHTTPClient(&http.Client{Timeout: 30 * time.Second, Transport: t}),
+			verify.WithClock(h.clock),
+		)
+	}
+
+	if h.refreshFraction <= 0 || h.refreshFraction >= 1 {
+		return nil, errors.New("token refresh fraction must be greater than 0 and less than 1")
+	}
+
+	if h.refreshJitter < 0 || h.refreshJitter >= h.refreshFraction {
+		return nil, errors.New("token refresh jitter must be at least 0 and less than the refresh fraction")
+	}
+
+	// derive the context that is cancelled to stop the retrieve and refresh threads
+	h.ctx, h.cancel = context.WithCancel(h.ctx)
+
+	// make channels, exitCh is buffered so that an exit signal can be sent while the retrieve
+	// thread is busy
 	h.resultCh = make(chan common.Result)
-	h.exitCh = make(chan int)
+	h.exitCh = make(chan int, 1)
+	h.doneCh = make(chan struct{})
+	h.readyCh = make(chan struct{})
+	h.updateCh = make(chan struct{}, 1)
 
-	// set-up retrieve thread on channel
-	h.startRetrieveThread()
+	// set-up refresh thread and the retrieve thread on channel
+	var wg sync.WaitGroup
+	wg.Add(2)
+	h.startRefreshThread(&wg)
+	h.startRetrieveThread(&wg)
+
+	go func() {
+		wg.Wait()
+		close(h.doneCh)
+	}()
 
 	return h, nil
 }
@@ -95,111 +300,265 @@ func (h *Handler) TokenChannels() (chan common.Result, chan int) { // nolint gol
 	return h.resultCh, h.exitCh
 }
 
+// Token returns the cached token, waiting for the first token to be generated if necessary.  This
+// doesn't go through the retrieve thread so can be called concurrently by any number of readers.
+func (h *Handler) Token(ctx context.Context) (string, error) {
+	if h.ctx.Err() != nil {
+		return "", common.ErrHandlerClosed
+	}
+
+	select {
+	case <-h.readyCh:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-h.ctx.Done():
+		return "", common.ErrHandlerClosed
+	}
+
+	res := h.currentResult()
+
+	return res.Token, res.Err
+}
+
+// Close stops the token retrieve thread, aborting any in-flight IAM call, and waits for it to exit.
+// An error is returned if ctx is done before the thread has exited cleanly.
+func (h *Handler) Close(ctx context.Context) error {
+	h.cancel()
+
+	select {
+	case <-h.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // startRetrieveThread start the token retrieve thread
-// function in an infinite loop, it puts the return value of retrieveToken into h.resultCh by default
-// if a signal on exitCh is received the thread exits
-func (h *Handler) startRetrieveThread() {
+// function in an infinite loop, it puts the current cached token into h.resultCh
+// if a signal on exitCh is received, or the Handler context is cancelled, the thread exits.  resultCh
+// is closed on exit so that any waiting retrieve functions return.
+func (h *Handler) startRetrieveThread(wg *sync.WaitGroup) {
+	// An exit signal cancels the Handler context, which aborts any in-flight IAM call
 	go func() {
+		select {
+		case <-h.exitCh:
+			h.cancel()
+		case <-h.ctx.Done():
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer close(h.resultCh)
+
+		// Wait for the first token to be generated
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-h.readyCh:
+		}
+
 		for {
+			// The send is re-evaluated whenever the cache is updated so that a waiting send never
+			// holds a stale token
 			select {
-			case <-h.exitCh:
-				// TODO we need to set-up a context here and cancel it so that the TokenGenerate call is killed
+			case <-h.ctx.Done():
 				return
-			default:
-				h.resultCh <- h.retrieveToken()
+			case <-h.updateCh:
+			case h.resultCh <- h.currentResult():
 			}
 		}
 	}()
 }
 
-// retrieveToken function to get a token
-// The token is stashed in the handler.  If its time-to-expiry is <= common.TimeToTokenExpiry then it is
-// regenerated.
-// If we have to regenerate a token we will retry in the case where the error is retryable up to retryLimit times
-// Currently the only error that is retryable is a net Timeout error
-func (h *Handler) retrieveToken() common.Result {
-	// We use a loop since we may need to retry depending on the error that we get from IAM
-	// Reset numRetries
-	h.numRetries = 0
-	for {
-		// Get current time in Unix "epoch" seconds
-		now := time.Now().Unix()
-
-		// Generate token if there isn't any
-		if h.token == "" {
-			token, retry, err := h.generateToken()
-			if retry {
-				continue
-			}
+// currentResult returns the cached token, or the error from the last refresh if there is no valid token.  A
+// token that has expired since it was cached isn't returned.
+func (h *Handler) currentResult() common.Result {
+	c := h.cache.Load()
+	if c == nil {
+		return common.Result{Token: "", Err: errors.New("no token has been generated")}
+	}
 
-			if err != nil {
-				return common.Result{
-					Token: "",
-					Err:   err,
-				}
-			}
+	if c.token != "" && c.expiry.After(h.clock.Now()) {
+		return common.Result{Token: c.token, Err: nil}
+	}
 
-			h.token = token
-		}
+	if c.err == nil && c.token != "" {
+		return common.Result{Token: "", Err: tokenerrors.MakeErrExpiredToken(c.expiry)}
+	}
 
-		// Decode token
-		tokenDetails, err := tokenutil.DecodeAccessToken(h.token)
-		if err != nil {
-			return common.Result{
-				Token: "",
-				Err:   err,
-			}
-		}
+	return common.Result{Token: "", Err: c.err}
+}
 
-		// If token is about to expire in TimeToTokenExpiry seconds or less generate a new one
-		if tokenDetails.Expiry-now <= common.TimeToTokenExpiry {
-			token, retry, err := h.generateToken()
-			if retry {
-				continue
-			}
+// generateToken simple function to call the API client's GenerateToken.  Retries are left to the
+// tokenutil.RetryPolicy of the IdentityAPI, so that there is a single retry layer.
+func (h *Handler) generateToken() (common.AccessToken, error) {
+	// Derive a context for this request from the Handler context, so that the call is aborted if the
+	// Handler is stopped
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
 
-			if err != nil {
-				return common.Result{
-					Token: "",
-					Err:   err,
-				}
-			}
+	return h.requestToken(ctx)
+}
 
-			h.token = token
-		}
+// clientAuthConfig returns the clientauth.Config from the client authentication fields in d
+func clientAuthConfig(d resourceData) clientauth.Config {
+	getString := func(key string) string {
+		v, _ := d.Get(key).(string)
+
+		return v
+	}
+
+	return clientauth.Config{
+		Method:             clientauth.Method(getString("client_auth_method")),
+		PrivateKey:         getString("client_private_key"),
+		PrivateKeyFile:     getString("client_private_key_file"),
+		KeyID:              getString("client_key_id"),
+		CertificateFile:    getString("client_certificate_file"),
+		CertificateKeyFile: getString("client_certificate_key_file"),
+	}
+}
+
+// newTokenCache creates the token cache if the token_cache field is set in d.  The cache is an optimisation,
+// if it can't be used a warning is logged and tokens aren't cached.
+func newTokenCache(h *Handler, d resourceData) *tokencache.Cache {
+	if enabled, _ := d.Get("token_cache").(bool); !enabled {
+		return nil
+	}
+
+	if method, _ := d.Get("client_auth_method").(string); method != "" && method != string(clientauth.MethodClientSecret) {
+		h.logger.Warn(h.ctx, "Tokens aren't cached, the token cache needs user_secret to encrypt them", nil)
 
-		return common.Result{
-			Token: h.token,
-			Err:   nil,
+		return nil
+	}
+
+	dir, _ := d.Get("token_cache_dir").(string)
+	c, err := tokencache.New(dir, tokencache.WithClock(h.clock))
+	if err != nil {
+		h.logger.Warn(h.ctx, "Tokens aren't cached, the token cache can't be used", map[string]interface{}{
+			common.LogFieldError: err,
+		})
+
+		return nil
+	}
+
+	return c
+}
+
+// requestToken returns a token from the token cache, if there is one that is newer than the current token,
+// otherwise it requests a token from IAM and stores it in the cache
+func (h *Handler) requestToken(ctx context.Context) (common.AccessToken, error) {
+	if err := h.resolveCredentials(ctx); err != nil {
+		return common.AccessToken{}, err
+	}
+
+	// tokens can't be cached without a secret to encrypt them
+	if h.tokenCache == nil || h.clientSecret == "" {
+		return h.requestTokenFromIAM(ctx)
+	}
+
+	key := tokencache.Key{
+		IAMServiceURL: h.iamServiceURL,
+		IAMVersion:    h.iamVersion,
+		TenantID:      h.tenantID,
+		ClientID:      h.clientID,
+	}
+
+	token, found, err := h.tokenCache.Get(key, h.clientSecret)
+	if err != nil {
+		h.logger.Warn(ctx, "Error reading the token cache", map[string]interface{}{common.LogFieldError: err})
+	}
+
+	// Don't serve the current token again, it is being refreshed
+	if c := h.cache.Load(); found && (c == nil || c.token != token.Value) {
+		h.logger.Debug(ctx, "Token read from the token cache", map[string]interface{}{
+			common.LogFieldIAMVersion:  h.iamVersion,
+			common.LogFieldClientID:    h.clientID,
+			common.LogFieldTokenExpiry: token.Expiry,
+		})
+
+		// Keep the refresh token, if any, the cache doesn't hold them
+		if c != nil {
+			token.RefreshToken = c.refreshToken
 		}
+
+		return token, nil
 	}
+
+	token, err = h.requestTokenFromIAM(ctx)
+	if err != nil {
+		return token, err
+	}
+
+	if err := h.tokenCache.Put(key, h.clientSecret, token); err != nil {
+		h.logger.Warn(ctx, "Error writing the token cache", map[string]interface{}{common.LogFieldError: err})
+	}
+
+	return token, nil
 }
 
-// generateToken simple function to call the API client's GenerateToken
-func (h *Handler) generateToken() (string, bool, error) {
-	var token string
-	var err error
+// resolveCredentials sets the client ID, secret and tenant ID from the credentials Provider.  If it has no
+// credentials configured those from the provider fields are kept, i.e. empty ones.
+func (h *Handler) resolveCredentials(ctx context.Context) error {
+	if h.credentials == nil {
+		return nil
+	}
 
-	// TODO pass a context down to here
-	token, err = h.client.GenerateToken(context.Background(), h.tenantID, h.clientID, h.clientSecret, h.iamVersion)
+	creds, err := h.credentials.Retrieve(ctx)
+	if errors.Is(err, credentials.ErrNoCredentials) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-	// If this is a retryable error check to see if we've reached our retryLimit or not, if we can retry again
-	// return true
-	if err != nil && isErrRetryable(err) {
-		h.numRetries++
+	if creds.ClientID != h.clientID || creds.ClientSecret != h.clientSecret {
+		h.logger.Debug(ctx, "Credentials resolved", map[string]interface{}{
+			common.LogFieldClientID: creds.ClientID,
+			"source":                creds.Source,
+		})
+	}
 
-		return token, h.numRetries <= retryLimit, err
+	h.clientID = creds.ClientID
+	h.clientSecret = creds.ClientSecret
+	h.tenantID = creds.TenantID
+	if h.tenantID == "" {
+		h.tenantID = h.providerTenantID
 	}
 
-	return token, false, err
+	return nil
 }
 
-// isErrRetryable checks if an error is retryable, currently limited to net Timeout errors
-func isErrRetryable(err error) bool {
-	var t net.Error
-	if errors.As(err, &t) && t.Timeout() {
-		return true
+// requestTokenFromIAM renews the token with the refresh_token grant if IAM issued a refresh token and the
+// IdentityAPI supports it, otherwise the token is generated with client credentials.  We fall back to
+// client credentials if IAM rejects the refresh token with invalid_grant.
+func (h *Handler) requestTokenFromIAM(ctx context.Context) (common.AccessToken, error) {
+	var refreshToken string
+	if c := h.cache.Load(); c != nil {
+		refreshToken = c.refreshToken
+	}
+
+	refresher, ok := h.client.(TokenRefresher)
+	if refreshToken == "" || !ok {
+		return h.client.GenerateToken(ctx, h.tenantID, h.clientID, h.clientSecret, h.iamVersion)
+	}
+
+	token, err := refresher.RefreshToken(ctx, h.tenantID, h.clientID, h.clientSecret, refreshToken, h.iamVersion)
+
+	var invalidGrant *tokenerrors.ErrInvalidGrant
+	if errors.As(err, &invalidGrant) {
+		h.logger.Info(ctx, "Refresh token rejected, generating a new token with client credentials", map[string]interface{}{
+			common.LogFieldIAMVersion: h.iamVersion,
+			common.LogFieldClientID:   h.clientID,
+		})
+
+		return h.client.GenerateToken(ctx, h.tenantID, h.clientID, h.clientSecret, h.iamVersion)
+	}
+
+	// IAM needn't issue a new refresh token on every refresh, keep using the old one if it doesn't
+	if err == nil && token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
 	}
 
-	return false
+	return token, err
 }
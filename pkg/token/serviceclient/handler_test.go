@@ -5,17 +5,23 @@ package serviceclient_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/credentials"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/mocks"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/iamfake"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/retrieve"
 	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/serviceclient"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokentest"
 
 	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
 
@@ -103,9 +109,11 @@ func TestHandler(t *testing.T) {
 			assert.NoError(t, err)
 
 			testToken := generateTestToken(600)
-			mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(testToken, tc.err).MaxTimes(8)
+			mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(common.AccessToken{Value: testToken}, tc.err).MaxTimes(8)
 
-			handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock))
+			// The test tokens expire 600s after the epoch
+			clock := tokentest.NewFakeClock(time.Unix(0, 0))
+			handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock), serviceclient.WithClock(clock))
 			assert.NoError(t, err)
 			if handler != nil {
 				getToken := retrieve.NewTokenRetrieveFunc(handler)
@@ -150,3 +158,266 @@ func (e testNetError) Temporary() bool {
 func (e testNetError) Error() string {
 	return ""
 }
+
+func TestHandlerClose(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), make(map[string]interface{}))
+	mock := mocks.NewMockIdentityAPI(ctrl)
+	mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(common.AccessToken{Value: generateTestToken(600)}, nil).AnyTimes()
+
+	handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+
+	// The retrieve function reports that the handler has been closed
+	_, err = retrieve.NewTokenRetrieveFunc(handler)(context.Background())
+	assert.ErrorIs(t, err, common.ErrHandlerClosed)
+}
+
+func TestHandlerCancelInFlight(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), make(map[string]interface{}))
+	mock := mocks.NewMockIdentityAPI(ctrl)
+
+	// GenerateToken blocks until its context is cancelled
+	started := make(chan struct{})
+	mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _, _, _, _ string) (common.AccessToken, error) {
+			close(started)
+			<-ctx.Done()
+
+			return common.AccessToken{}, ctx.Err()
+		}).Times(1)
+
+	parentCtx, parentCancel := context.WithCancel(context.Background())
+	handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock), serviceclient.WithContext(parentCtx))
+	assert.NoError(t, err)
+
+	// Cancel the parent context once the IAM call is in-flight
+	<-started
+	parentCancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+}
+
+func TestHandlerRetrieveCancelInFlight(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), make(map[string]interface{}))
+	mock := mocks.NewMockIdentityAPI(ctrl)
+
+	started := make(chan struct{})
+	mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _, _, _, _ string) (common.AccessToken, error) {
+			close(started)
+			<-ctx.Done()
+
+			return common.AccessToken{}, ctx.Err()
+		}).Times(1)
+
+	handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock))
+	assert.NoError(t, err)
+
+	// Cancelling the caller's context while the IAM call is in-flight stops the handler
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	token, err := retrieve.NewTokenRetrieveFunc(handler)(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "", token)
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	assert.NoError(t, handler.(common.TokenHandlerCloser).Close(closeCtx))
+}
+
+func TestHandlerTokenCache(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), map[string]interface{}{
+		"user_id":         "clientID",
+		"user_secret":     "secret",
+		"token_cache":     true,
+		"token_cache_dir": t.TempDir(),
+	})
+
+	// The token is generated once, the second Handler stands in for another provider process and reads it
+	// from the cache
+	token := generateTestToken(600)
+	mock := mocks.NewMockIdentityAPI(ctrl)
+	mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(common.AccessToken{Value: token, Expiry: time.Now().Add(time.Hour)}, nil).Times(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		handler, err := serviceclient.NewHandler(d, serviceclient.WithIdentityAPI(mock))
+		assert.NoError(t, err)
+
+		got, err := handler.(common.TokenReader).Token(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, token, got)
+		assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+	}
+}
+
+// rotatingCredentials returns new credentials each time they are retrieved
+type rotatingCredentials struct {
+	n int
+}
+
+func (r *rotatingCredentials) Retrieve(_ context.Context) (credentials.Credentials, error) {
+	r.n++
+
+	return credentials.Credentials{ClientID: "clientID", ClientSecret: fmt.Sprintf("secret-%d", r.n)}, nil
+}
+
+// failingCredentials fails to retrieve credentials
+type failingCredentials struct{}
+
+func (failingCredentials) Retrieve(_ context.Context) (credentials.Credentials, error) {
+	return credentials.Credentials{}, errors.New("credentials should not be retrieved")
+}
+
+func TestHandlerPassedInTokenSkipsCredentials(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), map[string]interface{}{"iam_token": "passed-in"})
+
+	token := generateTestToken(600)
+	mock := mocks.NewMockIdentityAPI(ctrl)
+	mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(common.AccessToken{Value: token, Expiry: time.Now().Add(time.Hour)}, nil).Times(1)
+
+	handler, err := serviceclient.NewHandler(
+		d,
+		serviceclient.WithIdentityAPI(mock),
+		serviceclient.WithCredentials(failingCredentials{}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got, err := handler.(common.TokenReader).Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, token, got)
+	assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+}
+
+func TestHandlerCredentials(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	d := schema.TestResourceDataRaw(t, provider.Schema(), map[string]interface{}{"tenant_id": "tenantID"})
+
+	// The credentials are resolved before each IAM call, so rotated secrets are used.  The tenant_id field
+	// is used as the credentials don't have a tenant.
+	mock := mocks.NewMockIdentityAPI(ctrl)
+	gomock.InOrder(
+		mock.EXPECT().GenerateToken(gomock.Any(), "tenantID", "clientID", "secret-1", gomock.Any()).
+			Return(common.AccessToken{Value: generateTestToken(600), Expiry: time.Now().Add(time.Second)}, nil),
+		mock.EXPECT().GenerateToken(gomock.Any(), "tenantID", "clientID", "secret-2", gomock.Any()).
+			Return(common.AccessToken{Value: generateTestToken(600), Expiry: time.Now().Add(time.Hour)}, nil),
+	)
+
+	clock := tokentest.NewFakeClock(time.Now())
+	handler, err := serviceclient.NewHandler(
+		d,
+		serviceclient.WithIdentityAPI(mock),
+		serviceclient.WithCredentials(&rotatingCredentials{}),
+		serviceclient.WithClock(clock),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Trigger the refresh once it has been scheduled
+	assert.NoError(t, clock.WaitForTimers(ctx, 1))
+	clock.Advance(time.Minute)
+	assert.NoError(t, clock.WaitForTimers(ctx, 1))
+
+	assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+}
+
+func TestHandlerVerifyPassedInToken(t *testing.T) {
+	t.Parallel()
+	iam := iamfake.New()
+	t.Cleanup(iam.Close)
+	other := iamfake.New()
+	t.Cleanup(other.Close)
+
+	iamToken, err := iam.MintToken("clientID", nil)
+	assert.NoError(t, err)
+	otherToken, err := other.MintToken("clientID", nil)
+	assert.NoError(t, err)
+
+	testcases := []struct {
+		name     string
+		config   map[string]interface{}
+		hasError bool
+	}{
+		{
+			name:   "token issued by iam_service_url",
+			config: map[string]interface{}{"iam_token": iamToken, "iam_service_url": iam.GLCSServiceURL()},
+		},
+		{
+			name: "token issued by iam_token_issuer",
+			config: map[string]interface{}{
+				"iam_token":        iamToken,
+				"iam_service_url":  "https://iam.example.com",
+				"iam_token_issuer": iam.Issuer(),
+			},
+		},
+		{
+			name:     "token issued by another issuer",
+			config:   map[string]interface{}{"iam_token": otherToken, "iam_service_url": iam.GLCSServiceURL()},
+			hasError: true,
+		},
+		{
+			name: "wrong audience",
+			config: map[string]interface{}{
+				"iam_token":          iamToken,
+				"iam_service_url":    iam.GLCSServiceURL(),
+				"iam_token_audience": "hpegl",
+			},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.config["iam_token_verify"] = true
+			d := schema.TestResourceDataRaw(t, provider.Schema(), tc.config)
+
+			handler, err := serviceclient.NewHandler(d)
+			assert.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			got, err := handler.(common.TokenReader).Token(ctx)
+			if tc.hasError {
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, context.DeadlineExceeded)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.config["iam_token"], got)
+			}
+			assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+		})
+	}
+}
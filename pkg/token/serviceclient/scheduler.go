@@ -0,0 +1,194 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package serviceclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
+)
+
+const (
+	// defaultRefreshFraction is the fraction of a token's lifetime after which it is refreshed
+	defaultRefreshFraction = 0.75
+	// defaultRefreshJitter is the maximum jitter subtracted from the refresh time, as a fraction of the
+	// token's lifetime
+	defaultRefreshJitter = 0.1
+	// minRefreshInterval is the minimum time between token refreshes, it is also the interval after which
+	// a failed refresh is retried
+	minRefreshInterval = 10 * time.Second
+)
+
+// cachedToken is the token state shared by the refresh thread and readers.  A new cachedToken is stored
+// on every refresh, existing values are never modified.
+type cachedToken struct {
+	token string
+	// expiry of the token
+	expiry time.Time
+	// fetched is when the token was generated
+	fetched time.Time
+	// err is the error from the last refresh, if it failed
+	err error
+	// refreshToken is the refresh token issued by IAM, if any, it is used for the next refresh
+	refreshToken string
+}
+
+// startRefreshThread start the token refresh thread
+// The token is generated when the thread starts and is then refreshed in the background after
+// refreshFraction of its lifetime, less some jitter, has passed.  A token is always refreshed at least
+// common.TimeToTokenExpiry seconds before it expires.  If a refresh fails the old token continues to be
+// served for as long as it is valid, and the refresh is retried after minRefreshInterval.
+func (h *Handler) startRefreshThread(wg *sync.WaitGroup) {
+	go func() {
+		defer wg.Done()
+
+		for {
+			h.refresh()
+			h.readyOnce.Do(func() { close(h.readyCh) })
+
+			next := h.nextRefresh()
+			h.logRefresh(next)
+
+			timer := h.clock.NewTimer(next)
+			select {
+			case <-h.ctx.Done():
+				timer.Stop()
+
+				return
+			case <-timer.C():
+			}
+		}
+	}()
+}
+
+// refresh generates a new token and stores it in the cache.  If generation fails the old token is kept
+// if it is still valid, otherwise the error is stored.  The old refresh token is kept in either case.
+func (h *Handler) refresh() {
+	c, err := h.retrieveToken()
+	if err != nil {
+		now := h.clock.Now()
+		old := h.cache.Load()
+		switch {
+		case old != nil && old.token != "" && old.expiry.After(now):
+			c = &cachedToken{
+				token:        old.token,
+				expiry:       old.expiry,
+				fetched:      old.fetched,
+				err:          err,
+				refreshToken: old.refreshToken,
+			}
+		case old != nil:
+			c = &cachedToken{fetched: now, err: err, refreshToken: old.refreshToken}
+		default:
+			c = &cachedToken{fetched: now, err: err}
+		}
+	}
+
+	h.cache.Store(c)
+
+	// Let the retrieve thread know that the cache has been updated
+	select {
+	case h.updateCh <- struct{}{}:
+	default:
+	}
+}
+
+// logRefresh logs the outcome of the last refresh and when the next one is due
+func (h *Handler) logRefresh(next time.Duration) {
+	c := h.cache.Load()
+	if c == nil {
+		return
+	}
+
+	fields := map[string]interface{}{
+		common.LogFieldIAMVersion:  h.iamVersion,
+		common.LogFieldClientID:    h.clientID,
+		common.LogFieldNextRefresh: next,
+	}
+	if c.token != "" {
+		fields[common.LogFieldTokenExpiry] = c.expiry
+	}
+
+	if c.err != nil {
+		fields[common.LogFieldError] = c.err
+		h.logger.Warn(h.ctx, "Token refresh failed", fields)
+
+		return
+	}
+
+	h.logger.Debug(h.ctx, "Token refreshed", fields)
+}
+
+// nextRefresh returns the time to wait before the next refresh
+func (h *Handler) nextRefresh() time.Duration {
+	c := h.cache.Load()
+	if c == nil || c.err != nil {
+		return minRefreshInterval
+	}
+
+	lifetime := c.expiry.Sub(c.fetched)
+	jitter := time.Duration(rand.Float64() * h.refreshJitter * float64(lifetime)) //nolint:gosec
+	wait := time.Duration(h.refreshFraction*float64(lifetime)) - jitter
+
+	// Refresh at least TimeToTokenExpiry seconds before the token expires
+	if latest := lifetime - common.TimeToTokenExpiry*time.Second; wait > latest {
+		wait = latest
+	}
+
+	if wait < minRefreshInterval {
+		wait = minRefreshInterval
+	}
+
+	return wait
+}
+
+// retrieveToken function to generate a token, the IdentityAPI retries the request as set out by its
+// tokenutil.RetryPolicy
+func (h *Handler) retrieveToken() (*cachedToken, error) {
+	// Don't try to generate a token if the Handler has been stopped
+	if err := h.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fetched := h.clock.Now()
+	token, err := h.generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the token if we have a verifier, the expiry is taken from the verified claims
+	if h.verifier != nil {
+		tokenDetails, err := h.verifier.Verify(h.ctx, token.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cachedToken{
+			token:        token.Value,
+			expiry:       time.Unix(tokenDetails.Expiry, 0),
+			fetched:      fetched,
+			refreshToken: token.RefreshToken,
+		}, nil
+	}
+
+	// Use the expiry reported by IAM, only decode the token if there isn't one.  This means that
+	// opaque access tokens work as long as IAM reports an expiry.
+	expiry := token.Expiry
+	if expiry.IsZero() {
+		tokenDetails, err := tokenutil.DecodeAccessToken(token.Value)
+		if err != nil {
+			return nil, err
+		}
+		expiry = time.Unix(tokenDetails.Expiry, 0)
+	}
+
+	return &cachedToken{
+		token:        token.Value,
+		expiry:       expiry,
+		fetched:      fetched,
+		refreshToken: token.RefreshToken,
+	}, nil
+}
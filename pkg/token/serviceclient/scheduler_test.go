@@ -0,0 +1,409 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package serviceclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/mocks"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/provider"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	tokenerrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokentest"
+)
+
+func signedToken(t *testing.T, expiry time.Time) string {
+	t.Helper()
+	sign, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("secret")}, nil)
+	require.NoError(t, err)
+
+	token, err := jwt.Signed(sign).Claims(tokenutil.Token{Expiry: expiry.Unix()}).CompactSerialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestNextRefresh(t *testing.T) {
+	t.Parallel()
+	fetched := time.Now()
+	testcases := []struct {
+		name     string
+		cache    *cachedToken
+		fraction float64
+		jitter   float64
+		minWait  time.Duration
+		maxWait  time.Duration
+	}{
+		{
+			name:     "fraction of lifetime",
+			cache:    &cachedToken{token: "token", fetched: fetched, expiry: fetched.Add(time.Hour)},
+			fraction: 0.75,
+			minWait:  45 * time.Minute,
+			maxWait:  45 * time.Minute,
+		},
+		{
+			name:     "fraction of lifetime with jitter",
+			cache:    &cachedToken{token: "token", fetched: fetched, expiry: fetched.Add(time.Hour)},
+			fraction: 0.75,
+			jitter:   0.1,
+			minWait:  39 * time.Minute,
+			maxWait:  45 * time.Minute,
+		},
+		{
+			name:     "refresh before TimeToTokenExpiry",
+			cache:    &cachedToken{token: "token", fetched: fetched, expiry: fetched.Add(5 * time.Minute)},
+			fraction: 0.75,
+			minWait:  3 * time.Minute,
+			maxWait:  3 * time.Minute,
+		},
+		{
+			name:     "short lifetime",
+			cache:    &cachedToken{token: "token", fetched: fetched, expiry: fetched.Add(time.Minute)},
+			fraction: 0.75,
+			minWait:  minRefreshInterval,
+			maxWait:  minRefreshInterval,
+		},
+		{
+			name:     "failed refresh",
+			cache:    &cachedToken{token: "token", fetched: fetched, expiry: fetched.Add(time.Hour), err: errors.New("")},
+			fraction: 0.75,
+			minWait:  minRefreshInterval,
+			maxWait:  minRefreshInterval,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h := &Handler{refreshFraction: tc.fraction, refreshJitter: tc.jitter}
+			h.cache.Store(tc.cache)
+
+			wait := h.nextRefresh()
+			assert.GreaterOrEqual(t, wait, tc.minWait)
+			assert.LessOrEqual(t, wait, tc.maxWait)
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	refreshErr := errors.New("refresh failed")
+	newToken := signedToken(t, now.Add(time.Hour))
+	testcases := []struct {
+		name     string
+		old      *cachedToken
+		token    string
+		expiry   time.Time
+		err      error
+		expToken string
+		expErr   error
+	}{
+		{
+			name:     "new token",
+			old:      &cachedToken{token: "old", fetched: now, expiry: now.Add(time.Minute)},
+			token:    newToken,
+			expToken: newToken,
+		},
+		{
+			name:     "opaque token with expiry reported by IAM",
+			token:    "opaque-token",
+			expiry:   now.Add(time.Hour),
+			expToken: "opaque-token",
+		},
+		{
+			name:     "fall back to valid old token",
+			old:      &cachedToken{token: "old", fetched: now, expiry: now.Add(time.Minute)},
+			err:      refreshErr,
+			expToken: "old",
+			expErr:   refreshErr,
+		},
+		{
+			name:   "old token expired",
+			old:    &cachedToken{token: "old", fetched: now.Add(-time.Hour), expiry: now.Add(-time.Minute)},
+			err:    refreshErr,
+			expErr: refreshErr,
+		},
+		{
+			name:   "no old token",
+			err:    refreshErr,
+			expErr: refreshErr,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			mock := mocks.NewMockIdentityAPI(ctrl)
+			mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(common.AccessToken{Value: tc.token, Expiry: tc.expiry}, tc.err)
+
+			h := &Handler{
+				ctx:      context.Background(),
+				client:   mock,
+				updateCh: make(chan struct{}, 1),
+				clock:    common.RealClock(),
+				logger:   common.LoggerOrDefault(nil),
+			}
+			if tc.old != nil {
+				h.cache.Store(tc.old)
+			}
+
+			h.refresh()
+			assert.Len(t, h.updateCh, 1)
+
+			c := h.cache.Load()
+			assert.Equal(t, tc.expToken, c.token)
+			assert.Equal(t, tc.expErr, c.err)
+
+			res := h.currentResult()
+			assert.Equal(t, tc.expToken, res.Token)
+			if tc.expToken == "" {
+				assert.Equal(t, tc.expErr, res.Err)
+			} else {
+				assert.NoError(t, res.Err)
+			}
+		})
+	}
+}
+
+// testRefresher is an IdentityAPI that supports the refresh_token grant
+type testRefresher struct {
+	IdentityAPI
+	refreshToken string
+	refreshErr   error
+	// refreshedWith is the refresh token passed to the last RefreshToken call
+	refreshedWith string
+}
+
+func (r *testRefresher) RefreshToken(
+	_ context.Context,
+	_, _, _, refreshToken, _ string,
+) (common.AccessToken, error) {
+	r.refreshedWith = refreshToken
+	if r.refreshErr != nil {
+		return common.AccessToken{}, r.refreshErr
+	}
+
+	return common.AccessToken{Value: "refreshed", Expiry: time.Now().Add(time.Hour), RefreshToken: r.refreshToken}, nil
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	testcases := []struct {
+		name            string
+		oldRefreshToken string
+		newRefreshToken string
+		refreshErr      error
+		generate        bool
+		expToken        string
+		expRefreshToken string
+		expErr          bool
+	}{
+		{
+			name:            "no refresh token",
+			generate:        true,
+			expToken:        "generated",
+			expRefreshToken: "generated-refresh",
+		},
+		{
+			name:            "refresh token rotated",
+			oldRefreshToken: "old-refresh",
+			newRefreshToken: "new-refresh",
+			expToken:        "refreshed",
+			expRefreshToken: "new-refresh",
+		},
+		{
+			name:            "refresh token reused",
+			oldRefreshToken: "old-refresh",
+			expToken:        "refreshed",
+			expRefreshToken: "old-refresh",
+		},
+		{
+			name:            "invalid grant falls back to client credentials",
+			oldRefreshToken: "old-refresh",
+			refreshErr:      tokenerrors.MakeErrInvalidGrant(tokenerrors.ErrorResponse{Message: "invalid grant"}),
+			generate:        true,
+			expToken:        "generated",
+			expRefreshToken: "generated-refresh",
+		},
+		{
+			name:            "other errors don't fall back",
+			oldRefreshToken: "old-refresh",
+			refreshErr:      errors.New("refresh failed"),
+			expToken:        "old",
+			expRefreshToken: "old-refresh",
+			expErr:          true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			mock := mocks.NewMockIdentityAPI(ctrl)
+			if tc.generate {
+				mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(common.AccessToken{
+						Value:        "generated",
+						Expiry:       now.Add(time.Hour),
+						RefreshToken: "generated-refresh",
+					}, nil)
+			}
+			refresher := &testRefresher{IdentityAPI: mock, refreshToken: tc.newRefreshToken, refreshErr: tc.refreshErr}
+
+			h := &Handler{
+				ctx:      context.Background(),
+				client:   refresher,
+				updateCh: make(chan struct{}, 1),
+				clock:    common.RealClock(),
+				logger:   common.LoggerOrDefault(nil),
+			}
+			h.cache.Store(&cachedToken{token: "old", fetched: now, expiry: now.Add(time.Minute), refreshToken: tc.oldRefreshToken})
+
+			h.refresh()
+
+			c := h.cache.Load()
+			assert.Equal(t, tc.expToken, c.token)
+			assert.Equal(t, tc.expRefreshToken, c.refreshToken)
+			assert.Equal(t, tc.expErr, c.err != nil)
+			assert.Equal(t, tc.oldRefreshToken, refresher.refreshedWith)
+		})
+	}
+}
+
+func TestRefreshScheduling(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		name string
+		// lifetime of the first token
+		lifetime time.Duration
+		// expWait is the time after which the first token must be refreshed
+		expWait time.Duration
+	}{
+		{
+			name:     "fraction of lifetime",
+			lifetime: time.Hour,
+			expWait:  45 * time.Minute,
+		},
+		{
+			name:     "refresh TimeToTokenExpiry before expiry",
+			lifetime: 5 * time.Minute,
+			expWait:  3 * time.Minute,
+		},
+		{
+			name:     "token expires in 119s",
+			lifetime: 119 * time.Second,
+			expWait:  minRefreshInterval,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			clock := tokentest.NewFakeClock(start)
+			ctrl := gomock.NewController(t)
+			mock := mocks.NewMockIdentityAPI(ctrl)
+			generated := make(chan struct{}, 2)
+			mock.EXPECT().GenerateToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(context.Context, string, string, string, string) (common.AccessToken, error) {
+					generated <- struct{}{}
+
+					return common.AccessToken{Value: "token", Expiry: clock.Now().Add(tc.lifetime)}, nil
+				}).Times(2)
+
+			d := schema.TestResourceDataRaw(t, provider.Schema(), make(map[string]interface{}))
+			handler, err := NewHandler(
+				d,
+				WithIdentityAPI(mock),
+				WithClock(clock),
+				WithRefreshJitter(0),
+			)
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, handler.(common.TokenHandlerCloser).Close(ctx))
+			}()
+
+			<-generated
+			require.NoError(t, clock.WaitForTimers(ctx, 1))
+
+			// The refresh timer hasn't fired just before the refresh time
+			clock.Advance(tc.expWait - time.Second)
+			assert.Equal(t, 1, clock.Timers())
+
+			// The token is refreshed at the refresh time
+			clock.Advance(time.Second)
+			select {
+			case <-generated:
+			case <-ctx.Done():
+				t.Fatal("token not refreshed")
+			}
+		})
+	}
+}
+
+func TestCurrentResultExpiry(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	refreshErr := errors.New("refresh failed")
+	testcases := []struct {
+		name     string
+		cache    *cachedToken
+		expToken string
+		expErr   error
+	}{
+		{
+			name:     "valid token after a failed refresh",
+			cache:    &cachedToken{token: "token", expiry: now.Add(time.Second), err: refreshErr},
+			expToken: "token",
+		},
+		{
+			name:   "expired token after a failed refresh",
+			cache:  &cachedToken{token: "token", expiry: now, err: refreshErr},
+			expErr: refreshErr,
+		},
+		{
+			name:   "expired token",
+			cache:  &cachedToken{token: "token", expiry: now.Add(-time.Second)},
+			expErr: &tokenerrors.ErrExpiredToken{},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h := &Handler{clock: tokentest.NewFakeClock(now)}
+			h.cache.Store(tc.cache)
+
+			res := h.currentResult()
+			assert.Equal(t, tc.expToken, res.Token)
+			if tc.expErr == nil {
+				assert.NoError(t, res.Err)
+			} else {
+				assert.ErrorIs(t, res.Err, tc.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokenutil
+
+import (
+	"context"
+	stderrors "errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+)
+
+// RetryPolicy controls how DoRetriesWithPolicy retries a request.  The delay before retry n (counting from 1)
+// is BaseDelay * 2^(n-1), capped at MaxDelay, less up to Jitter of itself.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between attempts, it also caps Retry-After
+	MaxDelay time.Duration
+	// Jitter is the fraction of the delay that is randomised, from 0 to 1
+	Jitter float64
+	// AttemptTimeout is the timeout of each attempt, no timeout is set if it is zero
+	AttemptTimeout time.Duration
+	// RetryableStatusCodes are the response status codes that are retried
+	RetryableStatusCodes []int
+	// HonourRetryAfter waits for the delay in the Retry-After header of a retried response, if there is one,
+	// instead of the backoff delay
+	HonourRetryAfter bool
+	// Clock is used to wait between attempts, the real clock is used if it is nil
+	Clock common.Clock
+	// Logger logs the retries, common.TFLogger() is used if it is nil
+	Logger common.Logger
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used for IAM calls unless another is supplied
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		BaseDelay:      time.Second,
+		MaxDelay:       30 * time.Second,
+		Jitter:         0.2,
+		AttemptTimeout: 30 * time.Second,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		HonourRetryAfter: true,
+	}
+}
+
+// legacyRetryPolicy returns the fixed RetryPolicy used by DoRetries
+func legacyRetryPolicy(retries int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    retries,
+		BaseDelay:      5 * time.Second,
+		MaxDelay:       5 * time.Second,
+		AttemptTimeout: 3 * time.Second,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+		},
+	}
+}
+
+// DoRetriesWithPolicy executes call, retrying attempts that time out or return a retryable status code as
+// set out in policy.  Each attempt is passed a context derived from ctx with the policy AttemptTimeout, the
+// cancel functions of these contexts are appended to cancelFuncs so that the caller can read the response
+// body before cancelling them.  The bodies of retried responses are closed, except for the last one which is
+// returned with an error if the attempts are exhausted.  ctx is respected while waiting
+// between attempts, ctx.Err() is returned if it is done.
+func DoRetriesWithPolicy(
+	ctx context.Context,
+	cancelFuncs *[]context.CancelFunc,
+	call func(ctx context.Context) (*http.Request, *http.Response, error),
+	policy RetryPolicy,
+) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := common.LoggerOrDefault(policy.Logger)
+
+	var resp *http.Response
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		// Close the body of the response that is being retried
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+
+		// Create a new context with a timeout
+		attemptCtx, cancel := policy.attemptContext(ctx)
+
+		// Add the cancel function to the list of cancel functions
+		*cancelFuncs = append(*cancelFuncs, cancel)
+
+		// Execute the request
+		var req *http.Request
+		var err error
+		req, resp, err = call(attemptCtx)
+
+		fields := attemptFields(req, resp, attempt)
+		var retryAfter time.Duration
+		switch {
+		case req != nil && stderrors.Is(req.Context().Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+			// The attempt timed out, retry the request
+			fields[common.LogFieldError] = req.Context().Err()
+		case err != nil:
+			// For all other errors, return the error
+			return resp, err
+		case !policy.isStatusRetryable(resp.StatusCode):
+			// The status code is not retryable, return the response
+			return resp, nil
+		default:
+			retryAfter = policy.retryAfter(resp)
+		}
+
+		if attempt == policy.MaxAttempts {
+			logger.Error(ctx, "Request failed, no attempts left", fields)
+
+			break
+		}
+
+		delay := policy.delay(attempt, retryAfter)
+		fields[common.LogFieldDelay] = delay
+		logger.Warn(ctx, "Retrying request", fields)
+		if err := common.Sleep(ctx, common.ClockOrReal(policy.Clock), delay); err != nil {
+			if resp != nil && resp.Body != nil {
+				resp.Body.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	// Attempts are exhausted, the last response is returned along with the error
+	return resp, errors.MakeErrInternalError(errors.ErrorResponse{
+		ErrorCode: "ErrGenerateTokenRetryLimitExceeded",
+		Message:   "Retry limit exceeded"})
+}
+
+// attemptFields returns the log fields for an attempt, req and resp may be nil
+func attemptFields(req *http.Request, resp *http.Response, attempt int) map[string]interface{} {
+	fields := map[string]interface{}{common.LogFieldAttempt: attempt}
+	if req != nil && req.URL != nil {
+		fields[common.LogFieldURLHost] = req.URL.Host
+	}
+	if resp != nil {
+		fields[common.LogFieldStatus] = resp.StatusCode
+	}
+
+	return fields
+}
+
+// attemptContext derives the context for an attempt from ctx
+func (p RetryPolicy) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.AttemptTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, p.AttemptTimeout)
+}
+
+// isStatusRetryable checks if statusCode is one of the policy RetryableStatusCodes
+func (p RetryPolicy) isStatusRetryable(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if statusCode == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delay returns the delay after attempt, retryAfter is used instead of the backoff delay if it is set
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if retryAfter > 0 {
+		delay = retryAfter
+	} else if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay)) //nolint:gosec
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// retryAfter returns the delay in the Retry-After header of resp, if the policy honours it.  The header
+// can be a number of seconds or an HTTP date.
+func (p RetryPolicy) retryAfter(resp *http.Response) time.Duration {
+	if !p.HonourRetryAfter || resp.Header == nil {
+		return 0
+	}
+
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return date.Sub(common.ClockOrReal(p.Clock).Now())
+	}
+
+	return 0
+}
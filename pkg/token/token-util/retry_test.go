@@ -0,0 +1,301 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokenutil
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokentest"
+)
+
+func testRetryPolicy() RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = 10 * time.Millisecond
+	p.AttemptTimeout = time.Second
+
+	return p
+}
+
+func TestDoRetriesWithPolicy(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name        string
+		statusCodes []int
+		expStatus   int
+		expAttempts int
+		hasError    bool
+	}{
+		{
+			name:        "503 then 200",
+			statusCodes: []int{http.StatusServiceUnavailable, http.StatusOK},
+			expStatus:   http.StatusOK,
+			expAttempts: 2,
+		},
+		{
+			name:        "504 then 200",
+			statusCodes: []int{http.StatusGatewayTimeout, http.StatusOK},
+			expStatus:   http.StatusOK,
+			expAttempts: 2,
+		},
+		{
+			name:        "non-retryable status",
+			statusCodes: []int{http.StatusForbidden},
+			expStatus:   http.StatusForbidden,
+			expAttempts: 1,
+		},
+		{
+			name: "attempts exhausted",
+			statusCodes: []int{
+				http.StatusTooManyRequests,
+				http.StatusTooManyRequests,
+				http.StatusTooManyRequests,
+				http.StatusTooManyRequests,
+			},
+			expAttempts: 4,
+			hasError:    true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			attempts := 0
+			call := func(ctx context.Context) (*http.Request, *http.Response, error) {
+				statusCode := tc.statusCodes[attempts]
+				attempts++
+
+				return nil, &http.Response{StatusCode: statusCode}, nil
+			}
+
+			cancelFuncs := make([]context.CancelFunc, 0)
+			resp, err := DoRetriesWithPolicy(context.Background(), &cancelFuncs, call, testRetryPolicy()) //nolint:bodyclose
+			assert.Equal(t, tc.expAttempts, attempts)
+			assert.Len(t, cancelFuncs, tc.expAttempts)
+			if tc.hasError {
+				assert.EqualError(t, err, errLimitExceeded.Error())
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestDoRetriesWithPolicyRetryAfter(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clock := tokentest.NewFakeClock(time.Now())
+	p := testRetryPolicy()
+	p.MaxDelay = 5 * time.Second
+	p.Clock = clock
+
+	attempts := make(chan struct{}, 2)
+	call := func(ctx context.Context) (*http.Request, *http.Response, error) {
+		attempts <- struct{}{}
+		if len(attempts) == 1 {
+			header := http.Header{}
+			header.Set("Retry-After", "3")
+
+			return nil, &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}, nil
+		}
+
+		return nil, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	done := make(chan *http.Response)
+	go func() {
+		cancelFuncs := make([]context.CancelFunc, 0)
+		resp, _ := DoRetriesWithPolicy(ctx, &cancelFuncs, call, p) //nolint:bodyclose
+		done <- resp
+	}()
+
+	// The retry waits for Retry-After rather than BaseDelay
+	require.NoError(t, clock.WaitForTimers(ctx, 1))
+	clock.Advance(3*time.Second - time.Millisecond)
+	assert.Len(t, attempts, 1)
+	clock.Advance(time.Millisecond)
+
+	resp := <-done
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, attempts, 2)
+}
+
+// recordingLogger records the messages and fields logged
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (r *recordingLogger) record(msg string, fields map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := map[string]interface{}{"msg": msg}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	r.entries = append(r.entries, entry)
+}
+
+func (r *recordingLogger) Debug(_ context.Context, msg string, fields map[string]interface{}) {
+	r.record(msg, fields)
+}
+
+func (r *recordingLogger) Info(_ context.Context, msg string, fields map[string]interface{}) {
+	r.record(msg, fields)
+}
+
+func (r *recordingLogger) Warn(_ context.Context, msg string, fields map[string]interface{}) {
+	r.record(msg, fields)
+}
+
+func (r *recordingLogger) Error(_ context.Context, msg string, fields map[string]interface{}) {
+	r.record(msg, fields)
+}
+
+func TestDoRetriesWithPolicyLogging(t *testing.T) {
+	t.Parallel()
+	logger := &recordingLogger{}
+	p := testRetryPolicy()
+	p.MaxAttempts = 2
+	p.Jitter = 0
+	p.Logger = logger
+
+	req, err := http.NewRequest(http.MethodPost, "https://iam.example.com/v1/token", nil) //nolint:noctx
+	require.NoError(t, err)
+	call := func(ctx context.Context) (*http.Request, *http.Response, error) {
+		return req, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	cancelFuncs := make([]context.CancelFunc, 0)
+	_, err = DoRetriesWithPolicy(context.Background(), &cancelFuncs, call, p) //nolint:bodyclose
+	require.Error(t, err)
+
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"msg":                  "Retrying request",
+			common.LogFieldAttempt: 1,
+			common.LogFieldURLHost: "iam.example.com",
+			common.LogFieldStatus:  http.StatusServiceUnavailable,
+			common.LogFieldDelay:   "1ms",
+		},
+		{
+			"msg":                  "Request failed, no attempts left",
+			common.LogFieldAttempt: 2,
+			common.LogFieldURLHost: "iam.example.com",
+			common.LogFieldStatus:  http.StatusServiceUnavailable,
+		},
+	}, logger.entries)
+}
+
+func TestDoRetriesWithPolicyContextCancelled(t *testing.T) {
+	t.Parallel()
+	p := testRetryPolicy()
+	p.BaseDelay = time.Minute
+	p.MaxDelay = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	call := func(ctx context.Context) (*http.Request, *http.Response, error) {
+		// Cancel the parent context, the wait before the next attempt must be abandoned
+		cancel()
+
+		return nil, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	start := time.Now()
+	cancelFuncs := make([]context.CancelFunc, 0)
+	_, err := DoRetriesWithPolicy(ctx, &cancelFuncs, call, p) //nolint:bodyclose
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	t.Parallel()
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	assert.Equal(t, time.Second, p.delay(1, 0))
+	assert.Equal(t, 2*time.Second, p.delay(2, 0))
+	assert.Equal(t, 4*time.Second, p.delay(3, 0))
+	assert.Equal(t, 5*time.Second, p.delay(4, 0))
+	assert.Equal(t, 3*time.Second, p.delay(1, 3*time.Second))
+	assert.Equal(t, 5*time.Second, p.delay(1, time.Minute))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := p.delay(2, 0)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	t.Parallel()
+	p := RetryPolicy{HonourRetryAfter: true}
+	resp := func(retryAfter string) *http.Response {
+		header := http.Header{}
+		header.Set("Retry-After", retryAfter)
+
+		return &http.Response{Header: header}
+	}
+
+	assert.Equal(t, 7*time.Second, p.retryAfter(resp("7")))
+	assert.Equal(t, time.Duration(0), p.retryAfter(resp("invalid")))
+	assert.Equal(t, time.Duration(0), p.retryAfter(&http.Response{}))
+
+	d := p.retryAfter(resp(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)))
+	assert.Greater(t, d, 50*time.Second)
+	assert.LessOrEqual(t, d, time.Minute)
+
+	p.HonourRetryAfter = false
+	assert.Equal(t, time.Duration(0), p.retryAfter(resp("7")))
+}
+
+func TestDoRetriesWithPolicyFakeClock(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clock := tokentest.NewFakeClock(time.Now())
+	p := DefaultRetryPolicy()
+	p.Jitter = 0
+	p.Clock = clock
+
+	attempts := make(chan struct{}, 4)
+	call := func(ctx context.Context) (*http.Request, *http.Response, error) {
+		attempts <- struct{}{}
+
+		return nil, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	done := make(chan error)
+	go func() {
+		cancelFuncs := make([]context.CancelFunc, 0)
+		_, err := DoRetriesWithPolicy(ctx, &cancelFuncs, call, p) //nolint:bodyclose
+		done <- err
+	}()
+
+	// The delays double from BaseDelay, the next attempt is made once each delay has passed
+	<-attempts
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		require.NoError(t, clock.WaitForTimers(ctx, 1))
+		clock.Advance(delay - time.Millisecond)
+		assert.Len(t, attempts, 0)
+		clock.Advance(time.Millisecond)
+		<-attempts
+	}
+
+	assert.EqualError(t, <-done, errLimitExceeded.Error())
+}
@@ -7,8 +7,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -34,33 +34,47 @@ type Token struct {
 	IsHPE            bool   `json:"isHPE"`
 }
 
+const (
+	// maxErrorBodySize is the maximum number of bytes read from an error response body
+	maxErrorBodySize = 64 * 1024
+	// maxErrorTextLength is the maximum length of a plain text explanation, longer ones are truncated
+	maxErrorTextLength = 512
+)
+
+// requestIDHeaders are the response headers that may carry the request ID, in order of preference
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "Request-Id"}
+
+// oauth2Error is the error response body defined in RFC 6749 section 5.2
+type oauth2Error struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
 //nolint:stylecheck,golint,revive
 type HttpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
 // DecodeAccessToken decodes the accessToken offline
+// An *errors.ErrMalformedJWT is returned if the token can't be parsed, and an *errors.ErrInvalidClaims
+// if the claims can't be decoded.  Note that the expiry is not checked, see DecodeAndValidateAccessToken.
 //
 //nolint:gocritic
 func DecodeAccessToken(rawToken string) (Token, error) {
 	_, err := jose.ParseSigned(rawToken)
 	if err != nil {
-		return Token{}, fmt.Errorf("oidc: malformed jwt: %w", err)
+		return Token{}, errors.MakeErrMalformedJWT(err)
 	}
 
 	// Throw out tokens with invalid claims before trying to verify the token. This lets
 	// us do cheap checks before possibly re-syncing keys.
 	payload, err := parseJWT(rawToken)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("oidc: malformed jwt: %v", err))
-
-		return Token{}, fmt.Errorf("oidc: malformed jwt: %w", err)
+		return Token{}, errors.MakeErrMalformedJWT(err)
 	}
 	var token Token
 	if err := json.Unmarshal(payload, &token); err != nil {
-		log.Fatalf(fmt.Sprintf("oidc: failed to unmarshal claims: %v", err))
-
-		return Token{}, fmt.Errorf("oidc: failed to unmarshal claims: %w", err)
+		return Token{}, errors.MakeErrInvalidClaims(fmt.Errorf("failed to unmarshal claims: %w", err))
 	}
 
 	if token.UserID != "" {
@@ -76,123 +90,185 @@ func DecodeAccessToken(rawToken string) (Token, error) {
 	return token, nil
 }
 
+// DecodeAndValidateAccessToken decodes the accessToken offline as DecodeAccessToken does, and checks that
+// it has an expiry and hasn't expired at time now.  An *errors.ErrInvalidClaims is returned if there is no
+// expiry, and an *errors.ErrExpiredToken if the token has expired.
+//
+//nolint:gocritic
+func DecodeAndValidateAccessToken(rawToken string, now time.Time) (Token, error) {
+	token, err := DecodeAccessToken(rawToken)
+	if err != nil {
+		return Token{}, err
+	}
+
+	if token.Expiry == 0 {
+		return Token{}, errors.MakeErrInvalidClaims(fmt.Errorf("missing exp claim"))
+	}
+
+	expiry := time.Unix(token.Expiry, 0)
+	if !now.Before(expiry) {
+		return Token{}, errors.MakeErrExpiredToken(expiry)
+	}
+
+	return token, nil
+}
+
+// DoRetries executes call, retrying up to retries attempts in total.  It uses the original fixed retry
+// policy: a 3s timeout per attempt, a 5s delay between attempts and only 429, 500 and 502 are retried.
+// New code should use DoRetriesWithPolicy.
 func DoRetries(
 	ctx context.Context,
 	cancelFuncs *[]context.CancelFunc,
 	call func(ctx context.Context) (*http.Request, *http.Response, error),
 	retries int,
 ) (*http.Response, error) {
-	var req *http.Request
-	var resp *http.Response
-	var err error
-
-	for {
-		// If retries are exhausted, return an error
-		if retries == 0 {
-			return resp, errors.MakeErrInternalError(errors.ErrorResponse{
-				ErrorCode: "ErrGenerateTokenRetryLimitExceeded",
-				Message:   "Retry limit exceeded"})
-		}
-
-		// Create a new context with a timeout
-		ctxWithTimeout, cancel := createContextWithTimeout(ctx)
-
-		// Add the cancel function to the list of cancel functions
-		*cancelFuncs = append(*cancelFuncs, cancel)
-
-		// Execute the request
-		req, resp, err = call(ctxWithTimeout)
+	return DoRetriesWithPolicy(ctx, cancelFuncs, call, legacyRetryPolicy(retries))
+}
 
-		// If the error is due to a context timeout, retry the request
-		if req != nil && req.Context().Err() == context.DeadlineExceeded {
-			retries = sleepAndDecrementRetries(retries)
+// ManageHTTPErrorCodes returns nil for a 2xx response, otherwise it returns the typed error for the status
+// of resp.  The body is decoded as an OAuth2 error, a GLCS ErrorResponse or plain text, and the explanation
+// is added to the error along with the HTTP status and request ID.  A JSON body that is neither is added
+// labelled as the response body.  An *errors.ErrInvalidGrant is returned
+// for an OAuth2 invalid_grant error so that a refresh_token grant can fall back to client_credentials.
+func ManageHTTPErrorCodes(resp *http.Response, clientID string) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
 
-			continue
-		}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return err
+	}
+	errorResponse, oauthCode := parseErrorBody(body)
+	explanation := errorResponse.Message
 
-		// For all other errors, return the error
-		if err != nil {
-			return resp, err
-		}
+	var httpErr interface {
+		error
+		SetHTTPDetails(statusCode int, requestID string)
+	}
 
-		// If the status code is not retryable, return the response
-		if !isStatusRetryable(resp.StatusCode) {
-			return resp, nil
+	switch {
+	case resp.StatusCode == http.StatusBadRequest && oauthCode == "invalid_grant":
+		httpErr = errors.MakeErrInvalidGrant(withMessage(errorResponse, "Bad request", explanation,
+			"ErrGenerateTokenInvalidGrant"))
+	case resp.StatusCode == http.StatusBadRequest:
+		httpErr = errors.MakeErrBadRequest(withMessage(errorResponse, "Bad request", explanation,
+			"ErrGenerateTokenBadRequest"))
+	case resp.StatusCode == http.StatusUnauthorized:
+		e := errors.MakeErrUnauthorized(clientID)
+		e.ErrorResponse = withMessage(errorResponse, e.Info, explanation, "")
+		httpErr = e
+	case resp.StatusCode == http.StatusForbidden:
+		var e *errors.ErrForbidden
+		if clientID != "" {
+			e = errors.MakeErrForbidden(clientID)
+		} else {
+			e = errors.MakeErrForbidden()
 		}
-
-		retries = sleepAndDecrementRetries(retries)
+		e.ErrorResponse = withMessage(errorResponse, e.Info, explanation, "")
+		httpErr = e
+	case resp.StatusCode == http.StatusNotFound:
+		httpErr = errors.MakeErrNotFound(withMessage(errorResponse, "Not found", explanation,
+			"ErrGenerateTokenNotFound"))
+	case resp.StatusCode == http.StatusConflict:
+		httpErr = errors.MakeErrConflict(withMessage(errorResponse, "Conflict", explanation,
+			"ErrGenerateTokenConflict"))
+	case resp.StatusCode == http.StatusTooManyRequests:
+		httpErr = errors.MakeErrTooManyRequests(withMessage(errorResponse, "Too many requests", explanation,
+			"ErrGenerateTokenTooManyRequests"))
+	case resp.StatusCode >= http.StatusInternalServerError:
+		httpErr = errors.MakeErrServerError(withMessage(errorResponse,
+			fmt.Sprintf("Server error %v", resp.StatusCode), explanation, "ErrGenerateTokenServerError"))
+	default:
+		httpErr = errors.MakeErrInternalError(withMessage(errorResponse,
+			fmt.Sprintf("Unexpected status code %v", resp.StatusCode), explanation,
+			"ErrGenerateTokenUnexpectedResponseCode"))
 	}
-}
 
-func createContextWithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
-	if ctx == nil {
-		return context.WithTimeout(context.Background(), 3*time.Second)
-	}
+	httpErr.SetHTTPDetails(resp.StatusCode, requestID(resp))
 
-	return context.WithTimeout(ctx, 3*time.Second)
+	return httpErr
 }
 
-func sleepAndDecrementRetries(retries int) int {
-	log.Printf("Retrying request, retries left: %v", retries)
-	time.Sleep(5 * time.Second)
-
-	return retries - 1
+// iamErrorBody is the union of the OAuth2 and GLCS error response bodies
+type iamErrorBody struct {
+	oauth2Error
+	errors.ErrorResponse
 }
 
-func ManageHTTPErrorCodes(resp *http.Response, clientID string) error {
-	var err error
+// parseErrorBody decodes body as an OAuth2 error, a GLCS ErrorResponse or plain text.  The returned
+// ErrorResponse has the explanation in Message, and the OAuth2 error code is returned if there is one.
+func parseErrorBody(body []byte) (errors.ErrorResponse, string) {
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		return errors.ErrorResponse{}, ""
+	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		return nil
-	case http.StatusBadRequest:
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	var parsed iamErrorBody
+	if json.Unmarshal(body, &parsed) == nil {
+		switch {
+		case parsed.oauth2Error.Error != "":
+			explanation := parsed.ErrorDescription
+			if explanation == "" {
+				explanation = parsed.oauth2Error.Error
+			}
+
+			return errors.ErrorResponse{Message: explanation, ErrorCode: parsed.oauth2Error.Error}, parsed.oauth2Error.Error
+		case parsed.Message != "" || parsed.ErrorCode != "":
+			return parsed.ErrorResponse, ""
+		default:
+			// JSON that we don't recognise isn't an explanation, so it is labelled as the body
+			return errors.ErrorResponse{Message: "unrecognised response body: " + truncate(text)}, ""
 		}
-		msg := fmt.Sprintf("Bad request: %v", string(body))
-		err = errors.MakeErrBadRequest(errors.ErrorResponse{
-			ErrorCode: "ErrGenerateTokenBadRequest",
-			Message:   msg,
-		})
+	}
 
-		return err
-	case http.StatusForbidden:
-		err = errors.MakeErrForbidden(clientID)
+	// Plain text, e.g. an HTML error page from a proxy
+	return errors.ErrorResponse{Message: truncate(text)}, ""
+}
 
-		return err
-	case http.StatusUnauthorized:
-		err = errors.MakeErrUnauthorized(clientID)
+// truncate returns text cut to maxErrorTextLength
+func truncate(text string) string {
+	if len(text) > maxErrorTextLength {
+		return text[:maxErrorTextLength] + "..."
+	}
 
-		return err
-	default:
-		msg := fmt.Sprintf("Unexpected status code %v", resp.StatusCode)
-		err = errors.MakeErrInternalError(errors.ErrorResponse{
-			ErrorCode: "ErrGenerateTokenUnexpectedResponseCode",
-			Message:   msg,
-		})
+	return text
+}
 
-		return err
+// withMessage returns r with its Message set to summary and the explanation, if any, and its ErrorCode set
+// to errorCode if IAM didn't report one
+func withMessage(r errors.ErrorResponse, summary, explanation, errorCode string) errors.ErrorResponse {
+	r.Message = summary
+	if explanation != "" {
+		r.Message += ": " + explanation
+	}
+
+	if r.ErrorCode == "" {
+		r.ErrorCode = errorCode
 	}
+
+	return r
 }
 
-func isStatusRetryable(statusCode int) bool {
-	if statusCode == http.StatusInternalServerError || statusCode == http.StatusTooManyRequests ||
-		statusCode == http.StatusBadGateway {
-		return true
+// requestID returns the request ID reported in the headers of resp, if any
+func requestID(resp *http.Response) string {
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			return id
+		}
 	}
 
-	return false
+	return ""
 }
 
 func parseJWT(p string) ([]byte, error) {
 	parts := strings.Split(p, ".")
 	if len(parts) < 2 {
-		return nil, fmt.Errorf("oidc: malformed jwt, expected 3 parts got %d", len(parts))
+		return nil, fmt.Errorf("expected 3 parts got %d", len(parts))
 	}
 	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return nil, fmt.Errorf("oidc: malformed jwt payload: %w", err)
+		return nil, fmt.Errorf("malformed jwt payload: %w", err)
 	}
 
 	return payload, nil
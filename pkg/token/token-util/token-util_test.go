@@ -4,8 +4,12 @@ package tokenutil
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	hpeglErrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokentest"
 )
 
 var errLimitExceeded = hpeglErrors.MakeErrInternalError(hpeglErrors.ErrorResponse{
@@ -147,6 +152,9 @@ func TestDoRetries(t *testing.T) {
 		call           func(ctx context.Context) (*http.Request, *http.Response, error)
 		responseStatus int
 		err            error
+		// viaDoRetries calls the exported DoRetries, on the real clock, rather than doRetriesFakeClock.  Only
+		// cases that don't wait between attempts can use it.
+		viaDoRetries bool
 	}{
 		{
 			name: "status 500",
@@ -186,19 +194,27 @@ func TestDoRetries(t *testing.T) {
 			},
 			responseStatus: http.StatusForbidden,
 		},
+		{
+			name: "status 403 no retry via DoRetries",
+			ctx:  context.Background(),
+			call: func(ctx context.Context) (*http.Request, *http.Response, error) {
+				return nil, &http.Response{StatusCode: http.StatusForbidden}, nil
+			},
+			responseStatus: http.StatusForbidden,
+			viaDoRetries:   true,
+		},
 		{
 			name: "Deadline exceeded",
 			ctx:  context.Background(),
 			call: func(ctx context.Context) (*http.Request, *http.Response, error) {
 				totalRetries++
+				// Simulate the attempt timing out, without waiting for the attempt timeout
+				deadlineCtx, cancel := context.WithDeadline(ctx, time.Time{})
+				defer cancel()
 				req := &http.Request{}
-				req = req.WithContext(ctx)
-				select {
-				case <-ctx.Done():
-					return req, nil, context.DeadlineExceeded
-				case <-time.After(5 * time.Second): // this is greater than the deadline
-					return req, nil, nil
-				}
+				req = req.WithContext(deadlineCtx)
+
+				return req, nil, context.DeadlineExceeded
 			},
 			err: errLimitExceeded,
 		},
@@ -210,13 +226,26 @@ func TestDoRetries(t *testing.T) {
 			},
 			err: errors.New("http: nil Request.URL"),
 		},
+		{
+			name: "no url via DoRetries",
+			ctx:  context.Background(),
+			call: func(ctx context.Context) (*http.Request, *http.Response, error) {
+				return nil, nil, errors.New("http: nil Request.URL")
+			},
+			err:          errors.New("http: nil Request.URL"),
+			viaDoRetries: true,
+		},
 	}
 
 	for _, testcase := range testcases {
 		tc := testcase
 		t.Run(tc.name, func(t *testing.T) {
 			cancelFuncs := make([]context.CancelFunc, 0)
-			resp, err := DoRetries(tc.ctx, &cancelFuncs, tc.call, 2) // nolint: bodyclose
+			doRetries := doRetriesFakeClock
+			if tc.viaDoRetries {
+				doRetries = DoRetries
+			}
+			resp, err := doRetries(tc.ctx, &cancelFuncs, tc.call, 2) // nolint: bodyclose
 			if tc.err != nil {
 				assert.EqualError(t, err, tc.err.Error())
 				if tc.err == errLimitExceeded {
@@ -246,3 +275,310 @@ func TestDoRetries(t *testing.T) {
 		})
 	}
 }
+
+// doRetriesFakeClock calls DoRetriesWithPolicy with the policy used by DoRetries on a fake clock, the clock
+// is moved on whenever DoRetriesWithPolicy is waiting between attempts
+func doRetriesFakeClock(
+	ctx context.Context,
+	cancelFuncs *[]context.CancelFunc,
+	call func(ctx context.Context) (*http.Request, *http.Response, error),
+	retries int,
+) (*http.Response, error) {
+	clock := tokentest.NewFakeClock(time.Now())
+	policy := legacyRetryPolicy(retries)
+	policy.Clock = clock
+
+	advanceCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		for clock.WaitForTimers(advanceCtx, 1) == nil {
+			clock.Advance(policy.MaxDelay)
+		}
+	}()
+
+	return DoRetriesWithPolicy(ctx, cancelFuncs, call, policy)
+}
+
+// testJWT builds a compact JWT from the payload passed-in, the signature is not valid
+func testJWT(payload string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	signature := base64.RawURLEncoding.EncodeToString([]byte("signature"))
+
+	return header + "." + payload + "." + signature
+}
+
+func TestDecodeAccessTokenErrors(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name     string
+		rawToken string
+		check    func(t *testing.T, err error)
+	}{
+		{
+			name:     "empty token",
+			rawToken: "",
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrMalformedJWT
+				assert.True(t, errors.As(err, &target))
+			},
+		},
+		{
+			name:     "truncated token",
+			rawToken: strings.Join(strings.Split(testJWT(base64.RawURLEncoding.EncodeToString([]byte(`{}`))), ".")[:2], "."),
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrMalformedJWT
+				assert.True(t, errors.As(err, &target))
+			},
+		},
+		{
+			name:     "non-base64 payload",
+			rawToken: testJWT("!!not-base64!!"),
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrMalformedJWT
+				assert.True(t, errors.As(err, &target))
+			},
+		},
+		{
+			name:     "non-JSON payload",
+			rawToken: testJWT(base64.RawURLEncoding.EncodeToString([]byte("not json"))),
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrInvalidClaims
+				assert.True(t, errors.As(err, &target))
+			},
+		},
+		{
+			name:     "wrong claim type",
+			rawToken: testJWT(base64.RawURLEncoding.EncodeToString([]byte(`{"exp":"tomorrow"}`))),
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrInvalidClaims
+				assert.True(t, errors.As(err, &target))
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// If DecodeAccessToken exits the process this test will fail to complete
+			got, err := DecodeAccessToken(tc.rawToken)
+			require.Error(t, err)
+			assert.Equal(t, Token{}, got)
+			tc.check(t, err)
+		})
+	}
+}
+
+func TestDecodeAndValidateAccessToken(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	testcases := []struct {
+		name     string
+		payload  string
+		check    func(t *testing.T, err error)
+		hasError bool
+	}{
+		{
+			name:    "valid token",
+			payload: fmt.Sprintf(`{"exp":%d}`, now.Add(time.Hour).Unix()),
+		},
+		{
+			name:    "expired token",
+			payload: fmt.Sprintf(`{"exp":%d}`, now.Add(-time.Hour).Unix()),
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrExpiredToken
+				require.True(t, errors.As(err, &target))
+				assert.Equal(t, now.Add(-time.Hour).Unix(), target.Expiry.Unix())
+			},
+			hasError: true,
+		},
+		{
+			name:    "no expiry",
+			payload: `{"sub":"subject"}`,
+			check: func(t *testing.T, err error) {
+				var target *hpeglErrors.ErrInvalidClaims
+				assert.True(t, errors.As(err, &target))
+			},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := DecodeAndValidateAccessToken(testJWT(base64.RawURLEncoding.EncodeToString([]byte(tc.payload))), now)
+			if tc.hasError {
+				require.Error(t, err)
+				tc.check(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestManageHTTPErrorCodes(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		name       string
+		statusCode int
+		body       string
+		requestID  string
+		noClientID bool
+		checkErr   func(t *testing.T, err error)
+	}{
+		{
+			name:       "status 200",
+			statusCode: http.StatusOK,
+			checkErr: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name:       "invalid grant",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":"invalid_grant","error_description":"refresh token expired"}`,
+			checkErr: func(t *testing.T, err error) {
+				var invalidGrant *hpeglErrors.ErrInvalidGrant
+				assert.ErrorAs(t, err, &invalidGrant)
+			},
+		},
+		{
+			name:       "bad request",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":"invalid_request"}`,
+			checkErr: func(t *testing.T, err error) {
+				var badRequest *hpeglErrors.ErrBadRequest
+				assert.ErrorAs(t, err, &badRequest)
+			},
+		},
+		{
+			name:       "bad request not json",
+			statusCode: http.StatusBadRequest,
+			body:       "bad request",
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "Bad request: bad request")
+			},
+		},
+		{
+			name:       "status 204",
+			statusCode: http.StatusNoContent,
+			checkErr: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name:       "unauthorized with an OAuth2 error",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"error":"invalid_client","error_description":"client secret has expired"}`,
+			requestID:  "req-1",
+			checkErr: func(t *testing.T, err error) {
+				var unauthorized *hpeglErrors.ErrUnauthorized
+				require.ErrorAs(t, err, &unauthorized)
+				assert.EqualError(t, err, "Unauthorized access: clientID: client secret has expired")
+				assert.Equal(t, "clientID", unauthorized.UnauthorizedReason)
+				assert.Equal(t, "invalid_client", unauthorized.ErrorResponse.ErrorCode)
+				assert.Equal(t, http.StatusUnauthorized, unauthorized.StatusCode)
+				assert.Equal(t, "req-1", unauthorized.RequestID)
+			},
+		},
+		{
+			name:       "unauthorized with an unrecognised JSON body",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"status":"denied"}`,
+			noClientID: true,
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, `Unauthorized access: unrecognised response body: {"status":"denied"}`)
+			},
+		},
+		{
+			name:       "forbidden without a body or client ID",
+			statusCode: http.StatusForbidden,
+			noClientID: true,
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "Forbidden")
+			},
+		},
+		{
+			name:       "forbidden without a body",
+			statusCode: http.StatusForbidden,
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, &hpeglErrors.ErrForbidden{})
+				assert.EqualError(t, err, "Forbidden: clientID")
+			},
+		},
+		{
+			name:       "not found with a GLCS ErrorResponse",
+			statusCode: http.StatusNotFound,
+			body: `{"message":"tenant not found","details":"tenant t1 doesn't exist",` +
+				`"recommendedActions":["check tenant_id"],"errorCode":"HPE_GL_IAM_NOT_FOUND"}`,
+			checkErr: func(t *testing.T, err error) {
+				var notFound *hpeglErrors.ErrNotFound
+				require.ErrorAs(t, err, &notFound)
+				assert.EqualError(t, err, "Not found: tenant not found")
+				assert.Equal(t, hpeglErrors.ErrorResponse{
+					Message:            "Not found: tenant not found",
+					Details:            "tenant t1 doesn't exist",
+					RecommendedActions: []string{"check tenant_id"},
+					ErrorCode:          "HPE_GL_IAM_NOT_FOUND",
+				}, notFound.ErrorResponse)
+			},
+		},
+		{
+			name:       "conflict",
+			statusCode: http.StatusConflict,
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, &hpeglErrors.ErrConflict{})
+				assert.EqualError(t, err, "Conflict")
+			},
+		},
+		{
+			name:       "too many requests",
+			statusCode: http.StatusTooManyRequests,
+			body:       "slow down",
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, &hpeglErrors.ErrTooManyRequests{})
+				assert.EqualError(t, err, "Too many requests: slow down")
+			},
+		},
+		{
+			name:       "server error",
+			statusCode: http.StatusServiceUnavailable,
+			body:       "<html>" + strings.Repeat("x", 1000) + "</html>",
+			checkErr: func(t *testing.T, err error) {
+				var serverError *hpeglErrors.ErrServerError
+				require.ErrorAs(t, err, &serverError)
+				assert.Equal(t, "ErrGenerateTokenServerError", serverError.ErrorResponse.ErrorCode)
+				assert.Len(t, err.Error(), len("Server error 503: ")+512+len("..."))
+
+				// 5xx errors were returned as ErrInternalError
+				assert.ErrorIs(t, err, &hpeglErrors.ErrInternalError{})
+			},
+		},
+		{
+			name:       "unexpected status",
+			statusCode: http.StatusTeapot,
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "Unexpected status code 418")
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resp := &http.Response{
+				StatusCode: tc.statusCode,
+				Header:     http.Header{"X-Request-Id": {tc.requestID}},
+				Body:       io.NopCloser(strings.NewReader(tc.body)),
+			}
+			clientID := "clientID"
+			if tc.noClientID {
+				clientID = ""
+			}
+			tc.checkErr(t, ManageHTTPErrorCodes(resp, clientID))
+		})
+	}
+}
@@ -0,0 +1,304 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokencache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/internal/filelock"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+const (
+	// dirPermissions are the permissions of the cache directory
+	dirPermissions = 0o700
+	// filePermissions are the permissions of the cache entries
+	filePermissions = 0o600
+	// defaultMinTTL is the default minimum remaining lifetime of a token served from the cache
+	defaultMinTTL = 5 * time.Minute
+	// lockTimeout is how long to wait for the lock on an entry
+	lockTimeout = 5 * time.Second
+	// keyInfo is mixed into the derivation of the encryption key
+	keyInfo = "hpegl-provider-lib token cache v1"
+)
+
+// ErrNoSecret is returned by Get and Put when there is no secret to derive the encryption key from
+var ErrNoSecret = errors.New("token cache: a client secret is needed to encrypt the cache")
+
+// Key identifies the token of an API client
+type Key struct {
+	IAMServiceURL string
+	IAMVersion    string
+	TenantID      string
+	ClientID      string
+}
+
+// hash returns the hex-encoded SHA-256 hash of k, it is the name of the cache entry
+func (k Key) hash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{k.IAMServiceURL, k.IAMVersion, k.TenantID, k.ClientID}, "\x00")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache is an on-disk token cache that is shared by the provider processes that Terraform starts during a
+// run, so that each process doesn't have to generate its own token.  Entries are encrypted with a key derived
+// from the client secret, so a token can only be read by a process that could have generated it, and are
+// file-locked and only readable by the current user.
+type Cache struct {
+	dir    string
+	minTTL time.Duration
+	clock  common.Clock
+}
+
+// CreateOpt - function option definition
+type CreateOpt func(c *Cache)
+
+// WithMinTTL set the minimum remaining lifetime of a token served from the cache, the default is 5 minutes
+func WithMinTTL(d time.Duration) CreateOpt {
+	return func(c *Cache) {
+		c.minTTL = d
+	}
+}
+
+// WithClock set the common.Clock used to check token expiry, the default is common.RealClock()
+func WithClock(clock common.Clock) CreateOpt {
+	return func(c *Cache) {
+		c.clock = clock
+	}
+}
+
+// DefaultDir returns the default cache directory, hpegl/tokens in the user's cache directory
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("token cache: %w", err)
+	}
+
+	return filepath.Join(dir, "hpegl", "tokens"), nil
+}
+
+// New creates a Cache in dir, the directory is created if necessary.  If dir is empty DefaultDir is used.
+func New(dir string, opts ...CreateOpt) (*Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, dirPermissions); err != nil {
+		return nil, fmt.Errorf("token cache: error creating %s: %w", dir, err)
+	}
+
+	c := &Cache{dir: dir, minTTL: defaultMinTTL, clock: common.RealClock()}
+
+	// run overrides
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+
+	return c, nil
+}
+
+// entry is the plaintext of a cache entry
+type entry struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// Get returns the cached token for key, if there is one that has at least the minimum TTL left.  Expired
+// entries, and entries that can't be decrypted with secret, are removed.
+func (c *Cache) Get(key Key, secret string) (common.AccessToken, bool, error) {
+	if secret == "" {
+		return common.AccessToken{}, false, ErrNoSecret
+	}
+
+	var token common.AccessToken
+	var found bool
+	err := c.withLock(key, func(path string) error {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e, err := decrypt(data, key, secret)
+		if err != nil || !e.Expiry.After(c.clock.Now()) {
+			// The entry is unreadable, e.g. the secret has been rotated, or has expired
+			return removeIfExists(path)
+		}
+
+		if e.Expiry.Sub(c.clock.Now()) < c.minTTL {
+			return nil
+		}
+
+		token = common.AccessToken{Value: e.AccessToken, TokenType: e.TokenType, Scope: e.Scope, Expiry: e.Expiry}
+		found = true
+
+		return nil
+	})
+
+	return token, found, err
+}
+
+// Put stores token for key, encrypted with a key derived from secret.  Tokens without an expiry aren't
+// cached, nor are refresh tokens.
+func (c *Cache) Put(key Key, secret string, token common.AccessToken) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+
+	if token.Value == "" || token.Expiry.IsZero() {
+		return nil
+	}
+
+	data, err := encrypt(entry{
+		AccessToken: token.Value,
+		TokenType:   token.TokenType,
+		Scope:       token.Scope,
+		Expiry:      token.Expiry,
+	}, key, secret)
+	if err != nil {
+		return err
+	}
+
+	return c.withLock(key, func(path string) error {
+		return writeFile(path, data)
+	})
+}
+
+// Delete removes the cached token for key, e.g. because IAM has rejected it
+func (c *Cache) Delete(key Key) error {
+	return c.withLock(key, removeIfExists)
+}
+
+// withLock calls f with the path of the entry for key while holding the lock on it
+func (c *Cache) withLock(key Key, f func(path string) error) error {
+	path := filepath.Join(c.dir, key.hash())
+
+	unlock, err := filelock.Lock(path+".lock", lockTimeout)
+	if err != nil {
+		return fmt.Errorf("token cache: %w", err)
+	}
+	defer unlock()
+
+	return f(path)
+}
+
+// writeFile writes data to path atomically, through a temporary file that is renamed
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("token cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(filePermissions); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("token cache: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("token cache: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("token cache: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("token cache: %w", err)
+	}
+
+	return nil
+}
+
+// removeIfExists removes path, it isn't an error if it doesn't exist
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("token cache: %w", err)
+	}
+
+	return nil
+}
+
+// newAEAD returns the AES-256-GCM cipher for key, with an encryption key derived from secret
+func newAEAD(key Key, secret string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(keyInfo))
+	mac.Write([]byte(key.hash()))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the nonce followed by the sealed JSON of e, the entry name is authenticated too so that
+// entries can't be swapped
+func encrypt(e entry, key Key, secret string) ([]byte, error) {
+	aead, err := newAEAD(key, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, []byte(key.hash())), nil
+}
+
+// decrypt opens data, see encrypt
+func decrypt(data []byte, key Key, secret string) (entry, error) {
+	aead, err := newAEAD(key, secret)
+	if err != nil {
+		return entry{}, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return entry{}, errors.New("token cache: entry is too short")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key.hash()))
+	if err != nil {
+		return entry{}, err
+	}
+
+	var e entry
+	if err := json.Unmarshal(plaintext, &e); err != nil {
+		return entry{}, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,173 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokencache
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/tokentest"
+)
+
+var testKey = Key{
+	IAMServiceURL: "https://iam.example.com",
+	IAMVersion:    "glcs",
+	TenantID:      "tenantID",
+	ClientID:      "clientID",
+}
+
+func TestGetPut(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	testcases := []struct {
+		name     string
+		token    common.AccessToken
+		key      Key
+		secret   string
+		expFound bool
+		expFile  bool
+	}{
+		{
+			name:     "cached",
+			token:    common.AccessToken{Value: "token", TokenType: "Bearer", Expiry: now.Add(time.Hour)},
+			key:      testKey,
+			secret:   "secret",
+			expFound: true,
+			expFile:  true,
+		},
+		{
+			name:    "less than the minimum TTL left",
+			token:   common.AccessToken{Value: "token", Expiry: now.Add(time.Minute)},
+			key:     testKey,
+			secret:  "secret",
+			expFile: true,
+		},
+		{
+			name:   "expired",
+			token:  common.AccessToken{Value: "token", Expiry: now.Add(-time.Minute)},
+			key:    testKey,
+			secret: "secret",
+		},
+		{
+			name:   "different secret",
+			token:  common.AccessToken{Value: "token", Expiry: now.Add(time.Hour)},
+			key:    testKey,
+			secret: "rotated",
+		},
+		{
+			name:    "different client",
+			token:   common.AccessToken{Value: "token", Expiry: now.Add(time.Hour)},
+			key:     Key{IAMServiceURL: testKey.IAMServiceURL, IAMVersion: "glcs", TenantID: "tenantID", ClientID: "other"},
+			secret:  "secret",
+			expFile: true,
+		},
+		{
+			name:   "no expiry",
+			token:  common.AccessToken{Value: "token"},
+			key:    testKey,
+			secret: "secret",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := New(t.TempDir(), WithClock(tokentest.NewFakeClock(now)))
+			require.NoError(t, err)
+
+			require.NoError(t, c.Put(testKey, "secret", tc.token))
+			token, found, err := c.Get(tc.key, tc.secret)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expFound, found)
+			if tc.expFound {
+				assert.Equal(t, tc.token.Value, token.Value)
+				assert.Equal(t, tc.token.TokenType, token.TokenType)
+				assert.True(t, tc.token.Expiry.Equal(token.Expiry))
+			}
+
+			// Expired and undecryptable entries are removed
+			_, err = os.Stat(filepath.Join(c.dir, testKey.hash()))
+			assert.Equal(t, tc.expFile, err == nil)
+		})
+	}
+}
+
+func TestEntryFile(t *testing.T) {
+	t.Parallel()
+	dir := filepath.Join(t.TempDir(), "tokens")
+	c, err := New(dir)
+	require.NoError(t, err)
+	require.NoError(t, c.Put(testKey, "secret", common.AccessToken{Value: "access-token", Expiry: time.Now().Add(time.Hour)}))
+
+	path := filepath.Join(dir, testKey.hash())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "access-token")
+
+	// Only the entry and its lock file are left, the temporary files are removed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(filePermissions), info.Mode().Perm())
+
+		info, err = os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(dirPermissions), info.Mode().Perm())
+	}
+
+	require.NoError(t, c.Delete(testKey))
+	_, found, err := c.Get(testKey, "secret")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = c.Get(testKey, "")
+	assert.ErrorIs(t, err, ErrNoSecret)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	// Caches in the same directory stand in for provider processes
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := New(dir)
+			assert.NoError(t, err)
+			assert.NoError(t, c.Put(testKey, "secret", common.AccessToken{Value: "token", Expiry: time.Now().Add(time.Hour)}))
+
+			token, found, err := c.Get(testKey, "secret")
+			assert.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, "token", token.Value)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStaleLock(t *testing.T) {
+	t.Parallel()
+	c, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	// A lock file left by a process that died isn't locked
+	lockPath := filepath.Join(c.dir, testKey.hash()+".lock")
+	require.NoError(t, os.WriteFile(lockPath, nil, filePermissions))
+
+	require.NoError(t, c.Put(testKey, "secret", common.AccessToken{Value: "token", Expiry: time.Now().Add(time.Hour)}))
+}
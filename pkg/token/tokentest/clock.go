@@ -0,0 +1,153 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokentest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+// Assert that FakeClock implements common.Clock
+var _ common.Clock = (*FakeClock)(nil)
+
+// FakeClock is a common.Clock whose time only moves when Advance or Set is called, timers fire when the
+// time is moved past their deadline.  It is safe for concurrent use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// changed is closed and replaced whenever a timer is created, for WaitForTimers
+	changed chan struct{}
+}
+
+// NewFakeClock creates a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer creates a Timer that fires once the fake time has been moved on by d
+func (c *FakeClock) NewTimer(d time.Duration) common.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, deadline: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.fire(c.now)
+	} else {
+		c.timers = append(c.timers, t)
+	}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return t
+}
+
+// Advance moves the fake time on by d, firing any timers that are due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(c.now.Add(d))
+}
+
+// Set sets the fake time to now, firing any timers that are due
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(now)
+}
+
+// Timers returns the number of timers that are waiting to fire
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// WaitForTimers waits until at least n timers are waiting to fire, so that a test can be sure that the code
+// under test is waiting on the clock before calling Advance.  ctx.Err() is returned if ctx is done first.
+func (c *FakeClock) WaitForTimers(ctx context.Context, n int) error {
+	for {
+		c.mu.Lock()
+		waiting, changed := len(c.timers), c.changed
+		c.mu.Unlock()
+
+		if waiting >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// setLocked sets the time and fires the timers that are due, c.mu must be held
+func (c *FakeClock) setLocked(now time.Time) {
+	c.now = now
+
+	waiting := c.timers[:0]
+	for _, t := range c.timers {
+		if now.Before(t.deadline) {
+			waiting = append(waiting, t)
+		} else {
+			t.fire(now)
+		}
+	}
+	c.timers = waiting
+}
+
+// removeLocked removes t from the waiting timers, it returns false if t wasn't waiting.  c.mu must be held.
+func (c *FakeClock) removeLocked(t *fakeTimer) bool {
+	for i, waiting := range c.timers {
+		if waiting == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// fakeTimer is the common.Timer created by FakeClock
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.removeLocked(t)
+}
+
+// fire sends now on the timer channel, the channel is buffered so this doesn't block
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package tokentest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	assert.Equal(t, start, c.Now())
+
+	short := c.NewTimer(time.Second)
+	long := c.NewTimer(time.Minute)
+	stopped := c.NewTimer(time.Second)
+	assert.Equal(t, 3, c.Timers())
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), c.Now())
+	assert.Equal(t, start.Add(time.Second), <-short.C())
+	assert.False(t, short.Stop())
+	assert.Len(t, long.C(), 0)
+	assert.Len(t, stopped.C(), 0)
+	assert.Equal(t, 1, c.Timers())
+
+	c.Set(start.Add(time.Hour))
+	assert.Equal(t, start.Add(time.Hour), <-long.C())
+	assert.Equal(t, 0, c.Timers())
+
+	// A timer with no duration fires straight away
+	assert.Len(t, c.NewTimer(0).C(), 1)
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := NewFakeClock(time.Now())
+
+	done := make(chan error)
+	go func() {
+		done <- common.Sleep(ctx, c, time.Hour)
+	}()
+
+	require.NoError(t, c.WaitForTimers(ctx, 1))
+	c.Advance(time.Hour)
+	assert.NoError(t, <-done)
+
+	// Sleep returns early if the context is done
+	cancelled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	assert.ErrorIs(t, common.Sleep(cancelled, c, time.Hour), context.Canceled)
+	assert.Equal(t, 0, c.Timers())
+}
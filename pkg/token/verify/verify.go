@@ -0,0 +1,299 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+	tokenutil "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/token-util"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Verifier verifies JWTs against the JWKS of the issuer.  The OIDC discovery document and JWKS are
+// fetched on first use for each issuer and cached.  The JWKS is re-fetched if a token is signed with
+// a key that isn't in the cached JWKS, so that key rotation is picked up.
+type Verifier struct {
+	issuer        string
+	defaultIssuer string
+	audience      string
+	leeway        time.Duration
+	httpClient    tokenutil.HttpClient
+	clock         common.Clock
+	mu            sync.Mutex
+	keySets       map[string]*keySet
+}
+
+// keySet is the cached JWKS for an issuer
+type keySet struct {
+	jwksURL string
+	keys    jose.JSONWebKeySet
+}
+
+// discoveryDocument holds the fields that we need from the OIDC discovery document
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURL string `json:"jwks_uri"`
+}
+
+// CreateOpt - function option definition
+type CreateOpt func(v *Verifier)
+
+// WithIssuer set the issuer that tokens must be issued by
+func WithIssuer(issuer string) CreateOpt {
+	return func(v *Verifier) {
+		v.issuer = strings.TrimRight(issuer, "/")
+	}
+}
+
+// WithDefaultIssuer set the issuer used to locate the JWKS for tokens that don't have an iss claim
+func WithDefaultIssuer(issuer string) CreateOpt {
+	return func(v *Verifier) {
+		v.defaultIssuer = strings.TrimRight(issuer, "/")
+	}
+}
+
+// WithAudience set the audience that tokens must be issued for
+func WithAudience(audience string) CreateOpt {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway set the leeway allowed when checking the exp, nbf and iat claims
+func WithLeeway(leeway time.Duration) CreateOpt {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+// WithHTTPClient override the client used to fetch the discovery document and JWKS
+func WithHTTPClient(httpClient tokenutil.HttpClient) CreateOpt {
+	return func(v *Verifier) {
+		v.httpClient = httpClient
+	}
+}
+
+// WithClock set the common.Clock used to check the exp, nbf and iat claims
+func WithClock(c common.Clock) CreateOpt {
+	return func(v *Verifier) {
+		v.clock = c
+	}
+}
+
+// New creates a new Verifier
+func New(opts ...CreateOpt) *Verifier {
+	v := &Verifier{
+		leeway:     jwt.DefaultLeeway,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		clock:      common.RealClock(),
+		keySets:    make(map[string]*keySet),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(v)
+		}
+	}
+
+	return v
+}
+
+// Verify verifies the signature of rawToken against the JWKS of its issuer, and validates the exp, nbf,
+// iat, iss and aud claims.  Without WithIssuer the issuer is taken from the unverified iss claim, so any
+// issuer is trusted, set WithIssuer to verify tokens that can come from anyone.  The decoded token is returned.  An *errors.ErrMalformedJWT is returned if the
+// token can't be parsed, an *errors.ErrInvalidSignature if the signature can't be verified, an
+// *errors.ErrExpiredToken if the token has expired and an *errors.ErrInvalidClaims for any other claim
+// that isn't valid.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (tokenutil.Token, error) {
+	tok, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return tokenutil.Token{}, errors.MakeErrMalformedJWT(err)
+	}
+
+	// Get the claims without verification so that we know which issuer to get keys from
+	var claims jwt.Claims
+	if err = tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return tokenutil.Token{}, errors.MakeErrInvalidClaims(err)
+	}
+
+	issuer, err := v.issuerFor(claims)
+	if err != nil {
+		return tokenutil.Token{}, err
+	}
+
+	if err = v.verifySignature(ctx, tok, issuer); err != nil {
+		return tokenutil.Token{}, err
+	}
+
+	if err = v.validateClaims(claims); err != nil {
+		return tokenutil.Token{}, err
+	}
+
+	return tokenutil.DecodeAccessToken(rawToken)
+}
+
+// issuerFor returns the issuer to get the JWKS from for a token with claims, and checks the iss claim
+// against the expected issuer if one is set
+func (v *Verifier) issuerFor(claims jwt.Claims) (string, error) {
+	issuer := strings.TrimRight(claims.Issuer, "/")
+	switch {
+	case v.issuer != "" && issuer != "" && issuer != v.issuer:
+		return "", errors.MakeErrInvalidClaims(fmt.Errorf("issuer %s does not match expected issuer %s", issuer, v.issuer))
+	case v.issuer != "":
+		return v.issuer, nil
+	case issuer != "":
+		return issuer, nil
+	case v.defaultIssuer != "":
+		return v.defaultIssuer, nil
+	default:
+		return "", errors.MakeErrInvalidClaims(fmt.Errorf("missing iss claim"))
+	}
+}
+
+// validateClaims validates the exp, nbf, iat and aud claims
+func (v *Verifier) validateClaims(claims jwt.Claims) error {
+	if claims.Expiry == nil {
+		return errors.MakeErrInvalidClaims(fmt.Errorf("missing exp claim"))
+	}
+
+	// The iss claim has already been checked by issuerFor
+	expected := jwt.Expected{Time: v.clock.Now()}
+	if v.audience != "" {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+
+	err := claims.ValidateWithLeeway(expected, v.leeway)
+	switch err {
+	case nil:
+		return nil
+	case jwt.ErrExpired:
+		return errors.MakeErrExpiredToken(claims.Expiry.Time())
+	default:
+		return errors.MakeErrInvalidClaims(err)
+	}
+}
+
+// verifySignature verifies the signature of tok with the JWKS of issuer.  If the key that tok is signed
+// with isn't in the cached JWKS then the JWKS is re-fetched once.
+func (v *Verifier) verifySignature(ctx context.Context, tok *jwt.JSONWebToken, issuer string) error {
+	if len(tok.Headers) != 1 {
+		return errors.MakeErrMalformedJWT(fmt.Errorf("expected 1 signature got %d", len(tok.Headers)))
+	}
+	kid := tok.Headers[0].KeyID
+
+	ks, err := v.getKeySet(ctx, issuer, false)
+	if err != nil {
+		return err
+	}
+
+	keys := ks.lookup(kid)
+	if len(keys) == 0 {
+		// The issuer may have rotated its keys
+		ks, err = v.getKeySet(ctx, issuer, true)
+		if err != nil {
+			return err
+		}
+		keys = ks.lookup(kid)
+	}
+
+	if len(keys) == 0 {
+		return errors.MakeErrInvalidSignature(fmt.Errorf("no key found for kid %q", kid))
+	}
+
+	for _, key := range keys {
+		var out interface{}
+		if err = tok.Claims(key.Key, &out); err == nil {
+			return nil
+		}
+	}
+
+	return errors.MakeErrInvalidSignature(err)
+}
+
+// lookup returns the keys matching kid, or all keys if kid is empty
+func (ks *keySet) lookup(kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return ks.keys.Keys
+	}
+
+	return ks.keys.Key(kid)
+}
+
+// getKeySet returns the cached JWKS for issuer, fetching it if necessary or if refresh is true
+func (v *Verifier) getKeySet(ctx context.Context, issuer string, refresh bool) (*keySet, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ks, ok := v.keySets[issuer]
+	if ok && !refresh {
+		return ks, nil
+	}
+
+	jwksURL := ""
+	if ok {
+		jwksURL = ks.jwksURL
+	} else {
+		var doc discoveryDocument
+		if err := v.getJSON(ctx, issuer+discoveryPath, &doc); err != nil {
+			return nil, fmt.Errorf("error fetching discovery document for issuer %s: %w", issuer, err)
+		}
+
+		if strings.TrimRight(doc.Issuer, "/") != issuer {
+			return nil, fmt.Errorf("discovery document issuer %s does not match issuer %s", doc.Issuer, issuer)
+		}
+
+		if doc.JWKSURL == "" {
+			return nil, fmt.Errorf("discovery document for issuer %s has no jwks_uri", issuer)
+		}
+		jwksURL = doc.JWKSURL
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := v.getJSON(ctx, jwksURL, &keys); err != nil {
+		return nil, fmt.Errorf("error fetching JWKS for issuer %s: %w", issuer, err)
+	}
+
+	ks = &keySet{jwksURL: jwksURL, keys: keys}
+	v.keySets[issuer] = ks
+
+	return ks, nil
+}
+
+// getJSON gets url and decodes the JSON response body into out
+func (v *Verifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+
+	return json.Unmarshal(body, out)
+}
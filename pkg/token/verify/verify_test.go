@@ -0,0 +1,307 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package verify
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	hpeglErrors "github.com/hewlettpackard/hpegl-provider-lib/pkg/token/errors"
+)
+
+// testIAM is an httptest stand-in for IAM that serves a discovery document and JWKS
+type testIAM struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	keys      []jose.JSONWebKey
+	jwksCalls int
+}
+
+func newTestIAM(t *testing.T) *testIAM {
+	t.Helper()
+	iam := &testIAM{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:  iam.server.URL,
+			JWKSURL: iam.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		iam.mu.Lock()
+		defer iam.mu.Unlock()
+		iam.jwksCalls++
+
+		keySet := jose.JSONWebKeySet{}
+		for _, k := range iam.keys {
+			keySet.Keys = append(keySet.Keys, k.Public())
+		}
+		_ = json.NewEncoder(w).Encode(keySet)
+	})
+	iam.server = httptest.NewServer(mux)
+	t.Cleanup(iam.server.Close)
+
+	return iam
+}
+
+// addKey generates a new signing key with kid and adds it to the JWKS
+func (i *testIAM) addKey(t *testing.T, kid string) jose.JSONWebKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key := jose.JSONWebKey{Key: privateKey, KeyID: kid, Algorithm: string(jose.RS256), Use: "sig"}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = append(i.keys, key)
+
+	return key
+}
+
+func signToken(t *testing.T, key jose.JSONWebKey, claims interface{}) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+	require.NoError(t, err)
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	key := iam.addKey(t, "key-1")
+	now := time.Now()
+
+	// otherKey has the same kid as key but isn't in the JWKS
+	otherKey := key
+	otherPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey.Key = otherPrivateKey
+
+	validClaims := func() jwt.Claims {
+		return jwt.Claims{
+			Issuer:   iam.server.URL,
+			Subject:  "subject",
+			Audience: jwt.Audience{"hpegl"},
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt: jwt.NewNumericDate(now),
+		}
+	}
+
+	testcases := []struct {
+		name   string
+		token  func() string
+		opts   []CreateOpt
+		target interface{}
+	}{
+		{
+			name: "valid token",
+			token: func() string {
+				return signToken(t, key, validClaims())
+			},
+			opts: []CreateOpt{WithAudience("hpegl")},
+		},
+		{
+			name: "valid token with expected issuer",
+			token: func() string {
+				return signToken(t, key, validClaims())
+			},
+			opts: []CreateOpt{WithIssuer(iam.server.URL + "/")},
+		},
+		{
+			name: "no iss claim with default issuer",
+			token: func() string {
+				c := validClaims()
+				c.Issuer = ""
+
+				return signToken(t, key, c)
+			},
+			opts: []CreateOpt{WithDefaultIssuer(iam.server.URL)},
+		},
+		{
+			name: "no iss claim",
+			token: func() string {
+				c := validClaims()
+				c.Issuer = ""
+
+				return signToken(t, key, c)
+			},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				return signToken(t, key, validClaims())
+			},
+			opts:   []CreateOpt{WithIssuer("https://issuer.example.com")},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				return signToken(t, key, validClaims())
+			},
+			opts:   []CreateOpt{WithAudience("other")},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "expired",
+			token: func() string {
+				c := validClaims()
+				c.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+
+				return signToken(t, key, c)
+			},
+			target: new(*hpeglErrors.ErrExpiredToken),
+		},
+		{
+			name: "no exp claim",
+			token: func() string {
+				c := validClaims()
+				c.Expiry = nil
+
+				return signToken(t, key, c)
+			},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "not valid yet",
+			token: func() string {
+				c := validClaims()
+				c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+
+				return signToken(t, key, c)
+			},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				c := validClaims()
+				c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour))
+
+				return signToken(t, key, c)
+			},
+			target: new(*hpeglErrors.ErrInvalidClaims),
+		},
+		{
+			name: "bad signature",
+			token: func() string {
+				return signToken(t, otherKey, validClaims())
+			},
+			target: new(*hpeglErrors.ErrInvalidSignature),
+		},
+		{
+			name: "malformed",
+			token: func() string {
+				return "not-a-jwt"
+			},
+			target: new(*hpeglErrors.ErrMalformedJWT),
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			v := New(tc.opts...)
+			token, err := v.Verify(context.Background(), tc.token())
+			if tc.target == nil {
+				require.NoError(t, err)
+				assert.Equal(t, now.Add(time.Hour).Unix(), token.Expiry)
+			} else {
+				require.Error(t, err)
+				assert.True(t, errors.As(err, tc.target), "unexpected error type %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	key1 := iam.addKey(t, "key-1")
+	claims := jwt.Claims{Issuer: iam.server.URL, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	v := New()
+	_, err := v.Verify(context.Background(), signToken(t, key1, claims))
+	require.NoError(t, err)
+
+	// The JWKS is cached
+	_, err = v.Verify(context.Background(), signToken(t, key1, claims))
+	require.NoError(t, err)
+	assert.Equal(t, 1, iam.jwksCalls)
+
+	// Rotate keys, the JWKS is re-fetched for the unknown kid
+	key2 := iam.addKey(t, "key-2")
+	_, err = v.Verify(context.Background(), signToken(t, key2, claims))
+	require.NoError(t, err)
+	assert.Equal(t, 2, iam.jwksCalls)
+
+	// A kid that isn't in the re-fetched JWKS either
+	unknown := key2
+	unknown.KeyID = "key-3"
+	_, err = v.Verify(context.Background(), signToken(t, unknown, claims))
+	var target *hpeglErrors.ErrInvalidSignature
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, 3, iam.jwksCalls)
+}
+
+func TestVerifyDiscoveryError(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	iam := newTestIAM(t)
+	key := iam.addKey(t, "key-1")
+	claims := jwt.Claims{Issuer: server.URL, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	_, err := New().Verify(context.Background(), signToken(t, key, claims))
+	assert.ErrorContains(t, err, "error fetching discovery document")
+}
+
+func TestVerifySecondIssuer(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	iam.addKey(t, "key-1")
+
+	// other is a second issuer, e.g. one run by an attacker, with its own discovery document and JWKS
+	other := newTestIAM(t)
+	otherKey := other.addKey(t, "key-1")
+	claims := jwt.Claims{Issuer: other.server.URL, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	// The token verifies if any issuer is trusted
+	_, err := New().Verify(context.Background(), signToken(t, otherKey, claims))
+	require.NoError(t, err)
+
+	// The token is rejected with the issuer pinned, without fetching the keys of the second issuer
+	other.mu.Lock()
+	other.jwksCalls = 0
+	other.mu.Unlock()
+	_, err = New(WithIssuer(iam.server.URL)).Verify(context.Background(), signToken(t, otherKey, claims))
+	var target *hpeglErrors.ErrInvalidClaims
+	assert.True(t, errors.As(err, &target), "unexpected error type %T: %v", err, err)
+	assert.Equal(t, 0, other.jwksCalls)
+
+	// A token signed by the second issuer but naming the pinned issuer fails the signature check
+	claims.Issuer = iam.server.URL
+	_, err = New(WithIssuer(iam.server.URL)).Verify(context.Background(), signToken(t, otherKey, claims))
+	var signatureTarget *hpeglErrors.ErrInvalidSignature
+	assert.True(t, errors.As(err, &signatureTarget), "unexpected error type %T: %v", err, err)
+}
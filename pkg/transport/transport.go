@@ -0,0 +1,208 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+const (
+	// TLSVersion12 is the tls_min_version value for TLS 1.2, the default
+	TLSVersion12 = "1.2"
+	// TLSVersion13 is the tls_min_version value for TLS 1.3
+	TLSVersion13 = "1.3"
+)
+
+// tlsVersions maps tls_min_version values to crypto/tls versions
+var tlsVersions = map[string]uint16{
+	TLSVersion12: tls.VersionTLS12,
+	TLSVersion13: tls.VersionTLS13,
+}
+
+// TLSVersions returns the supported tls_min_version values
+func TLSVersions() []string {
+	return []string{TLSVersion12, TLSVersion13}
+}
+
+// Config is the HTTP transport configuration taken from the provider fields.  The zero value gives a
+// transport that behaves like http.DefaultTransport, but with a minimum TLS version of 1.2.
+type Config struct {
+	// CABundle is the path of a PEM file of CA certificates that are trusted as well as the system roots
+	CABundle string
+	// Proxy is the URL of the proxy used for all requests, if it is empty the HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY env-vars are used
+	Proxy string
+	// InsecureSkipVerify disables verification of server certificates, it must only be used for testing
+	InsecureSkipVerify bool
+	// MinTLSVersion is the minimum TLS version, one of TLSVersions
+	MinTLSVersion string
+}
+
+// options are the options for creating a transport
+type options struct {
+	ctx    context.Context
+	logger common.Logger
+}
+
+// Opt - function option definition for Shared and New
+type Opt func(o *options)
+
+// WithLogger set the common.Logger used to warn that certificate verification is disabled, the default is
+// common.TFLogger()
+func WithLogger(l common.Logger) Opt {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithContext set the context passed to the Logger
+func WithContext(ctx context.Context) Opt {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// newOptions returns the options with opts applied
+func newOptions(opts []Opt) options {
+	o := options{ctx: context.Background()}
+
+	// run overrides
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	o.logger = common.LoggerOrDefault(o.logger)
+
+	return o
+}
+
+var (
+	sharedMu sync.Mutex
+	// shared holds the transports returned by Shared, keyed on their Config
+	shared = make(map[Config]*http.Transport)
+)
+
+// Shared returns the transport for c, creating it with opts if necessary.  The same *http.Transport is returned
+// for equal Configs so that the IAM calls and service clients share a connection pool.  The transport must not
+// be modified, Clone it first.
+func Shared(c Config, opts ...Opt) (*http.Transport, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	if t, ok := shared[c]; ok {
+		return t, nil
+	}
+
+	t, err := c.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	shared[c] = t
+
+	return t, nil
+}
+
+// New creates a new transport for c
+func (c Config) New(opts ...Opt) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := c.tlsConfig(newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	return t, nil
+}
+
+// tlsConfig creates the TLS config for c
+func (c Config) tlsConfig(o options) (*tls.Config, error) {
+	minVersion := uint16(tls.VersionTLS12)
+	if c.MinTLSVersion != "" {
+		v, ok := tlsVersions[c.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum TLS version %s, must be one of %v", c.MinTLSVersion, TLSVersions())
+		}
+		minVersion = v
+	}
+
+	//nolint:gosec
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.InsecureSkipVerify {
+		o.logger.Warn(o.ctx, "TLS certificate verification is DISABLED, connections to IAM and services are "+
+			"not secure.  This must only be used for testing.", nil)
+	}
+
+	if c.CABundle != "" {
+		pool, err := certPool(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// certPool returns the system cert pool with the certificates in the PEM file caBundle added
+func certPool(caBundle string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("CA bundle doesn't contain any PEM-encoded certificates")
+	}
+
+	return pool, nil
+}
+
+// resourceData is a generic model which implements Get function, e.g. *schema.ResourceData
+type resourceData interface {
+	Get(key string) interface{}
+}
+
+// ConfigFromResourceData returns the Config from the transport fields of the provider schema in d.  The
+// fields may not be present in all models, any that are missing are left unset.
+func ConfigFromResourceData(d resourceData) Config {
+	caBundle, _ := d.Get("ca_bundle").(string)
+	proxy, _ := d.Get("https_proxy").(string)
+	insecureSkipVerify, _ := d.Get("insecure_skip_verify").(bool)
+	minTLSVersion, _ := d.Get("tls_min_version").(string)
+
+	return Config{
+		CABundle:           caBundle,
+		Proxy:              proxy,
+		InsecureSkipVerify: insecureSkipVerify,
+		MinTLSVersion:      minTLSVersion,
+	}
+}
@@ -0,0 +1,179 @@
+// (C) Copyright 2024 Hewlett Packard Enterprise Development LP
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hewlettpackard/hpegl-provider-lib/pkg/token/common"
+)
+
+type testResourceData map[string]interface{}
+
+func (d testResourceData) Get(key string) interface{} {
+	return d[key]
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	badBundle := filepath.Join(t.TempDir(), "bad.pem")
+	require.NoError(t, os.WriteFile(badBundle, []byte("not-pem"), 0o600))
+
+	testcases := []struct {
+		name          string
+		config        Config
+		expMinVersion uint16
+		hasError      bool
+	}{
+		{
+			name:          "default",
+			expMinVersion: tls.VersionTLS12,
+		},
+		{
+			name:          "TLS 1.3",
+			config:        Config{MinTLSVersion: TLSVersion13},
+			expMinVersion: tls.VersionTLS13,
+		},
+		{
+			name:     "invalid TLS version",
+			config:   Config{MinTLSVersion: "1.0"},
+			hasError: true,
+		},
+		{
+			name:     "missing CA bundle",
+			config:   Config{CABundle: "missing.pem"},
+			hasError: true,
+		},
+		{
+			name:     "CA bundle without certificates",
+			config:   Config{CABundle: badBundle},
+			hasError: true,
+		},
+		{
+			name:     "invalid proxy",
+			config:   Config{Proxy: "://proxy"},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tr, err := tc.config.New()
+			if tc.hasError {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expMinVersion, tr.TLSClientConfig.MinVersion)
+			assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+		})
+	}
+}
+
+func TestCABundleAndProxy(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The server certificate isn't trusted without the CA bundle
+	tr, err := Config{}.New()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: tr}).Get(server.URL) //nolint:noctx
+	assert.Error(t, err)
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0o600))
+
+	tr, err = Config{CABundle: caBundle, Proxy: "http://proxy.example.com:8080"}.New()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil) //nolint:noctx
+	require.NoError(t, err)
+	proxyURL, err := tr.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:8080", proxyURL.String())
+
+	// Bypass the proxy to check that the server certificate is trusted
+	tr.Proxy = nil
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL) //nolint:noctx
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestShared(t *testing.T) {
+	t.Parallel()
+	c := Config{MinTLSVersion: TLSVersion13, InsecureSkipVerify: true}
+	t1, err := Shared(c)
+	require.NoError(t, err)
+	assert.True(t, t1.TLSClientConfig.InsecureSkipVerify)
+
+	t2, err := Shared(c)
+	require.NoError(t, err)
+	assert.Same(t, t1, t2)
+
+	t3, err := Shared(Config{MinTLSVersion: TLSVersion13})
+	require.NoError(t, err)
+	assert.NotSame(t, t1, t3)
+}
+
+// recordingLogger records the messages of the warnings logged
+type recordingLogger struct {
+	common.Logger
+	warnings []string
+}
+
+func (r *recordingLogger) Warn(_ context.Context, msg string, _ map[string]interface{}) {
+	r.warnings = append(r.warnings, msg)
+}
+
+func TestInsecureSkipVerifyWarning(t *testing.T) {
+	t.Parallel()
+	logger := &recordingLogger{}
+	_, err := Config{}.New(WithLogger(logger))
+	require.NoError(t, err)
+	assert.Empty(t, logger.warnings)
+
+	tr, err := Config{InsecureSkipVerify: true}.New(WithLogger(logger), WithContext(context.Background()))
+	require.NoError(t, err)
+	assert.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+	require.Len(t, logger.warnings, 1)
+	assert.Contains(t, logger.warnings[0], "TLS certificate verification is DISABLED")
+}
+
+func TestConfigFromResourceData(t *testing.T) {
+	t.Parallel()
+	d := testResourceData{
+		"ca_bundle":            "ca.pem",
+		"https_proxy":          "http://proxy",
+		"insecure_skip_verify": true,
+		"tls_min_version":      TLSVersion13,
+	}
+	assert.Equal(t, Config{
+		CABundle:           "ca.pem",
+		Proxy:              "http://proxy",
+		InsecureSkipVerify: true,
+		MinTLSVersion:      TLSVersion13,
+	}, ConfigFromResourceData(d))
+
+	// Missing fields are left unset
+	assert.Equal(t, Config{}, ConfigFromResourceData(testResourceData{}))
+}